@@ -0,0 +1,104 @@
+package main
+
+import "fuzhu_2/middleware"
+
+// accessPolicy 每个路由的访问级别：public 无需登录；login 已登录即可（含须修改初始密码的用户）；
+// viewer 可查看结果并完成分配给自己的复核；analyst 可上传、评分、维护词典和创建项目；admin 另可管理用户。
+// 项目内的操作另由项目角色（owner、editor、viewer）在处理函数中检查。
+// 新增路由时须在这里登记，启动时会检查，遗漏的路由一律拒绝访问
+var accessPolicy = middleware.Policy{
+	// 静态资源与公开页面
+	"GET /web/*filepath":       middleware.AccessPublic,
+	"HEAD /web/*filepath":      middleware.AccessPublic,
+	"GET /chengshi/*filepath":  middleware.AccessViewer,
+	"HEAD /chengshi/*filepath": middleware.AccessViewer,
+	"GET /":                    middleware.AccessPublic,
+	"GET /about":               middleware.AccessPublic,
+	"GET /login":               middleware.AccessPublic,
+	"GET /reset-password":      middleware.AccessPublic,
+
+	// 登录、登出与密码
+	"POST /api/login":            middleware.AccessPublic,
+	"POST /api/logout":           middleware.AccessPublic,
+	"GET /api/check-status":      middleware.AccessPublic,
+	"GET /api/password/policy":   middleware.AccessPublic,
+	"POST /api/password/reset":   middleware.AccessPublic,
+	"GET /account/password":      middleware.AccessLogin,
+	"POST /api/account/password": middleware.AccessLogin,
+
+	// 个人访问令牌
+	"GET /account/tokens":        middleware.AccessViewer,
+	"GET /api/account/tokens":    middleware.AccessViewer,
+	"POST /api/account/tokens":   middleware.AccessViewer,
+	"DELETE /api/account/tokens": middleware.AccessViewer,
+
+	// 页面
+	"GET /dashboard":     middleware.AccessViewer,
+	"GET /data_analysis": middleware.AccessViewer,
+	"GET /review":        middleware.AccessViewer,
+	"GET /leaderboard":   middleware.AccessViewer,
+	"GET /model-score":   middleware.AccessViewer,
+
+	// 结果下载与进度查询
+	"GET /files/:id":        middleware.AccessViewer,
+	"GET /api/files":        middleware.AccessViewer,
+	"GET /progress":         middleware.AccessViewer,
+	"GET /api/progress":     middleware.AccessViewer,
+	"GET /api/jobs":         middleware.AccessViewer,
+	"POST /api/jobs/cancel": middleware.AccessViewer,
+	"GET /api/quota":        middleware.AccessViewer,
+
+	// 项目、成员与 prompt
+	"GET /projects":                middleware.AccessViewer,
+	"GET /api/projects":            middleware.AccessViewer,
+	"POST /api/projects":           middleware.AccessAnalyst,
+	"DELETE /api/projects":         middleware.AccessViewer,
+	"GET /api/projects/members":    middleware.AccessViewer,
+	"POST /api/projects/members":   middleware.AccessViewer,
+	"DELETE /api/projects/members": middleware.AccessViewer,
+	"GET /api/projects/prompts":    middleware.AccessViewer,
+	"POST /api/projects/prompts":   middleware.AccessViewer,
+	"DELETE /api/projects/prompts": middleware.AccessViewer,
+
+	// 批处理与评分
+	"POST /upload":             middleware.AccessAnalyst,
+	"POST /api/model/score":    middleware.AccessAnalyst,
+	"POST /api/process-excel":  middleware.AccessAnalyst,
+	"POST /api/calculate-acc":  middleware.AccessAnalyst,
+	"POST /api/calculate-ass":  middleware.AccessAnalyst,
+	"POST /api/compare":        middleware.AccessAnalyst,
+	"POST /api/report":         middleware.AccessAnalyst,
+	"POST /api/datasets":       middleware.AccessAnalyst,
+	"GET /api/datasets":        middleware.AccessViewer,
+	"GET /api/eval-runs":       middleware.AccessViewer,
+	"GET /api/leaderboard":     middleware.AccessViewer,
+	"POST /api/reviews":        middleware.AccessAnalyst,
+	"GET /api/reviews":         middleware.AccessViewer,
+	"GET /api/reviews/next":    middleware.AccessViewer,
+	"POST /api/reviews/submit": middleware.AccessViewer,
+
+	// 同义词词典与分词配置
+	"GET /api/dicts":           middleware.AccessViewer,
+	"POST /api/dicts":          middleware.AccessAnalyst,
+	"DELETE /api/dicts":        middleware.AccessAnalyst,
+	"POST /api/dicts/reload":   middleware.AccessAnalyst,
+	"GET /api/seg/profiles":    middleware.AccessViewer,
+	"POST /api/seg/profiles":   middleware.AccessAnalyst,
+	"DELETE /api/seg/profiles": middleware.AccessAnalyst,
+	"POST /api/seg/preview":    middleware.AccessViewer,
+
+	// 用户管理
+	"GET /admin/users":                      middleware.AccessAdmin,
+	"GET /api/admin/users":                  middleware.AccessAdmin,
+	"POST /api/admin/users":                 middleware.AccessAdmin,
+	"POST /api/admin/users/update":          middleware.AccessAdmin,
+	"DELETE /api/admin/users":               middleware.AccessAdmin,
+	"POST /api/admin/users/reset-token":     middleware.AccessAdmin,
+	"POST /api/admin/users/revoke-sessions": middleware.AccessAdmin,
+	"GET /api/admin/quotas":                 middleware.AccessAdmin,
+	"POST /api/admin/quotas":                middleware.AccessAdmin,
+	"DELETE /api/admin/quotas":              middleware.AccessAdmin,
+	"GET /admin/audit":                      middleware.AccessAdmin,
+	"GET /api/admin/audit":                  middleware.AccessAdmin,
+	"GET /api/admin/audit/export":           middleware.AccessAdmin,
+}
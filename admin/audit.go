@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// 审计日志分页与导出的条数限制
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+	maxAuditExportRows   = 100000
+)
+
+// AuditResponse 审计日志接口的响应结构
+type AuditResponse struct {
+	Status   string              `json:"status"`
+	Message  string              `json:"message,omitempty"`
+	Total    int                 `json:"total"`
+	Page     int                 `json:"page"`
+	PageSize int                 `json:"pageSize"`
+	Entries  []models.AuditEntry `json:"entries"`
+}
+
+func writeAuditResponse(w http.ResponseWriter, status int, response AuditResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// recordAudit 以当前用户为操作人写入审计日志
+func recordAudit(r *http.Request, action, target, details string) {
+	audit.Record(r, jobs.OwnerFrom(r.Context()), action, target, details)
+}
+
+// parseAuditTime 解析查询时间，支持 2006-01-02、2006-01-02 15:04:05 与 RFC3339。
+// 只有日期的结束时间包含当天
+func parseAuditTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseAuditFilter 读取查询参数 username、action、target（前缀匹配）、ip、from、to
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Username: strings.TrimSpace(query.Get("username")),
+		Action:   strings.TrimSpace(query.Get("action")),
+		Target:   strings.TrimSpace(query.Get("target")),
+		IP:       strings.TrimSpace(query.Get("ip")),
+	}
+	var err error
+	if filter.From, err = parseAuditTime(strings.TrimSpace(query.Get("from")), false); err != nil {
+		return filter, fmt.Errorf("无效的开始时间: %s", query.Get("from"))
+	}
+	if filter.To, err = parseAuditTime(strings.TrimSpace(query.Get("to")), true); err != nil {
+		return filter, fmt.Errorf("无效的结束时间: %s", query.Get("to"))
+	}
+	return filter, nil
+}
+
+// ListAuditLogs 分页查询审计日志，按时间倒序。筛选参数见 parseAuditFilter，
+// page 从1开始，pageSize 默认50、最多500
+func ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeAuditResponse(w, http.StatusBadRequest, AuditResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize <= 0 {
+		pageSize = defaultAuditPageSize
+	}
+	if pageSize > maxAuditPageSize {
+		pageSize = maxAuditPageSize
+	}
+
+	total, err := models.CountAudit(filter)
+	if err != nil {
+		writeAuditResponse(w, http.StatusInternalServerError, AuditResponse{Status: "error", Message: "查询审计日志失败"})
+		return
+	}
+	entries, err := models.ListAudit(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		writeAuditResponse(w, http.StatusInternalServerError, AuditResponse{Status: "error", Message: "查询审计日志失败"})
+		return
+	}
+	writeAuditResponse(w, http.StatusOK, AuditResponse{
+		Status:   "success",
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+		Entries:  entries,
+	})
+}
+
+// ExportAuditLogs 按与 ListAuditLogs 相同的筛选条件导出 CSV（UTF-8 带 BOM，Excel 可直接打开），
+// 最多导出10万条
+func ExportAuditLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("audit_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write([]byte("\xEF\xBB\xBF"))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"时间", "用户", "操作", "对象", "IP", "详情"})
+	err = models.EachAudit(filter, 0, maxAuditExportRows, func(entry models.AuditEntry) error {
+		return writer.Write([]string{
+			entry.CreatedAt.Format("2006-01-02 15:04:05"),
+			csvCell(entry.Username),
+			csvCell(entry.Action),
+			csvCell(entry.Target),
+			csvCell(entry.IP),
+			csvCell(entry.Details),
+		})
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		// 响应头已发出，只能记录日志
+		log.Printf("导出审计日志失败: %v", err)
+	}
+}
+
+// csvCell 以 = + - @ 制表符或回车开头的内容在表格软件中会被当作公式执行，前面加单引号按文本显示
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
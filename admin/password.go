@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/session"
+)
+
+// maxResetTokenHours 重置令牌的最长有效期（小时）
+const maxResetTokenHours = 7 * 24
+
+// PasswordResponse 密码相关接口的响应结构
+type PasswordResponse struct {
+	Status    string                 `json:"status"`
+	Message   string                 `json:"message,omitempty"`
+	Policy    *models.PasswordPolicy `json:"policy,omitempty"`
+	Rule      string                 `json:"rule,omitempty"`
+	Token     string                 `json:"token,omitempty"`
+	ResetURL  string                 `json:"resetUrl,omitempty"`
+	ExpiresAt *time.Time             `json:"expiresAt,omitempty"`
+	Redirect  string                 `json:"redirect,omitempty"`
+}
+
+func writePasswordResponse(w http.ResponseWriter, status int, response PasswordResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetPasswordPolicy 返回当前的密码策略，供改密和重置页面提示
+func GetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := models.CurrentPasswordPolicy()
+	writePasswordResponse(w, http.StatusOK, PasswordResponse{Status: "success", Policy: &policy, Rule: policy.Describe()})
+}
+
+// ChangePassword 当前用户修改自己的密码，参数 oldPassword、newPassword
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writePasswordResponse(w, http.StatusInternalServerError, PasswordResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+
+	oldPassword := r.FormValue("oldPassword")
+	newPassword := r.FormValue("newPassword")
+	if oldPassword == "" || newPassword == "" {
+		writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: "原密码和新密码不能为空"})
+		return
+	}
+	if !user.CheckPassword(oldPassword) {
+		writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: "原密码错误"})
+		return
+	}
+	if err := user.SetPassword(newPassword, false); err != nil {
+		writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	recordAudit(r, models.AuditPasswordChange, user.Username, "")
+	writePasswordResponse(w, http.StatusOK, PasswordResponse{Status: "success", Message: "密码已修改", Redirect: "/dashboard"})
+}
+
+// IssueResetToken 管理员为用户签发一次性密码重置链接，参数 id、ttlHours（有效期，默认24小时，最长7天）。
+// 令牌只在本次响应中返回，由管理员转交给用户
+func IssueResetToken(w http.ResponseWriter, r *http.Request) {
+	user, status, msg := targetUser(r)
+	if user == nil {
+		writePasswordResponse(w, status, PasswordResponse{Status: "error", Message: msg})
+		return
+	}
+
+	ttlHours := 24
+	if v := strings.TrimSpace(r.FormValue("ttlHours")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxResetTokenHours {
+			writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: "有效期应为1到168小时"})
+			return
+		}
+		ttlHours = n
+	}
+
+	token, expiresAt, err := models.CreateResetToken(user.ID, jobs.OwnerFrom(r.Context()), time.Duration(ttlHours)*time.Hour)
+	if err != nil {
+		writePasswordResponse(w, http.StatusInternalServerError, PasswordResponse{Status: "error", Message: "生成重置链接失败"})
+		return
+	}
+	recordAudit(r, models.AuditResetLink, user.Username, fmt.Sprintf("有效期 %d 小时", ttlHours))
+	writePasswordResponse(w, http.StatusOK, PasswordResponse{
+		Status:    "success",
+		Message:   "已生成重置链接，之前未使用的链接已作废",
+		Token:     token,
+		ResetURL:  "/reset-password?token=" + url.QueryEscape(token),
+		ExpiresAt: &expiresAt,
+	})
+}
+
+// ResetPassword 使用重置令牌设置新密码（无需登录），参数 token、newPassword
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(r.FormValue("token"))
+	newPassword := r.FormValue("newPassword")
+	if token == "" || newPassword == "" {
+		writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: "重置令牌和新密码不能为空"})
+		return
+	}
+
+	user, err := models.ResetPassword(token, newPassword)
+	if err != nil {
+		writePasswordResponse(w, http.StatusBadRequest, PasswordResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	// 密码可能已泄露，重置后原有的会话全部失效
+	session.RevokeUser(user.Username)
+	audit.Record(r, user.Username, models.AuditPasswordReset, user.Username, "通过重置链接")
+	writePasswordResponse(w, http.StatusOK, PasswordResponse{Status: "success", Message: "密码已重置，请重新登录", Redirect: "/login"})
+}
@@ -0,0 +1,144 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/quota"
+)
+
+// QuotaAdminResponse 配额管理接口的响应结构
+type QuotaAdminResponse struct {
+	Status   string                        `json:"status"`
+	Message  string                        `json:"message,omitempty"`
+	Defaults map[string]models.QuotaLimits `json:"defaults,omitempty"` // 按 user、project 的默认配额
+	Quotas   []models.Quota                `json:"quotas,omitempty"`
+	Quota    *models.Quota                 `json:"quota,omitempty"`
+}
+
+func writeQuotaAdminResponse(w http.ResponseWriter, status int, response QuotaAdminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListQuotas 列出默认配额以及为用户、项目单独设置的配额
+func ListQuotas(w http.ResponseWriter, r *http.Request) {
+	quotas, err := models.ListQuotas()
+	if err != nil {
+		writeQuotaAdminResponse(w, http.StatusInternalServerError, QuotaAdminResponse{Status: "error", Message: "查询配额失败"})
+		return
+	}
+	writeQuotaAdminResponse(w, http.StatusOK, QuotaAdminResponse{
+		Status: "success",
+		Defaults: map[string]models.QuotaLimits{
+			models.QuotaScopeUser:    quota.Defaults(models.QuotaScopeUser),
+			models.QuotaScopeProject: quota.Defaults(models.QuotaScopeProject),
+		},
+		Quotas: quotas,
+	})
+}
+
+// SetQuota 为用户或项目单独设置配额。参数 scope（user 或 project），username 或 projectId 指定对象；
+// rows（每月行数）、tokens（每月 token 数）、jobs（同时运行的任务数）、storageMB（存储空间），
+// 0 为不限制，未填写的项使用默认配额
+func SetQuota(w http.ResponseWriter, r *http.Request) {
+	scope, subjectID, status, msg := quotaSubject(r)
+	if subjectID == 0 {
+		writeQuotaAdminResponse(w, status, QuotaAdminResponse{Status: "error", Message: msg})
+		return
+	}
+	limits := quota.Defaults(scope)
+	for _, field := range []struct {
+		name  string
+		scale int64
+		set   func(n int64)
+	}{
+		{"rows", 1, func(n int64) { limits.Rows = n }},
+		{"tokens", 1, func(n int64) { limits.Tokens = n }},
+		{"jobs", 1, func(n int64) { limits.Jobs = int(n) }},
+		{"storageMB", 1 << 20, func(n int64) { limits.StorageBytes = n }},
+	} {
+		value := strings.TrimSpace(r.FormValue(field.name))
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			writeQuotaAdminResponse(w, http.StatusBadRequest, QuotaAdminResponse{Status: "error", Message: fmt.Sprintf("%s 应为非负整数", field.name)})
+			return
+		}
+		field.set(n * field.scale)
+	}
+
+	q := &models.Quota{Scope: scope, SubjectID: subjectID, Limits: limits, UpdatedBy: jobs.OwnerFrom(r.Context())}
+	if err := models.SetQuota(q); err != nil {
+		writeQuotaAdminResponse(w, http.StatusInternalServerError, QuotaAdminResponse{Status: "error", Message: "保存配额失败"})
+		return
+	}
+	recordAudit(r, models.AuditConfigChange, quotaTarget(scope, subjectID), audit.Details(map[string]interface{}{
+		"rows": limits.Rows, "tokens": limits.Tokens, "jobs": limits.Jobs, "storageBytes": limits.StorageBytes,
+	}))
+	writeQuotaAdminResponse(w, http.StatusOK, QuotaAdminResponse{Status: "success", Message: "配额已保存", Quota: q})
+}
+
+// DeleteQuota 删除单独设置的配额，恢复使用默认配额。参数同 SetQuota 的 scope、username 或 projectId
+func DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	scope, subjectID, status, msg := quotaSubject(r)
+	if subjectID == 0 {
+		writeQuotaAdminResponse(w, status, QuotaAdminResponse{Status: "error", Message: msg})
+		return
+	}
+	deleted, err := models.DeleteQuota(scope, subjectID)
+	if err != nil {
+		writeQuotaAdminResponse(w, http.StatusInternalServerError, QuotaAdminResponse{Status: "error", Message: "删除配额失败"})
+		return
+	}
+	if !deleted {
+		writeQuotaAdminResponse(w, http.StatusNotFound, QuotaAdminResponse{Status: "error", Message: "未单独设置配额"})
+		return
+	}
+	recordAudit(r, models.AuditConfigChange, quotaTarget(scope, subjectID), "恢复默认配额")
+	writeQuotaAdminResponse(w, http.StatusOK, QuotaAdminResponse{Status: "success", Message: "已恢复默认配额"})
+}
+
+// quotaSubject 按参数 scope 与 username 或 projectId 查询配额对象。失败时 ID 为0，并返回状态码和提示
+func quotaSubject(r *http.Request) (string, int, int, string) {
+	scope := strings.TrimSpace(r.FormValue("scope"))
+	switch scope {
+	case models.QuotaScopeUser:
+		user, err := models.GetUserByUsername(strings.TrimSpace(r.FormValue("username")))
+		if err != nil {
+			return scope, 0, http.StatusInternalServerError, "查询用户失败"
+		}
+		if user == nil {
+			return scope, 0, http.StatusNotFound, "用户不存在"
+		}
+		return scope, user.ID, http.StatusOK, ""
+	case models.QuotaScopeProject:
+		id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("projectId")))
+		if err != nil {
+			return scope, 0, http.StatusBadRequest, "无效的项目ID"
+		}
+		project, err := models.GetProject(id)
+		if err != nil {
+			return scope, 0, http.StatusInternalServerError, "查询项目失败"
+		}
+		if project == nil {
+			return scope, 0, http.StatusNotFound, "项目不存在"
+		}
+		return scope, project.ID, http.StatusOK, ""
+	}
+	return scope, 0, http.StatusBadRequest, "scope 应为 user 或 project"
+}
+
+// quotaTarget 审计日志中配额的对象标识
+func quotaTarget(scope string, subjectID int) string {
+	return fmt.Sprintf("quota:%s:%d", scope, subjectID)
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// 访问令牌的有效期（天）
+const (
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+// TokenResponse 访问令牌接口的响应结构
+type TokenResponse struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message,omitempty"`
+	Token   string            `json:"token,omitempty"`
+	Info    *models.APIToken  `json:"info,omitempty"`
+	Tokens  []models.APIToken `json:"tokens,omitempty"`
+}
+
+func writeTokenResponse(w http.ResponseWriter, status int, response TokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// currentUser 查询发起请求的用户
+func currentUser(r *http.Request) (*models.User, error) {
+	return models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+}
+
+// ListTokens 列出当前用户的访问令牌
+func ListTokens(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	tokens, err := models.ListAPITokens(user.ID)
+	if err != nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "查询访问令牌失败"})
+		return
+	}
+	writeTokenResponse(w, http.StatusOK, TokenResponse{Status: "success", Tokens: tokens})
+}
+
+// CreateToken 为当前用户创建访问令牌，参数 name、scopes（逗号分隔的 read、write、admin，默认 read）、
+// expiresInDays（默认90天，最长365天）。令牌明文只在本次响应中返回
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 128 {
+		writeTokenResponse(w, http.StatusBadRequest, TokenResponse{Status: "error", Message: "请填写令牌名称（不超过128个字符）"})
+		return
+	}
+
+	scopes := splitScopes(r.FormValue("scopes"))
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeRead}
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			writeTokenResponse(w, http.StatusBadRequest, TokenResponse{Status: "error", Message: "权限范围应为 read、write 或 admin"})
+			return
+		}
+		if scope == models.ScopeAdmin && user.Role != models.RoleAdmin {
+			writeTokenResponse(w, http.StatusForbidden, TokenResponse{Status: "error", Message: "只有管理员可以创建 admin 权限的令牌"})
+			return
+		}
+	}
+
+	days := defaultTokenDays
+	if v := strings.TrimSpace(r.FormValue("expiresInDays")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxTokenDays {
+			writeTokenResponse(w, http.StatusBadRequest, TokenResponse{Status: "error", Message: "有效期应为1到365天"})
+			return
+		}
+		days = n
+	}
+
+	token, info, err := models.CreateAPIToken(user.ID, name, scopes, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "创建访问令牌失败"})
+		return
+	}
+	recordAudit(r, models.AuditTokenCreate, fmt.Sprintf("token:%d", info.ID), audit.Details(map[string]interface{}{
+		"name": name, "scopes": scopes, "expiresInDays": days,
+	}))
+	writeTokenResponse(w, http.StatusOK, TokenResponse{
+		Status:  "success",
+		Message: "令牌已创建，请立即复制保存，之后将无法再次查看",
+		Token:   token,
+		Info:    info,
+	})
+}
+
+// RevokeToken 撤销当前用户的访问令牌，参数 id
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		writeTokenResponse(w, http.StatusBadRequest, TokenResponse{Status: "error", Message: "无效的令牌ID"})
+		return
+	}
+	revoked, err := models.RevokeAPIToken(user.ID, id)
+	if err != nil {
+		writeTokenResponse(w, http.StatusInternalServerError, TokenResponse{Status: "error", Message: "撤销访问令牌失败"})
+		return
+	}
+	if !revoked {
+		writeTokenResponse(w, http.StatusNotFound, TokenResponse{Status: "error", Message: "令牌不存在或已撤销"})
+		return
+	}
+	recordAudit(r, models.AuditTokenRevoke, fmt.Sprintf("token:%d", id), "")
+	writeTokenResponse(w, http.StatusOK, TokenResponse{Status: "success", Message: "令牌已撤销"})
+}
+
+// splitScopes 拆分逗号分隔的权限范围，去掉空白和重复项
+func splitScopes(value string) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" && !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
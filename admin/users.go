@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/session"
+)
+
+// UserResponse 用户管理接口的响应结构
+type UserResponse struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message,omitempty"`
+	User    *models.User  `json:"user,omitempty"`
+	Users   []models.User `json:"users,omitempty"`
+}
+
+func writeUserResponse(w http.ResponseWriter, status int, response UserResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListUsers 列出所有用户
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := models.ListUsers()
+	if err != nil {
+		writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	writeUserResponse(w, http.StatusOK, UserResponse{Status: "success", Users: users})
+}
+
+// CreateUser 创建用户，参数 username、password（初始密码，须符合密码策略，用户首次登录后须修改）、role（默认 viewer）
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	user := &models.User{
+		Username: strings.TrimSpace(r.FormValue("username")),
+		Password: r.FormValue("password"),
+		Role:     strings.TrimSpace(r.FormValue("role")),
+	}
+	if user.Username == "" || user.Password == "" {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "用户名和密码不能为空"})
+		return
+	}
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	if !models.ValidRole(user.Role) {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "角色应为 admin、analyst 或 viewer"})
+		return
+	}
+
+	if err := models.CurrentPasswordPolicy().Validate(user.Username, user.Password); err != nil {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	user.MustChangePassword = true
+
+	existing, err := models.GetUserByUsername(user.Username)
+	if err != nil {
+		writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	if existing != nil {
+		writeUserResponse(w, http.StatusConflict, UserResponse{Status: "error", Message: "用户名已存在"})
+		return
+	}
+	if err := user.Create(); err != nil {
+		writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "创建用户失败"})
+		return
+	}
+	user.Password = ""
+	recordAudit(r, models.AuditUserCreate, user.Username, "角色 "+user.Role)
+	writeUserResponse(w, http.StatusOK, UserResponse{Status: "success", Message: "用户已创建", User: user})
+}
+
+// UpdateUser 修改用户，参数 id；role 修改角色，disabled=true/false 停用或启用。
+// 不能修改自己，也不能停用或降级最后一个可用的管理员
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, status, msg := targetUser(r)
+	if user == nil {
+		writeUserResponse(w, status, UserResponse{Status: "error", Message: msg})
+		return
+	}
+
+	role := strings.TrimSpace(r.FormValue("role"))
+	if role != "" && !models.ValidRole(role) {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "角色应为 admin、analyst 或 viewer"})
+		return
+	}
+	disabledValue := strings.TrimSpace(r.FormValue("disabled"))
+	disabled, err := strconv.ParseBool(disabledValue)
+	if disabledValue != "" && err != nil {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "disabled 应为 true 或 false"})
+		return
+	}
+
+	removesAdmin := user.Role == models.RoleAdmin && !user.Disabled &&
+		((role != "" && role != models.RoleAdmin) || (disabledValue != "" && disabled))
+	if removesAdmin && !otherAdminExists() {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "至少需要保留一个可用的管理员"})
+		return
+	}
+
+	if role != "" {
+		if err := user.SetRole(role); err != nil {
+			writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "修改角色失败"})
+			return
+		}
+	}
+	if disabledValue != "" {
+		if err := user.SetDisabled(disabled); err != nil {
+			writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "修改用户状态失败"})
+			return
+		}
+		if disabled {
+			session.RevokeUser(user.Username)
+		}
+	}
+	recordAudit(r, models.AuditUserUpdate, user.Username, audit.Details(map[string]interface{}{"role": role, "disabled": disabledValue}))
+	writeUserResponse(w, http.StatusOK, UserResponse{Status: "success", Message: "用户已更新", User: user})
+}
+
+// DeleteUser 删除用户，参数 id。不能删除自己和最后一个可用的管理员
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, status, msg := targetUser(r)
+	if user == nil {
+		writeUserResponse(w, status, UserResponse{Status: "error", Message: msg})
+		return
+	}
+	if user.Role == models.RoleAdmin && !user.Disabled && !otherAdminExists() {
+		writeUserResponse(w, http.StatusBadRequest, UserResponse{Status: "error", Message: "至少需要保留一个可用的管理员"})
+		return
+	}
+	if err := user.Delete(); err != nil {
+		writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "删除用户失败"})
+		return
+	}
+	session.RevokeUser(user.Username)
+	recordAudit(r, models.AuditUserDelete, user.Username, "")
+	writeUserResponse(w, http.StatusOK, UserResponse{Status: "success", Message: "用户已删除"})
+}
+
+// RevokeSessions 强制用户下线，删除其全部会话，参数 id。访问令牌不受影响，需由用户撤销或停用账号
+func RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user, status, msg := targetUser(r)
+	if user == nil {
+		writeUserResponse(w, status, UserResponse{Status: "error", Message: msg})
+		return
+	}
+	n, err := session.RevokeUser(user.Username)
+	if err != nil {
+		writeUserResponse(w, http.StatusInternalServerError, UserResponse{Status: "error", Message: "删除会话失败"})
+		return
+	}
+	recordAudit(r, models.AuditSessionsRevoke, user.Username, fmt.Sprintf("%d 个会话", n))
+	writeUserResponse(w, http.StatusOK, UserResponse{Status: "success", Message: fmt.Sprintf("已使用户 %s 的 %d 个会话失效", user.Username, n)})
+}
+
+// targetUser 按参数 id 查询要修改的用户，不允许修改自己。失败时返回状态码和提示
+func targetUser(r *http.Request) (*models.User, int, string) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		return nil, http.StatusBadRequest, "无效的用户ID"
+	}
+	user, err := models.GetUser(id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "查询用户失败"
+	}
+	if user == nil {
+		return nil, http.StatusNotFound, "用户不存在"
+	}
+	if user.Username == jobs.OwnerFrom(r.Context()) {
+		return nil, http.StatusBadRequest, "不能修改或删除自己的账号"
+	}
+	return user, http.StatusOK, ""
+}
+
+// otherAdminExists 除目标用户外是否还有可用的管理员。调用方已排除修改自己的情况，
+// 当前操作者就是一个可用的管理员，这里再确认一次数据库中的人数
+func otherAdminExists() bool {
+	n, err := models.CountActiveAdmins()
+	return err == nil && n > 1
+}
@@ -18,6 +18,7 @@ type APIClient struct {
 	apiKey       string
 	baseURL      string
 	SystemPrompt string
+	OnUsage      func(totalTokens int) // 每次调用成功后回调消耗的 token 数，用于配额计量
 }
 
 // NewAPIClient 创建新的API客户端
@@ -89,6 +90,9 @@ func (c *APIClient) ProcessText(input string) string {
 		return ""
 	}
 
+	if c.OnUsage != nil {
+		c.OnUsage(chatCompletion.Usage.TotalTokens)
+	}
 	output := chatCompletion.Choices[0].Message.Content
 	log.Printf("✅ 处理完成，耗时: %v, 输出长度: %d字符", time.Since(startTime), len(output))
 	return output
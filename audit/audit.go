@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"fuzhu_2/models"
+)
+
+type clientIPKey struct{}
+
+// WithClientIP 在请求上下文中记录客户端 IP，由中间件按可信代理配置解析后设置
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP 读取请求上下文中的客户端 IP，未设置时返回空字符串
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// Record 写入一条审计日志，IP 取自请求上下文。username 为操作人，target 为操作对象
+// （任务ID、文件ID、用户名等），details 为补充说明
+func Record(r *http.Request, username, action, target, details string) {
+	models.RecordAudit(models.AuditEntry{
+		Username: username,
+		Action:   action,
+		Target:   target,
+		IP:       ClientIP(r.Context()),
+		Details:  details,
+	})
+}
+
+// Details 将补充说明的字段编码为 JSON
+func Details(fields map[string]interface{}) string {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvString 读取环境变量，未设置时返回默认值
+func EnvString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// EnvList 读取逗号分隔的环境变量，去掉空白项，未设置时返回 nil
+func EnvList(name string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// EnvInt 读取整数环境变量，未设置或格式错误时返回默认值
+func EnvInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("环境变量 %s=%q 不是整数，使用默认值 %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// EnvDuration 读取时长环境变量（如 30m、12h），未设置或格式错误时返回默认值
+func EnvDuration(name string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("环境变量 %s=%q 不是有效的时长，使用默认值 %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// EnvBool 读取布尔环境变量，未设置或格式错误时返回默认值
+func EnvBool(name string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("环境变量 %s=%q 不是布尔值，使用默认值 %t", name, v, def)
+		return def
+	}
+	return b
+}
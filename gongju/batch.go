@@ -0,0 +1,194 @@
+package gongju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+	"fuzhu_2/quota"
+	"fuzhu_2/storage"
+	"fuzhu_2/utils"
+)
+
+// SubJobResult 批量提交中一个文件或工作表的评分结果
+type SubJobResult struct {
+	JobID      string  `json:"jobId"`
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Message    string  `json:"message,omitempty"`
+	Scored     int     `json:"scored"`
+	Skipped    int     `json:"skipped"`
+	Mean       float64 `json:"mean"`
+	ResultFile string  `json:"resultFile,omitempty"`
+}
+
+// batchResult 一次提交（可能包含多个文件或工作表）的评分结果
+type batchResult struct {
+	scoringResult
+	JobID   string
+	SubJobs []SubJobResult
+}
+
+// scoreSubmission 处理评分接口提交的数据（上传的文件或数据集库中的数据集）：普通数据集直接评分；zip 压缩包中的每个文件、或
+// allSheets=true 时工作簿中的每个工作表，作为同一父任务下的子任务依次评分，
+// 结果文件打包为 zip 返回。参数 projectId 指定任务所属的项目（需要项目 editor 角色）。
+// 出错时同时返回建议的HTTP状态码
+func scoreSubmission(r *http.Request, src *submission, metricName, label string) (*batchResult, int, error) {
+	if _, err := lookupMetric(metricName); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		return nil, status, errors.New(msg)
+	}
+
+	parts, err := utils.ExpandBatch(src.file, src.size, src.filename, parseColumnMapping(r).sheet, formBool(r.FormValue("allSheets")))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer utils.ReleaseBatch(parts)
+
+	owner := jobs.OwnerFrom(r.Context())
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+
+	// 提交前检查提交人和项目的配额，评分的行数计入本月用量
+	meter, err := quota.Begin(owner, projectID)
+	if err != nil {
+		return nil, quota.HTTPStatus(err), err
+	}
+	defer meter.Finish()
+
+	// 单个数据集：与原来一样直接返回结果文件
+	if len(parts) == 1 {
+		job, err := meter.CreateJob(metricName, parts[0].Name)
+		if err != nil {
+			return nil, quota.HTTPStatus(err), err
+		}
+		jobs.SetDataset(job.ID, src.datasetID())
+		jobs.Start(job.ID)
+		jobs.RecordStart(r, job, src.datasetID(), nil)
+		result, status, err := scoreUploadedDataset(r, parts[0], job.ID, fmt.Sprintf("%s_%s", label, timestamp), metricName, label, meter)
+		if err != nil {
+			jobs.Fail(job.ID, err)
+			return nil, status, err
+		}
+		if jobs.Cancelled(job.ID) {
+			return nil, http.StatusConflict, jobs.ErrCancelled
+		}
+		jobs.Succeed(job.ID, result.ResultFile, result)
+		recordEvalRun(r, job.ID, src, evalDatasetName(r, src, parts[0], false), result)
+		return &batchResult{scoringResult: *result, JobID: job.ID}, http.StatusOK, nil
+	}
+
+	parent, err := meter.CreateJob(metricName, src.filename)
+	if err != nil {
+		return nil, quota.HTTPStatus(err), err
+	}
+	jobs.SetDataset(parent.ID, src.datasetID())
+	jobs.Start(parent.ID)
+	jobs.RecordStart(r, parent, src.datasetID(), nil)
+	subJobs := make([]*jobs.Job, len(parts))
+	for i, part := range parts {
+		subJobs[i] = jobs.Create(metricName, part.Name, owner, parent.ID)
+		jobs.SetDataset(subJobs[i].ID, src.datasetID())
+	}
+
+	// 子任务依次执行，单个文件失败不影响其他文件
+	batch := &batchResult{JobID: parent.ID, SubJobs: make([]SubJobResult, len(parts))}
+	resultFiles := make([]string, 0, len(parts))
+	resultNames := make([]string, 0, len(parts))
+	sum := 0.0
+	slices := make([][]SliceSummary, 0, len(parts))
+	for i, part := range parts {
+		sub := SubJobResult{JobID: subJobs[i].ID, Name: part.Name}
+		// 取消父任务后不再评分剩余的文件
+		if jobs.Cancelled(parent.ID) {
+			sub.Status = string(jobs.StatusCancelled)
+			batch.SubJobs[i] = sub
+			continue
+		}
+		jobs.Start(sub.JobID)
+
+		baseName := fmt.Sprintf("%s_%s_%d_%s", label, timestamp, i+1, part.OutputName())
+		result, _, err := scoreUploadedDataset(r, part, sub.JobID, baseName, metricName, label, meter)
+		part.Release()
+		if err != nil {
+			log.Printf("子任务 %s 评分失败: %v", part.Name, err)
+			jobs.Fail(sub.JobID, err)
+			sub.Status = string(jobs.StatusFailed)
+			sub.Message = err.Error()
+			batch.SubJobs[i] = sub
+			continue
+		}
+		jobs.Succeed(sub.JobID, result.ResultFile, result)
+		recordEvalRun(r, sub.JobID, src, evalDatasetName(r, src, part, true), result)
+
+		sub.Status = string(jobs.StatusSucceeded)
+		sub.Scored = result.Scored
+		sub.Skipped = len(result.SkippedRows)
+		sub.Mean = result.Mean
+		sub.ResultFile = result.ResultFile
+		batch.SubJobs[i] = sub
+
+		slices = append(slices, result.Slices)
+		batch.Scored += result.Scored
+		sum += result.Mean * float64(result.Scored)
+		for _, row := range result.SkippedRows {
+			batch.SkippedRows = append(batch.SkippedRows, part.Name+": "+row)
+		}
+		resultFiles = append(resultFiles, result.file.Path)
+		resultNames = append(resultNames, part.OutputName()+filepath.Ext(result.file.Name))
+	}
+
+	if err := meter.Err(); err != nil {
+		return nil, quota.HTTPStatus(err), err
+	}
+	if jobs.Cancelled(parent.ID) {
+		return nil, http.StatusConflict, jobs.ErrCancelled
+	}
+	if len(resultFiles) == 0 {
+		err := fmt.Errorf("所有文件评分均失败，第一个错误: %s: %s", batch.SubJobs[0].Name, batch.SubJobs[0].Message)
+		jobs.Fail(parent.ID, err)
+		return nil, http.StatusBadRequest, err
+	}
+	if batch.Scored > 0 {
+		batch.Mean = sum / float64(batch.Scored)
+	}
+	batch.Slices = mergeSlices(slices...)
+
+	// 所有子任务的结果文件打包下载
+	archive, err := storage.New(owner, parent.ID, storage.KindArchive, fmt.Sprintf("%s_%s.zip", label, timestamp))
+	if err == nil {
+		err = utils.WriteZip(archive.Path, resultFiles, resultNames)
+	}
+	if err == nil {
+		err = storage.Commit(archive)
+	}
+	if err != nil {
+		jobs.Fail(parent.ID, err)
+		return nil, http.StatusInternalServerError, err
+	}
+	batch.ResultFile = storage.URL(archive)
+	jobs.Succeed(parent.ID, batch.ResultFile, batch.SubJobs)
+	return batch, http.StatusOK, nil
+}
+
+// processResponseFrom 将批量评分结果转换为接口响应
+func processResponseFrom(message string, result *batchResult) ProcessResponse {
+	return ProcessResponse{
+		Status:      "success",
+		Message:     message,
+		ResultFile:  result.ResultFile,
+		Scored:      result.Scored,
+		SkippedRows: result.SkippedRows,
+		JobID:       result.JobID,
+		SubJobs:     result.SubJobs,
+		Slices:      result.Slices,
+	}
+}
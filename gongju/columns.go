@@ -0,0 +1,213 @@
+package gongju
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/storage"
+	"fuzhu_2/utils"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// columnMapping 请求中指定的工作表（仅xlsx）和列。列可以用表头名称或列字母（如 B、AA）表示，
+// 表头名称优先匹配
+type columnMapping struct {
+	sheet      string
+	refNames   []string
+	predName   string
+	idName     string
+	delimiter  string
+	sliceNames []string // 分组统计的列
+}
+
+// parseColumnMapping 解析 sheet、refColumns（逗号分隔，默认 A）、predColumn（默认 B）、
+// idColumn（可选）、refDelimiter 与 sliceColumns（可选）参数
+func parseColumnMapping(r *http.Request) columnMapping {
+	m := columnMapping{
+		sheet:      strings.TrimSpace(r.FormValue("sheet")),
+		predName:   strings.TrimSpace(r.FormValue("predColumn")),
+		idName:     strings.TrimSpace(r.FormValue("idColumn")),
+		delimiter:  r.FormValue("refDelimiter"),
+		sliceNames: parseSliceColumns(r),
+	}
+	for _, name := range strings.Split(r.FormValue("refColumns"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			m.refNames = append(m.refNames, name)
+		}
+	}
+	if len(m.refNames) == 0 {
+		m.refNames = []string{"A"}
+	}
+	if m.predName == "" {
+		m.predName = "B"
+	}
+	return m
+}
+
+// uploadedDataset 上传的数据集文件（xlsx/csv/tsv/json/jsonl）。文件按行流式读取，
+// 需要多遍处理时每次从头重新打开
+type uploadedDataset struct {
+	file     io.ReadSeeker
+	filename string
+	opts     utils.DatasetOptions
+	format   string
+	sheet    string
+}
+
+// openUploadedDataset 校验上传文件的格式与工作表，encoding 参数可强制指定文本编码
+func openUploadedDataset(r *http.Request, file io.ReadSeeker, filename, sheet string) (*uploadedDataset, error) {
+	ds := &uploadedDataset{
+		file:     file,
+		filename: filename,
+		opts:     utils.DatasetOptions{Sheet: sheet, Encoding: r.FormValue("encoding")},
+	}
+	reader, err := ds.open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	ds.format, ds.sheet = reader.Format(), reader.Sheet()
+	return ds, nil
+}
+
+// open 从头打开数据集
+func (ds *uploadedDataset) open() (utils.DatasetReader, error) {
+	if _, err := ds.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+	return utils.OpenDatasetReader(ds.file, ds.filename, ds.opts)
+}
+
+// each 从头逐行读取数据集，rowIndex 从0开始（0为表头）
+func (ds *uploadedDataset) each(fn func(rowIndex int, row []string) error) error {
+	reader, err := ds.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for rowIndex := 0; ; rowIndex++ {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(rowIndex, row); err != nil {
+			return err
+		}
+	}
+}
+
+// shape 读取表头，并统计行数与最大列数
+func (ds *uploadedDataset) shape() (headers []string, rowCount, width int, err error) {
+	err = ds.each(func(rowIndex int, row []string) error {
+		if rowIndex == 0 {
+			headers = row
+		}
+		if len(row) > width {
+			width = len(row)
+		}
+		rowCount++
+		return nil
+	})
+	return headers, rowCount, width, err
+}
+
+// resultWriter 在当前用户的任务 jobID 下创建结果文件，outputFormat 参数可指定输出格式，默认与输入格式相同。
+// 返回写入器与文件记录，写入器关闭后须调用 storage.Commit 登记
+func (ds *uploadedDataset) resultWriter(r *http.Request, jobID, baseName string) (utils.DatasetWriter, *models.File, error) {
+	format, err := utils.NormalizeFormat(r.FormValue("outputFormat"), ds.format)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := storage.New(jobs.OwnerFrom(r.Context()), jobID, storage.KindResult, baseName+"."+format)
+	if err != nil {
+		return nil, nil, err
+	}
+	writer, err := utils.CreateDataset(file.Path, format, ds.sheet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return writer, file, nil
+}
+
+// resolveColumn 按表头名称或列字母查找列下标（从0开始），width 为数据的最大列数
+func resolveColumn(headers []string, width int, name string) (int, error) {
+	for i, header := range headers {
+		if strings.TrimSpace(header) == name {
+			return i, nil
+		}
+	}
+	if col, err := excelize.ColumnNameToNumber(strings.ToUpper(name)); err == nil && col <= width {
+		return col - 1, nil
+	}
+	return 0, fmt.Errorf("列 %q 不存在，可用的表头: %s", name, describeHeaders(headers))
+}
+
+// describeHeaders 列出表头及其列字母，用于错误提示
+func describeHeaders(headers []string) string {
+	if len(headers) == 0 {
+		return "（表头为空）"
+	}
+	items := make([]string, len(headers))
+	for i, header := range headers {
+		letter, _ := excelize.ColumnNumberToName(i + 1)
+		items[i] = fmt.Sprintf("%s=%q", letter, strings.TrimSpace(header))
+	}
+	return strings.Join(items, ", ")
+}
+
+// resolve 根据表头行解析出标准答案、预测文本和ID列，width 为数据的最大列数
+func (m columnMapping) resolve(headers []string, width int) (referenceSpec, error) {
+	if width < len(headers) {
+		width = len(headers)
+	}
+	spec := referenceSpec{delimiter: m.delimiter, idCol: -1}
+	for _, name := range m.refNames {
+		col, err := resolveColumn(headers, width, name)
+		if err != nil {
+			return spec, fmt.Errorf("标准答案%v", err)
+		}
+		spec.cols = append(spec.cols, col)
+	}
+
+	predCol, err := resolveColumn(headers, width, m.predName)
+	if err != nil {
+		return spec, fmt.Errorf("预测文本%v", err)
+	}
+	spec.predCol = predCol
+
+	if m.idName != "" {
+		idCol, err := resolveColumn(headers, width, m.idName)
+		if err != nil {
+			return spec, fmt.Errorf("ID%v", err)
+		}
+		spec.idCol = idCol
+	}
+
+	for _, name := range m.sliceNames {
+		col, err := resolveColumn(headers, width, name)
+		if err != nil {
+			return spec, fmt.Errorf("分组%v", err)
+		}
+		spec.sliceCols = append(spec.sliceCols, col)
+	}
+	return spec, nil
+}
+
+// rowID 返回行的标识：有ID列时使用ID，否则使用Excel行号
+func (s referenceSpec) rowID(row []string, rowNum int) string {
+	if s.idCol >= 0 {
+		if id := cellAt(row, s.idCol); id != "" {
+			return id
+		}
+	}
+	return fmt.Sprintf("第%d行", rowNum)
+}
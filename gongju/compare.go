@@ -0,0 +1,246 @@
+package gongju
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+	"fuzhu_2/quota"
+	"fuzhu_2/storage"
+)
+
+// CompareResponse 两个系统对比的响应结构
+type CompareResponse struct {
+	Status       string  `json:"status"`
+	Message      string  `json:"message"`
+	Metric       string  `json:"metric,omitempty"`
+	Rows         int     `json:"rows"`
+	MeanA        float64 `json:"meanA"`
+	MeanB        float64 `json:"meanB"`
+	MeanDiff     float64 `json:"meanDiff"` // B - A
+	CILower      float64 `json:"ciLower"`
+	CIUpper      float64 `json:"ciUpper"`
+	Confidence   float64 `json:"confidence"`
+	PValue       float64 `json:"pValue"`
+	Iterations   int     `json:"iterations"`
+	Permutations int     `json:"permutations"`
+	ResultFile   string  `json:"resultFile,omitempty"`
+}
+
+// bootstrap 重采样次数与置换检验次数的上限，避免单个请求耗尽内存和CPU
+const (
+	maxBootstrapIterations = 10000
+	maxPermutations        = 100000
+)
+
+// formInt 读取整数表单参数，缺失或无效时返回默认值
+func formInt(r *http.Request, key string, def int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(r.FormValue(key)))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// formValueOr 读取表单参数，缺失时返回默认值
+func formValueOr(r *http.Request, key, def string) string {
+	if v := strings.TrimSpace(r.FormValue(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// cellAt 安全读取行中的单元格
+func cellAt(row []string, col int) string {
+	if col < len(row) {
+		return strings.TrimSpace(row[col])
+	}
+	return ""
+}
+
+// writeCompareResponse 写入对比接口的JSON响应
+func writeCompareResponse(w http.ResponseWriter, status int, response CompareResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CompareSystems 在同一份标准答案上对比两个系统的输出：分别计算指标，报告平均差值（B-A）的
+// bootstrap 置信区间与配对置换检验 p 值。对比的行数计入提交人（以及 projectId 指定的项目）的本月用量
+func CompareSystems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: "获取文件失败"})
+		return
+	}
+	defer file.Close()
+
+	metricName := strings.TrimSpace(r.FormValue("metric"))
+	if metricName == "" {
+		metricName = "semantic_f1"
+	}
+	metric, err := lookupMetric(metricName)
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	iterations := formInt(r, "iterations", 1000)
+	permutations := formInt(r, "permutations", 10000)
+	if iterations > maxBootstrapIterations || permutations > maxPermutations {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error",
+			Message: fmt.Sprintf("iterations 不能超过 %d，permutations 不能超过 %d", maxBootstrapIterations, maxPermutations)})
+		return
+	}
+	confidence, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("confidence")), 64)
+	if err != nil || confidence <= 0 || confidence >= 1 {
+		confidence = 0.95
+	}
+	seed, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("seed")), 10, 64)
+	if err != nil {
+		seed = time.Now().UnixNano()
+	}
+
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeCompareResponse(w, status, CompareResponse{Status: "error", Message: msg})
+		return
+	}
+	meter, err := quota.Begin(jobs.OwnerFrom(r.Context()), projectID)
+	if err != nil {
+		writeCompareResponse(w, quota.HTTPStatus(err), CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	defer meter.Finish()
+
+	ctx, err := newScoringContext(r, projectID)
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	ds, err := openUploadedDataset(r, file, header.Filename, strings.TrimSpace(r.FormValue("sheet")))
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	headers, rowCount, width, err := ds.shape()
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	if rowCount < 2 {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: "文件为空或只有表头"})
+		return
+	}
+
+	// 列可以用表头名称或列字母指定，计算前先校验
+	cols := make([]int, 3)
+	for i, param := range [][2]string{{"refColumn", "A"}, {"predColumnA", "B"}, {"predColumnB", "C"}} {
+		col, err := resolveColumn(headers, width, formValueOr(r, param[0], param[1]))
+		if err != nil {
+			writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: fmt.Sprintf("参数 %s: %v", param[0], err)})
+			return
+		}
+		cols[i] = col
+	}
+	refCol, colA, colB := cols[0], cols[1], cols[2]
+
+	// 跳过表头与标准答案为空的行
+	refs := make([]string, 0, rowCount)
+	predsA := make([]string, 0, rowCount)
+	predsB := make([]string, 0, rowCount)
+	err = ds.each(func(i int, row []string) error {
+		ref := cellAt(row, refCol)
+		if i == 0 || ref == "" {
+			return nil
+		}
+		refs = append(refs, ref)
+		predsA = append(predsA, cellAt(row, colA))
+		predsB = append(predsB, cellAt(row, colB))
+		return nil
+	})
+	if err != nil {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	if len(refs) == 0 {
+		writeCompareResponse(w, http.StatusBadRequest, CompareResponse{Status: "error", Message: "文件中没有有效数据"})
+		return
+	}
+	if err := meter.AddRows(len(refs)); err != nil {
+		writeCompareResponse(w, quota.HTTPStatus(err), CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	scoresA, err := metric(ctx, refs, predsA)
+	if err != nil {
+		writeCompareResponse(w, http.StatusInternalServerError, CompareResponse{Status: "error", Message: fmt.Sprintf("计算系统A的指标失败: %v", err)})
+		return
+	}
+	scoresB, err := metric(ctx, refs, predsB)
+	if err != nil {
+		writeCompareResponse(w, http.StatusInternalServerError, CompareResponse{Status: "error", Message: fmt.Sprintf("计算系统B的指标失败: %v", err)})
+		return
+	}
+
+	diffs := make([]float64, len(refs))
+	for i := range refs {
+		diffs[i] = scoresB[i] - scoresA[i]
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	ciLower, ciUpper := bootstrapMeanCI(diffs, iterations, confidence, rng)
+	pValue := pairedPermutationTest(diffs, permutations, rng)
+
+	// 输出逐行结果
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	writer, resultFile, err := ds.resultWriter(r, "", fmt.Sprintf("系统对比_%s_%s", metricName, timestamp))
+	if err != nil {
+		writeCompareResponse(w, http.StatusInternalServerError, CompareResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	err = writer.WriteRow([]interface{}{"标准答案", "系统A输出", "系统B输出", "系统A分数", "系统B分数", "差值(B-A)"})
+	for i := 0; err == nil && i < len(refs); i++ {
+		err = writer.WriteRow([]interface{}{refs[i], predsA[i], predsB[i], scoresA[i], scoresB[i], diffs[i]})
+	}
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = storage.Commit(resultFile)
+	}
+	if err != nil {
+		writeCompareResponse(w, http.StatusInternalServerError, CompareResponse{Status: "error", Message: "保存结果文件失败"})
+		return
+	}
+
+	writeCompareResponse(w, http.StatusOK, CompareResponse{
+		Status:       "success",
+		Message:      "对比完成",
+		Metric:       metricName,
+		Rows:         len(refs),
+		MeanA:        mean(scoresA),
+		MeanB:        mean(scoresB),
+		MeanDiff:     mean(diffs),
+		CILower:      ciLower,
+		CIUpper:      ciUpper,
+		Confidence:   confidence,
+		PValue:       pValue,
+		Iterations:   iterations,
+		Permutations: permutations,
+		ResultFile:   storage.URL(resultFile),
+	})
+}
@@ -0,0 +1,320 @@
+package gongju
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+	"fuzhu_2/quota"
+	"fuzhu_2/utils"
+)
+
+// datasetDir 数据集库的文件目录，不在 uploads 中，不会被定期清理。文件按内容哈希命名，
+// 内容相同的数据集共用一个文件
+const datasetDir = "datasets"
+
+// DatasetResponse 数据集接口的响应结构
+type DatasetResponse struct {
+	Status   string           `json:"status"`
+	Message  string           `json:"message,omitempty"`
+	Dataset  *models.Dataset  `json:"dataset,omitempty"`
+	Datasets []models.Dataset `json:"datasets,omitempty"`
+	Existing bool             `json:"existing,omitempty"` // 同名数据集已有相同内容的版本，未新建版本
+}
+
+func writeDatasetResponse(w http.ResponseWriter, status int, response DatasetResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegisterDataset 上传数据集到数据集库。参数 file 为数据集文件（不支持 zip），name 为数据集名称
+// （默认为文件名），sheet、encoding 用于读取表头与行数，description 为说明，
+// projectId 登记到项目中（需要项目 editor 角色），不指定时登记到公共数据集库。
+// 同名数据集内容未变化时返回已有版本，否则新建一个版本
+func RegisterDataset(w http.ResponseWriter, r *http.Request) {
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeDatasetResponse(w, status, DatasetResponse{Status: "error", Message: msg})
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeDatasetResponse(w, http.StatusBadRequest, DatasetResponse{Status: "error", Message: "获取文件失败"})
+		return
+	}
+	defer file.Close()
+	if err := quota.CheckStorage(jobs.OwnerFrom(r.Context()), projectID, header.Size); err != nil {
+		writeDatasetResponse(w, quota.HTTPStatus(err), DatasetResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	format, err := utils.DetectFormat(header.Filename)
+	if err != nil {
+		writeDatasetResponse(w, http.StatusBadRequest, DatasetResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	name := formValueOr(r, "name", strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)))
+
+	hash, storagePath, err := storeDatasetFile(file, filepath.Ext(header.Filename))
+	if err != nil {
+		log.Printf("保存数据集文件失败: %v", err)
+		writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "保存数据集文件失败"})
+		return
+	}
+
+	existing, err := models.FindDatasetByHash(projectID, name, hash)
+	if err != nil {
+		discardDatasetFile(storagePath)
+		writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "查询数据集失败"})
+		return
+	}
+	if existing != nil {
+		writeDatasetResponse(w, http.StatusOK, DatasetResponse{
+			Status:   "success",
+			Message:  fmt.Sprintf("内容与 %s 相同，未新建版本", existing.Label()),
+			Dataset:  existing,
+			Existing: true,
+		})
+		return
+	}
+
+	dataset := &models.Dataset{
+		Name:        name,
+		ContentHash: hash,
+		Filename:    header.Filename,
+		Format:      format,
+		Size:        header.Size,
+		StoragePath: storagePath,
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Owner:       jobs.OwnerFrom(r.Context()),
+		ProjectID:   projectID,
+	}
+	opts := utils.DatasetOptions{Sheet: strings.TrimSpace(r.FormValue("sheet")), Encoding: r.FormValue("encoding")}
+	if err := describeDataset(dataset, opts); err != nil {
+		discardDatasetFile(storagePath)
+		writeDatasetResponse(w, http.StatusBadRequest, DatasetResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	if err := dataset.Create(); err != nil {
+		discardDatasetFile(storagePath)
+		writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "保存数据集失败"})
+		return
+	}
+	log.Printf("数据集 %s 已登记，哈希 %s，%d 行", dataset.Label(), hash, dataset.RowCount)
+	audit.Record(r, dataset.Owner, models.AuditUpload, fmt.Sprintf("dataset:%d", dataset.ID),
+		audit.Details(map[string]interface{}{"name": dataset.Label(), "size": dataset.Size, "sha256": hash, "projectId": projectID}))
+	writeDatasetResponse(w, http.StatusOK, DatasetResponse{Status: "success", Message: "数据集已登记为 " + dataset.Label(), Dataset: dataset})
+}
+
+// storeDatasetFile 边保存边计算 SHA-256，文件保存为 datasets/<哈希><扩展名>
+func storeDatasetFile(src multipart.File, ext string) (string, string, error) {
+	if err := os.MkdirAll(datasetDir, 0755); err != nil {
+		return "", "", err
+	}
+	tmp, err := os.CreateTemp(datasetDir, ".upload-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), src); err != nil {
+		tmp.Close()
+		return "", "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	path := filepath.Join(datasetDir, hash+strings.ToLower(ext))
+	if _, err := os.Stat(path); err == nil {
+		return hash, path, nil
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", err
+	}
+	return hash, path, nil
+}
+
+// discardDatasetFile 登记失败时删除保存的数据集文件，已有其他数据集引用该文件时保留
+func discardDatasetFile(path string) {
+	inUse, err := models.DatasetFileInUse(path)
+	if err != nil || inUse {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除数据集文件 %s 失败: %v", path, err)
+	}
+}
+
+// describeDataset 读取数据集的表头与数据行数
+func describeDataset(dataset *models.Dataset, opts utils.DatasetOptions) error {
+	file, err := os.Open(dataset.StoragePath)
+	if err != nil {
+		return fmt.Errorf("打开数据集文件失败: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := utils.OpenDatasetReader(file, dataset.Filename, opts)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	dataset.Sheet = reader.Sheet()
+
+	for i := 0; ; i++ {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("读取数据集失败: %v", err)
+		}
+		if i == 0 {
+			dataset.Columns = row
+			continue
+		}
+		dataset.RowCount++
+	}
+	if dataset.Columns == nil {
+		return fmt.Errorf("文件 %q 为空", dataset.Filename)
+	}
+	return nil
+}
+
+// ListDatasets 查询数据集库：带 id 参数时返回该版本，否则列出可访问的所有数据集版本（公共数据集库
+// 和所属项目中的数据集，管理员可看到全部），可按 name 过滤，带 projectId 参数时只列出该项目中的数据集
+func ListDatasets(w http.ResponseWriter, r *http.Request) {
+	if id := strings.TrimSpace(r.FormValue("id")); id != "" {
+		dataset, err := lookupDataset(r, id)
+		if err != nil {
+			writeDatasetResponse(w, http.StatusNotFound, DatasetResponse{Status: "error", Message: err.Error()})
+			return
+		}
+		writeDatasetResponse(w, http.StatusOK, DatasetResponse{Status: "success", Dataset: dataset})
+		return
+	}
+
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleViewer)
+	if status != http.StatusOK {
+		writeDatasetResponse(w, status, DatasetResponse{Status: "error", Message: msg})
+		return
+	}
+	user, err := models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+	if err != nil || user == nil {
+		writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	var visible []int
+	if user.Role != models.RoleAdmin {
+		if visible, err = models.ProjectIDs(user.ID); err != nil {
+			writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "查询数据集失败"})
+			return
+		}
+	}
+	datasets, err := models.ListDatasets(strings.TrimSpace(r.FormValue("name")), visible)
+	if err != nil {
+		writeDatasetResponse(w, http.StatusInternalServerError, DatasetResponse{Status: "error", Message: "查询数据集失败"})
+		return
+	}
+	if projectID > 0 {
+		filtered := datasets[:0]
+		for _, d := range datasets {
+			if d.ProjectID == projectID {
+				filtered = append(filtered, d)
+			}
+		}
+		datasets = filtered
+	}
+	writeDatasetResponse(w, http.StatusOK, DatasetResponse{Status: "success", Datasets: datasets})
+}
+
+// lookupDataset 按 datasetId 参数查询数据集。项目中的数据集只有项目成员可以使用
+func lookupDataset(r *http.Request, id string) (*models.Dataset, error) {
+	datasetID, err := strconv.Atoi(strings.TrimSpace(id))
+	if err != nil || datasetID <= 0 {
+		return nil, fmt.Errorf("无效的数据集ID: %s", id)
+	}
+	dataset, err := models.GetDataset(datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("查询数据集失败: %v", err)
+	}
+	if dataset == nil || (dataset.ProjectID > 0 &&
+		!models.CanAccessProject(jobs.OwnerFrom(r.Context()), dataset.ProjectID, models.ProjectRoleViewer)) {
+		return nil, fmt.Errorf("数据集 %d 不存在", datasetID)
+	}
+	return dataset, nil
+}
+
+// OpenRegisteredDataset 按 datasetId 参数打开数据集库中的文件，调用方负责关闭文件
+func OpenRegisteredDataset(r *http.Request, id string) (*models.Dataset, *os.File, error) {
+	dataset, err := lookupDataset(r, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(dataset.StoragePath)
+	if err != nil {
+		log.Printf("打开数据集 %s 的文件失败: %v", dataset.Label(), err)
+		return nil, nil, fmt.Errorf("数据集 %s 的文件不存在", dataset.Label())
+	}
+	return dataset, file, nil
+}
+
+// submission 评分接口提交的数据：上传的文件，或通过 datasetId 引用的数据集库中的数据集
+type submission struct {
+	file     utils.BatchSource
+	closer   io.Closer
+	filename string
+	size     int64
+	dataset  *models.Dataset // 使用数据集库时不为空
+}
+
+// openSubmission 打开评分接口提交的数据，有 datasetId 参数时使用数据集库，否则读取上传的 file
+func openSubmission(r *http.Request) (*submission, error) {
+	if id := strings.TrimSpace(r.FormValue("datasetId")); id != "" {
+		dataset, file, err := OpenRegisteredDataset(r, id)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("读取数据集文件失败: %v", err)
+		}
+		return &submission{file: file, closer: file, filename: dataset.Filename, size: info.Size(), dataset: dataset}, nil
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("获取文件失败")
+	}
+	return &submission{file: file, closer: file, filename: header.Filename, size: header.Size}, nil
+}
+
+// Close 关闭提交的文件
+func (s *submission) Close() error {
+	return s.closer.Close()
+}
+
+// datasetID 返回数据集库中的数据集ID，上传文件时为0
+func (s *submission) datasetID() int {
+	if s.dataset == nil {
+		return 0
+	}
+	return s.dataset.ID
+}
@@ -0,0 +1,237 @@
+package gongju
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+	"fuzhu_2/storage"
+	"fuzhu_2/utils"
+)
+
+// defaultModelName 未填写模型名称时使用的名称
+const defaultModelName = "未指定"
+
+// maxEvalRunsLimit 运行记录查询一次最多返回的条数
+const maxEvalRunsLimit = 500
+
+// EvalRunsResponse 运行记录查询的响应结构
+type EvalRunsResponse struct {
+	Status  string           `json:"status"`
+	Message string           `json:"message,omitempty"`
+	Runs    []models.EvalRun `json:"runs,omitempty"`
+}
+
+// LeaderboardResponse 排行榜的响应结构，Datasets 为有运行记录的数据集及其指标
+type LeaderboardResponse struct {
+	Status   string                    `json:"status"`
+	Message  string                    `json:"message,omitempty"`
+	Dataset  string                    `json:"dataset,omitempty"`
+	Metric   string                    `json:"metric,omitempty"`
+	Profile  string                    `json:"profile,omitempty"`
+	Entries  []models.LeaderboardEntry `json:"entries,omitempty"`
+	Datasets map[string][]string       `json:"datasets"`
+}
+
+// metricProfile 返回指标配置的描述，未填写 metricProfile 时由项目 projectID 的分词配置、词典和过滤规则生成，
+// 配置不同的运行在排行榜上可以区分
+func metricProfile(r *http.Request, metricName string, projectID int) string {
+	if profile := strings.TrimSpace(r.FormValue("metricProfile")); profile != "" {
+		return profile
+	}
+	parts := []string{metricName}
+	if hasSegProfile(projectID) {
+		parts = append(parts, fmt.Sprintf("seg=%d", projectID))
+	}
+	if dicts := strings.TrimSpace(r.FormValue("dicts")); dicts != "" {
+		parts = append(parts, "dicts="+dicts)
+	}
+	if formBool(r.FormValue("excludeStop")) {
+		parts = append(parts, "excludeStop")
+	}
+	if formBool(r.FormValue("excludePunct")) {
+		parts = append(parts, "excludePunct")
+	}
+	return strings.Join(parts, ";")
+}
+
+// evalDatasetName 返回运行记录的数据集名称：默认为数据集库中的 名称@v版本，上传文件时为文件名
+// （多工作表时含工作表名）；指定了名称的批量提交中，每个文件或工作表记为 名称/文件名
+func evalDatasetName(r *http.Request, src *submission, part utils.BatchPart, batch bool) string {
+	name := strings.TrimSpace(r.FormValue("datasetName"))
+	if name == "" && src.dataset != nil {
+		name = src.dataset.Label()
+	}
+	switch {
+	case name == "":
+		return part.Name
+	case batch:
+		return name + "/" + part.Name
+	default:
+		return name
+	}
+}
+
+// recordEvalRun 将一次（子）任务的评分结果保存为运行记录。参数 modelName、promptVersion、
+// metricProfile 来自请求。保存失败只记录日志，不影响评分结果的返回
+func recordEvalRun(r *http.Request, jobID string, src *submission, dataset string, result *scoringResult) {
+
+	scores := make(map[string]float64, len(result.ScoreColumns))
+	for i, column := range result.ScoreColumns {
+		if i < len(result.ScoreMeans) {
+			scores[column] = result.ScoreMeans[i]
+		}
+	}
+	slices, err := json.Marshal(result.Slices)
+	if err != nil {
+		log.Printf("序列化分组统计失败: %v", err)
+		slices = nil
+	}
+
+	run := &models.EvalRun{
+		JobID:         jobID,
+		Owner:         jobs.OwnerFrom(r.Context()),
+		ModelName:     formValueOr(r, "modelName", defaultModelName),
+		Dataset:       dataset,
+		DatasetID:     src.datasetID(),
+		PromptVersion: strings.TrimSpace(r.FormValue("promptVersion")),
+		Metric:        result.Metric,
+		Rows:          result.Scored,
+		Mean:          result.Mean,
+		Scores:        scores,
+		Slices:        slices,
+	}
+	if result.file != nil {
+		run.ResultFileID = result.file.ID
+	}
+	if job, _, ok := jobs.Get(jobID); ok {
+		run.ProjectID = job.ProjectID
+	}
+	run.MetricProfile = metricProfile(r, result.Metric, run.ProjectID)
+	if err := run.Create(); err != nil {
+		log.Printf("任务 %s 的运行记录保存失败: %v", jobID, err)
+	}
+}
+
+// visibleProjects 返回当前用户所属的项目，管理员返回 nil 表示不限
+func visibleProjects(r *http.Request) ([]int, error) {
+	user, err := models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("用户不存在")
+	}
+	if user.Role == models.RoleAdmin {
+		return nil, nil
+	}
+	return models.ProjectIDs(user.ID)
+}
+
+// ListEvalRuns 查询评分运行记录，可按 dataset、model、metric、profile 过滤，mine=true 时只看自己的运行，
+// projectId 只看该项目的运行，limit 为返回条数（默认100）。只返回个人任务和当前用户所属项目的运行
+func ListEvalRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleViewer)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(EvalRunsResponse{Status: "error", Message: msg})
+		return
+	}
+	visible, err := visibleProjects(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(EvalRunsResponse{Status: "error", Message: "查询运行记录失败"})
+		return
+	}
+	filter := models.EvalRunFilter{
+		Dataset:   strings.TrimSpace(r.FormValue("dataset")),
+		Model:     strings.TrimSpace(r.FormValue("model")),
+		Metric:    strings.TrimSpace(r.FormValue("metric")),
+		Profile:   strings.TrimSpace(r.FormValue("profile")),
+		ProjectID: projectID,
+		Visible:   visible,
+		Limit:     100,
+	}
+	if formBool(r.FormValue("mine")) {
+		filter.Owner = jobs.OwnerFrom(r.Context())
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 {
+		filter.Limit = min(limit, maxEvalRunsLimit)
+	}
+
+	runs, err := models.ListEvalRuns(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(EvalRunsResponse{Status: "error", Message: "查询运行记录失败"})
+		return
+	}
+	// 下载链接在读取时签名，不会随记录一起过期
+	for i := range runs {
+		if runs[i].ResultFileID != "" {
+			runs[i].ResultFile = storage.URLForID(runs[i].ResultFileID)
+		}
+	}
+	json.NewEncoder(w).Encode(EvalRunsResponse{Status: "success", Runs: runs})
+}
+
+// defaultLeaderboardMetric 返回排行榜默认的指标：数据集有 semantic_f1 的运行时用它（与评分接口的默认指标一致），
+// 否则用数据集的第一个指标
+func defaultLeaderboardMetric(metrics []string) string {
+	for _, metric := range metrics {
+		if metric == "semantic_f1" {
+			return metric
+		}
+	}
+	if len(metrics) > 0 {
+		return metrics[0]
+	}
+	return "semantic_f1"
+}
+
+// GetLeaderboard 返回某个数据集在某个指标上的模型排行榜，参数 dataset、metric（默认为该数据集的
+// semantic_f1，没有时为其第一个指标）、profile（只看该指标配置）。同一模型在不同指标配置下分别排名。
+// 未指定数据集时只返回可选的数据集列表。只统计个人任务和当前用户所属项目的运行
+func GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	visible, err := visibleProjects(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(LeaderboardResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	datasets, err := models.ListEvalDatasets(visible)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(LeaderboardResponse{Status: "error", Message: "查询数据集失败"})
+		return
+	}
+
+	response := LeaderboardResponse{
+		Status:   "success",
+		Dataset:  strings.TrimSpace(r.FormValue("dataset")),
+		Metric:   strings.TrimSpace(r.FormValue("metric")),
+		Profile:  strings.TrimSpace(r.FormValue("profile")),
+		Datasets: datasets,
+	}
+	if response.Dataset == "" {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if response.Metric == "" {
+		response.Metric = defaultLeaderboardMetric(datasets[response.Dataset])
+	}
+	response.Entries, err = models.Leaderboard(response.Dataset, response.Metric, response.Profile, visible)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(LeaderboardResponse{Status: "error", Message: "查询排行榜失败"})
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
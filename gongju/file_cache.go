@@ -0,0 +1,83 @@
+package gongju
+
+import (
+	"sync"
+	"time"
+)
+
+// fileCache 缓存由配置文件加载的对象（合并后的同义词词典、项目分词器），按依赖文件的修改时间判断是否过期。
+// 加载在锁外进行，同一个 key 同时只加载一次，其他请求等待加载结果；超过容量时淘汰最久未使用的项
+type fileCache struct {
+	capacity int
+	mu       sync.Mutex
+	entries  map[string]*fileCacheEntry
+}
+
+// fileCacheEntry 缓存项，ready 关闭后 value 与 err 可读
+type fileCacheEntry struct {
+	mtimes   map[string]time.Time
+	value    interface{}
+	err      error
+	ready    chan struct{}
+	lastUsed time.Time
+}
+
+// newFileCache 创建最多保存 capacity 项的缓存
+func newFileCache(capacity int) *fileCache {
+	return &fileCache{capacity: capacity, entries: make(map[string]*fileCacheEntry)}
+}
+
+// get 返回 key 对应的对象，缓存不存在或依赖文件的修改时间与 mtimes 不一致时调用 load 加载。
+// 加载失败的结果不缓存，下一次请求重新加载
+func (c *fileCache) get(key string, mtimes map[string]time.Time, load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && sameMTimes(entry.mtimes, mtimes) {
+		entry.lastUsed = time.Now()
+		c.mu.Unlock()
+		<-entry.ready
+		return entry.value, entry.err
+	}
+
+	entry = &fileCacheEntry{mtimes: mtimes, ready: make(chan struct{}), lastUsed: time.Now()}
+	c.entries[key] = entry
+	c.evictLocked()
+	c.mu.Unlock()
+
+	entry.value, entry.err = load()
+	close(entry.ready)
+	if entry.err != nil {
+		c.remove(key, entry)
+	}
+	return entry.value, entry.err
+}
+
+// evictLocked 淘汰最久未使用的项直到不超过容量，调用方需持有锁
+func (c *fileCache) evictLocked() {
+	for len(c.entries) > c.capacity {
+		oldestKey := ""
+		var oldest time.Time
+		for key, entry := range c.entries {
+			if oldestKey == "" || entry.lastUsed.Before(oldest) {
+				oldestKey, oldest = key, entry.lastUsed
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+}
+
+// remove 删除 key 对应的缓存项，entry 不为 nil 时只在缓存项仍是 entry 时删除
+func (c *fileCache) remove(key string, entry *fileCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, ok := c.entries[key]; ok && (entry == nil || current == entry) {
+		delete(c.entries, key)
+	}
+}
+
+// clear 清空缓存
+func (c *fileCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*fileCacheEntry)
+}
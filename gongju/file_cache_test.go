@@ -0,0 +1,84 @@
+package gongju
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFileCacheLoadsOnce 并发请求同一个 key 时只加载一次，修改时间变化后重新加载
+func TestFileCacheLoadsOnce(t *testing.T) {
+	cache := newFileCache(4)
+	mtimes := map[string]time.Time{"a.txt": time.Unix(1, 0)}
+	var loads int32
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := cache.get("a", mtimes, load); err != nil || v != "value" {
+				t.Errorf("get 返回 %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("并发请求加载了 %d 次，应为1次", loads)
+	}
+
+	cache.get("a", map[string]time.Time{"a.txt": time.Unix(2, 0)}, load)
+	if loads != 2 {
+		t.Fatalf("修改时间变化后加载了 %d 次，应为2次", loads)
+	}
+}
+
+// TestFileCacheEviction 超过容量时淘汰最久未使用的项，加载失败的结果不缓存
+func TestFileCacheEviction(t *testing.T) {
+	cache := newFileCache(2)
+	mtimes := map[string]time.Time{}
+	loaded := make(map[string]int)
+	load := func(key string) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			loaded[key]++
+			return key, nil
+		}
+	}
+
+	cache.get("a", mtimes, load("a"))
+	time.Sleep(time.Millisecond)
+	cache.get("b", mtimes, load("b"))
+	time.Sleep(time.Millisecond)
+	cache.get("a", mtimes, load("a"))
+	time.Sleep(time.Millisecond)
+	cache.get("c", mtimes, load("c"))
+	if len(cache.entries) != 2 {
+		t.Fatalf("缓存了 %d 项，应为2项", len(cache.entries))
+	}
+	cache.get("a", mtimes, load("a"))
+	cache.get("b", mtimes, load("b"))
+	if loaded["a"] != 1 || loaded["b"] != 2 {
+		t.Fatalf("加载次数 %v，应淘汰最久未使用的 b", loaded)
+	}
+
+	failures := 0
+	fail := func() (interface{}, error) {
+		failures++
+		return nil, errors.New("加载失败")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.get("bad", mtimes, fail); err == nil {
+			t.Fatal("加载失败时应返回错误")
+		}
+	}
+	if failures != 2 {
+		t.Fatalf("加载失败后应重新加载，实际加载 %d 次", failures)
+	}
+}
@@ -0,0 +1,160 @@
+package gongju
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ego/gse"
+)
+
+// scoringContext 一次评分请求共享的配置：分词器、同义词词典、分词过滤规则和并发数
+type scoringContext struct {
+	seg     *gse.Segmenter
+	dict    *SynonymDict
+	filter  tokenFilter
+	workers int
+}
+
+// metricFunc 批量计算指标，refs 与 preds 一一对应，返回每一对的分数
+type metricFunc func(ctx *scoringContext, refs, preds []string) ([]float64, error)
+
+// metricRegistry 支持的评分指标
+var metricRegistry = map[string]metricFunc{
+	"semantic_f1": similarityMetric(func(s TextSimilarity) float64 { return s.SemanticF1 }),
+	"f1":          similarityMetric(func(s TextSimilarity) float64 { return s.F1 }),
+	"precision":   similarityMetric(func(s TextSimilarity) float64 { return s.Precision }),
+	"recall":      similarityMetric(func(s TextSimilarity) float64 { return s.Recall }),
+	"position_f1": similarityMetric(func(s TextSimilarity) float64 { return s.PositionAwareF1 }),
+	"acc":         accMetric,
+	"ass":         assMetric,
+}
+
+// newScoringContext 根据任务所属的项目 projectID 以及请求参数（dicts、excludeStop、excludePunct、workers）
+// 创建评分配置，项目上传了分词配置时使用项目的分词器与停用词表
+func newScoringContext(r *http.Request, projectID int) (*scoringContext, error) {
+	seg, stopWords, err := segmenterFor(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("加载分词配置失败: %v", err)
+	}
+	dictIDs := parseDictIDs(r.FormValue("dicts"))
+	if err := checkDictAccess(r, dictIDs); err != nil {
+		return nil, fmt.Errorf("加载自定义词典失败: %v", err)
+	}
+	dict, err := synonymDictFor(dictIDs)
+	if err != nil {
+		return nil, fmt.Errorf("加载自定义词典失败: %v", err)
+	}
+	return &scoringContext{
+		seg:     seg,
+		dict:    dict,
+		filter:  newTokenFilter(stopWords, r),
+		workers: scoringWorkers(r),
+	}, nil
+}
+
+// metricNames 返回所有支持的指标名称
+func metricNames() []string {
+	names := make([]string, 0, len(metricRegistry))
+	for name := range metricRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupMetric 查找指标，不存在时返回列出可选指标的错误
+func lookupMetric(name string) (metricFunc, error) {
+	metric, ok := metricRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的指标 %q，可选: %s", name, strings.Join(metricNames(), ", "))
+	}
+	return metric, nil
+}
+
+// similarityMetric 基于语义F1计算结果的指标
+func similarityMetric(pick func(TextSimilarity) float64) metricFunc {
+	return func(ctx *scoringContext, refs, preds []string) ([]float64, error) {
+		scores := make([]float64, len(refs))
+		parallelFor(len(refs), ctx.workers, func(i int) {
+			similarity := calculateSemanticF1(strings.TrimSpace(refs[i]), strings.TrimSpace(preds[i]), ctx.seg, ctx.dict, ctx.filter)
+			scores[i] = pick(similarity)
+		})
+		return scores, nil
+	}
+}
+
+// accMetric 精确匹配指标
+func accMetric(ctx *scoringContext, refs, preds []string) ([]float64, error) {
+	scores := make([]float64, len(refs))
+	for i := range refs {
+		scores[i] = calculateACC([]string{refs[i]}, []string{preds[i]})
+	}
+	return scores, nil
+}
+
+// assServiceURL 返回Python语义相似度服务地址，可通过环境变量 ASS_SERVICE_URL 配置
+func assServiceURL() string {
+	if url := strings.TrimSpace(os.Getenv("ASS_SERVICE_URL")); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:5001"
+}
+
+// assBatchSize 每次请求Python服务的文本对数量
+const assBatchSize = 256
+
+// assMetric 调用Python服务批量计算向量余弦相似度
+func assMetric(ctx *scoringContext, refs, preds []string) ([]float64, error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	scores := make([]float64, 0, len(refs))
+
+	for start := 0; start < len(refs); start += assBatchSize {
+		end := start + assBatchSize
+		if end > len(refs) {
+			end = len(refs)
+		}
+
+		body, err := json.Marshal(map[string][]string{
+			"references":  refs[start:end],
+			"predictions": preds[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Post(assServiceURL()+"/api/similarity", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("Python服务请求失败: %v", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取Python服务响应失败: %v", err)
+		}
+
+		var result struct {
+			Scores []float64 `json:"scores"`
+			Error  string    `json:"error,omitempty"`
+		}
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return nil, fmt.Errorf("解析Python服务响应失败: %s", string(respBody))
+		}
+		if result.Error != "" {
+			return nil, fmt.Errorf("Python服务返回错误: %s", result.Error)
+		}
+		if len(result.Scores) != end-start {
+			return nil, fmt.Errorf("Python服务返回的分数数量不正确: %d/%d", len(result.Scores), end-start)
+		}
+
+		scores = append(scores, result.Scores...)
+		updateProgress(end, len(refs))
+	}
+	return scores, nil
+}
@@ -2,22 +2,15 @@ package gongju
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"io"
 	"log"
 	"math"
-	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/go-ego/gse"
-	"github.com/xuri/excelize/v2"
 )
 
 // 全局变量
@@ -26,8 +19,8 @@ var (
 		cilinMap: make(map[string]CiLinCode),
 		codemap:  make(map[string][]string),
 	}
+	// 分词器在 init 中加载完成后只读，可被多个协程同时使用
 	segmenter   gse.Segmenter
-	jiebaLock   sync.Mutex
 	initialized bool
 	initLock    sync.Once
 
@@ -48,12 +41,16 @@ func init() {
 // 初始化函数
 func initialize() error {
 	// 加载同义词词典
-	dictPath := "gongju/cilin.txt"
-	err := globalSynonymDict.LoadCiLinDict(dictPath)
+	err := globalSynonymDict.LoadCiLinDict(cilinDictPath)
 	if err != nil {
 		log.Printf("加载同义词词典失败: %v，将不使用同义词功能", err)
 	}
 
+	// 加载默认停用词表
+	if err := loadStopWordFile(defaultStopWordsPath, defaultStopWords); err != nil {
+		log.Printf("加载停用词表失败: %v，将不排除停用词", err)
+	}
+
 	initialized = true
 	return nil
 }
@@ -108,9 +105,10 @@ type CiLinCode struct {
 
 // SynonymDict 同义词字典
 type SynonymDict struct {
-	cilinMap map[string]CiLinCode
-	codemap  map[string][]string
-	mu       sync.RWMutex
+	cilinMap  map[string]CiLinCode
+	codemap   map[string][]string
+	customSeq int // 自定义同义词组的编号计数
+	mu        sync.RWMutex
 }
 
 // TextSimilarity 存储文本相似度的各种指标
@@ -124,9 +122,14 @@ type TextSimilarity struct {
 
 // ProcessResponse 处理响应结构
 type ProcessResponse struct {
-	Status     string `json:"status"`
-	Message    string `json:"message"`
-	ResultFile string `json:"resultFile,omitempty"`
+	Status      string         `json:"status"`
+	Message     string         `json:"message"`
+	ResultFile  string         `json:"resultFile,omitempty"`
+	Scored      int            `json:"scored,omitempty"`      // 计分的行数
+	SkippedRows []string       `json:"skippedRows,omitempty"` // 因缺少标准答案未计分的行
+	JobID       string         `json:"jobId,omitempty"`
+	SubJobs     []SubJobResult `json:"subJobs,omitempty"` // 批量提交时每个文件或工作表的结果
+	Slices      []SliceSummary `json:"slices,omitempty"`  // 按分组列与标准答案长度的分组统计
 }
 
 // CalculateModelScore 计算智能大模型分值
@@ -208,7 +211,7 @@ func generateAnalysis(params ModelParams, responseTimeScore, accuracyScore, reso
 	return analysis
 }
 
-// ProcessExcelFile 处理Excel文件并计算语义相似度
+// ProcessExcelFile 处理上传的数据集文件并计算语义相似度
 func ProcessExcelFile(w http.ResponseWriter, r *http.Request) {
 	// 确保已初始化
 	initLock.Do(func() {
@@ -228,86 +231,23 @@ func ProcessExcelFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 解析文件
-	file, header, err := r.FormFile("file")
+	// 解析文件，也可以通过 datasetId 使用数据集库中的数据集
+	sub, err := openSubmission(r)
 	if err != nil {
-		http.Error(w, "获取文件失败", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
-
-	// 检查文件类型
-	if !strings.HasSuffix(header.Filename, ".xlsx") {
-		http.Error(w, "只支持.xlsx格式的文件", http.StatusBadRequest)
-		return
-	}
-
-	// 创建临时文件
-	tempFile := excelize.NewFile()
-	defer tempFile.Close()
+	defer sub.Close()
 
-	// 读取上传的文件
-	uploadedFile, err := excelize.OpenReader(file)
+	// 计算语义F1值，支持多个标准答案；支持 xlsx、csv、tsv、json、jsonl，以及包含多个文件的 zip
+	result, status, err := scoreSubmission(r, sub, "semantic_f1", "语义F1值")
 	if err != nil {
-		http.Error(w, "读取文件失败", http.StatusInternalServerError)
-		return
-	}
-	defer uploadedFile.Close()
-
-	// 获取第一个工作表
-	sheetName := uploadedFile.GetSheetName(0)
-	rows, err := uploadedFile.GetRows(sheetName)
-	if err != nil {
-		http.Error(w, "读取工作表失败", http.StatusInternalServerError)
-		return
-	}
-
-	// 设置表头
-	headers := []string{"标准答案", "预测文本", "语义F1值"}
-	for i, header := range headers {
-		cell := string(rune('A'+i)) + "1"
-		tempFile.SetCellValue("Sheet1", cell, header)
-	}
-
-	// 处理每一行
-	for rowIdx, row := range rows {
-		if rowIdx == 0 || len(row) < 2 {
-			continue
-		}
-
-		actual := strings.TrimSpace(row[0])
-		predicted := strings.TrimSpace(row[1])
-
-		// 计算相似度
-		similarity := calculateSemanticF1(actual, predicted, segmenter)
-
-		// 写入结果
-		rowNum := rowIdx + 1
-		tempFile.SetCellValue("Sheet1", fmt.Sprintf("A%d", rowNum), actual)
-		tempFile.SetCellValue("Sheet1", fmt.Sprintf("B%d", rowNum), predicted)
-		tempFile.SetCellValue("Sheet1", fmt.Sprintf("C%d", rowNum), similarity.SemanticF1)
-	}
-
-	// 调整列宽
-	tempFile.SetColWidth("Sheet1", "A", "C", 30)
-
-	// 生成结果文件名
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	resultFileName := fmt.Sprintf("语义F1值_%s.xlsx", timestamp)
-	resultPath := filepath.Join("uploads", resultFileName)
-
-	// 保存结果文件
-	if err := tempFile.SaveAs(resultPath); err != nil {
-		http.Error(w, "保存结果文件失败", http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
 
 	// 返回成功响应
-	response := ProcessResponse{
-		Status:     "success",
-		Message:    "文件处理成功",
-		ResultFile: "/uploads/" + resultFileName,
-	}
+	response := processResponseFrom("文件处理成功", result)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -318,96 +258,31 @@ func CalculateACCScore(w http.ResponseWriter, r *http.Request) {
 	// 设置响应头
 	w.Header().Set("Content-Type", "application/json")
 
-	// 解析上传的文件
-	file, _, err := r.FormFile("file")
+	// 解析上传的文件，也可以通过 datasetId 使用数据集库中的数据集
+	sub, err := openSubmission(r)
 	if err != nil {
 		response := ProcessResponse{
 			Status:  "error",
-			Message: fmt.Sprintf("获取文件失败: %v", err),
+			Message: err.Error(),
 		}
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	defer file.Close()
+	defer sub.Close()
 
-	// 读取Excel文件
-	xlsx, err := excelize.OpenReader(file)
+	// 计算ACC分数，支持多个标准答案
+	result, _, err := scoreSubmission(r, sub, "acc", "ACC分数")
 	if err != nil {
 		response := ProcessResponse{
 			Status:  "error",
-			Message: fmt.Sprintf("读取Excel文件失败: %v", err),
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-	defer xlsx.Close()
-
-	// 获取第一个工作表
-	sheetName := xlsx.GetSheetName(0)
-	rows, err := xlsx.GetRows(sheetName)
-	if err != nil {
-		response := ProcessResponse{
-			Status:  "error",
-			Message: fmt.Sprintf("读取工作表失败: %v", err),
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// 创建输出文件
-	outputXlsx := excelize.NewFile()
-	outputSheet := "Sheet1"
-
-	// 写入表头
-	headers := []string{"标准答案", "预测文本", "ACC分数"}
-	for i, header := range headers {
-		cell := string(rune('A'+i)) + "1"
-		outputXlsx.SetCellValue(outputSheet, cell, header)
-	}
-
-	// 处理每一行数据
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
-		if len(row) < 2 {
-			continue
-		}
-
-		// 获取A列和B列的文本
-		textA := row[0]
-		textB := row[1]
-
-		// 计算ACC分数
-		score := calculateACC([]string{textA}, []string{textB})
-
-		// 写入结果
-		rowNum := i + 1
-		outputXlsx.SetCellValue(outputSheet, fmt.Sprintf("A%d", rowNum), textA)
-		outputXlsx.SetCellValue(outputSheet, fmt.Sprintf("B%d", rowNum), textB)
-		outputXlsx.SetCellValue(outputSheet, fmt.Sprintf("C%d", rowNum), score)
-	}
-
-	// 调整列宽
-	outputXlsx.SetColWidth(outputSheet, "A", "C", 30)
-
-	// 保存输出文件
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	outputFileName := fmt.Sprintf("ACC分数_%s.xlsx", timestamp)
-	outputPath := filepath.Join("uploads", outputFileName)
-	if err := outputXlsx.SaveAs(outputPath); err != nil {
-		response := ProcessResponse{
-			Status:  "error",
-			Message: fmt.Sprintf("保存结果文件失败: %v", err),
+			Message: err.Error(),
 		}
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	// 返回成功响应
-	response := ProcessResponse{
-		Status:     "success",
-		Message:    "ACC分数计算完成",
-		ResultFile: "/uploads/" + outputFileName,
-	}
+	response := processResponseFrom("ACC分数计算完成", result)
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -419,92 +294,24 @@ func CalculateASSScore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 解析文件
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "获取文件失败", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	// 检查文件类型
-	if !strings.HasSuffix(header.Filename, ".xlsx") {
-		http.Error(w, "只支持.xlsx格式的文件", http.StatusBadRequest)
-		return
-	}
-
-	// 将文件转发到Python服务
-	pythonServiceURL := "http://localhost:5001/calculate-ass"
-
-	// 创建新的multipart请求
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", header.Filename)
+	// 解析文件，也可以通过 datasetId 使用数据集库中的数据集
+	sub, err := openSubmission(r)
 	if err != nil {
-		http.Error(w, "创建请求失败", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer sub.Close()
 
-	// 复制文件内容
-	_, err = io.Copy(part, file)
+	// 逐行文本对交给Python服务批量计算向量相似度，支持多个标准答案
+	result, status, err := scoreSubmission(r, sub, "ass", "ASS分数")
 	if err != nil {
-		http.Error(w, "复制文件失败", http.StatusInternalServerError)
-		return
-	}
-	writer.Close()
-
-	// 创建请求
-	req, err := http.NewRequest("POST", pythonServiceURL, body)
-	if err != nil {
-		http.Error(w, "创建请求失败", http.StatusInternalServerError)
-		return
-	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, "Python服务请求失败", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "读取响应失败", http.StatusInternalServerError)
-		return
-	}
-
-	// 解析Python服务返回的JSON
-	var pythonResp struct {
-		Status     string `json:"status"`
-		Message    string `json:"message"`
-		ResultFile string `json:"resultFile"`
-		Error      string `json:"error,omitempty"`
-	}
-
-	if err := json.Unmarshal(respBody, &pythonResp); err != nil {
-		http.Error(w, "解析响应失败: "+string(respBody), http.StatusInternalServerError)
-		return
-	}
-
-	// 如果Python服务返回错误
-	if pythonResp.Error != "" {
-		http.Error(w, pythonResp.Error, http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
 
 	// 返回成功响应
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":     pythonResp.Status,
-		"message":    pythonResp.Message,
-		"resultFile": pythonResp.ResultFile,
-	})
+	json.NewEncoder(w).Encode(processResponseFrom("ASS分数计算完成", result))
 }
 
 // splitWords 将文本分词并返回词列表
@@ -513,18 +320,8 @@ func splitWords(text string) []string {
 		return []string{}
 	}
 
-	// 使用互斥锁保护分词操作
-	jiebaLock.Lock()
-	defer jiebaLock.Unlock()
-
-	// 确保分词器已初始化
-	if !initialized {
-		initLock.Do(func() {
-			if err := initialize(); err != nil {
-				log.Printf("初始化失败: %v", err)
-			}
-		})
-	}
+	// 确保分词器已初始化，分词器加载后只读，无需加锁
+	ensureInitialized()
 
 	return segmenter.CutAll(text)
 }
@@ -574,13 +371,26 @@ func (sd *SynonymDict) LoadCiLinDict(filePath string) error {
 		}
 
 		code := parts[0]
+		if len(code) < 7 {
+			continue
+		}
 		cilinCode := ParseCiLinCode(code)
 		words := parts[1:]
 
 		sd.mu.Lock()
-		sd.codemap[code] = words
-		for _, word := range words {
-			sd.cilinMap[word] = cilinCode
+		if isSynonymGroupCode(code) {
+			sd.codemap[cilinCode.String()] = words
+			for _, word := range words {
+				sd.cilinMap[word] = cilinCode
+			}
+		} else {
+			// 相关词（#）和独立词（@）不是同义词，只记录编码用于判断词性，
+			// 不覆盖同义词组中的编码
+			for _, word := range words {
+				if _, ok := sd.cilinMap[word]; !ok {
+					sd.cilinMap[word] = cilinCode
+				}
+			}
 		}
 		sd.mu.Unlock()
 	}
@@ -588,19 +398,23 @@ func (sd *SynonymDict) LoadCiLinDict(filePath string) error {
 	return scanner.Err()
 }
 
+// isSynonymGroupCode 判断词林编码的行是否为同义词组：末尾为 = 或没有标记
+func isSynonymGroupCode(code string) bool {
+	return len(code) == 7 || code[7] == '='
+}
+
+// String 返回词林编码的前七位（不含末尾的=、#、@标记）
+func (c CiLinCode) String() string {
+	return c.FirstLevel + c.SecondLevel + c.ThirdLevel + c.FourthLevel + c.FifthLevel
+}
+
 // GetSynonyms 获取同义词
 func (sd *SynonymDict) GetSynonyms(word string) []string {
 	sd.mu.RLock()
 	defer sd.mu.RUnlock()
 
 	if code, ok := sd.cilinMap[word]; ok {
-		codeStr := fmt.Sprintf("%s%s%s%s%s",
-			code.FirstLevel,
-			code.SecondLevel,
-			code.ThirdLevel,
-			code.FourthLevel,
-			code.FifthLevel)
-		if words, exists := sd.codemap[codeStr]; exists {
+		if words, exists := sd.codemap[code.String()]; exists {
 			synonyms := make([]string, 0)
 			for _, w := range words {
 				if w != word {
@@ -614,8 +428,10 @@ func (sd *SynonymDict) GetSynonyms(word string) []string {
 }
 
 // 获取词语类型
-func getWordType(word string) WordType {
-	code, ok := globalSynonymDict.cilinMap[word]
+func getWordType(dict *SynonymDict, word string) WordType {
+	dict.mu.RLock()
+	code, ok := dict.cilinMap[word]
+	dict.mu.RUnlock()
 	if !ok {
 		return TypeOther
 	}
@@ -652,7 +468,7 @@ func getPositionTolerance(wordType WordType) float64 {
 }
 
 // calculateMatches 计算词语匹配情况
-func calculateMatches(actual, predicted []WordMatch, actualLen, predictedLen int) matchResult {
+func calculateMatches(dict *SynonymDict, actual, predicted []WordMatch, actualLen, predictedLen int) matchResult {
 	exactMatches := 0.0
 	semanticMatches := 0.0
 	positionAwareScore := 0.0
@@ -662,7 +478,7 @@ func calculateMatches(actual, predicted []WordMatch, actualLen, predictedLen int
 
 	// 首先处理完全匹配
 	for _, actualWord := range actual {
-		actualType := getWordType(actualWord.word)
+		actualType := getWordType(dict, actualWord.word)
 		tolerance := getPositionTolerance(actualType)
 
 		bestMatchScore := 0.0
@@ -675,9 +491,9 @@ func calculateMatches(actual, predicted []WordMatch, actualLen, predictedLen int
 				continue
 			}
 
-			matchScore := getMatchScore(actualWord.word, predictedWord.word)
+			matchScore := getMatchScore(dict, actualWord.word, predictedWord.word)
 			if matchScore > 0 {
-				predictedType := getWordType(predictedWord.word)
+				predictedType := getWordType(dict, predictedWord.word)
 
 				// 计算位置分数
 				positionScore := calculatePositionScore(
@@ -734,14 +550,14 @@ func calculatePositionScore(pos1, pos2 float64, tolerance float64) float64 {
 }
 
 // getMatchScore 获取两个词的匹配分数
-func getMatchScore(word1, word2 string) float64 {
+func getMatchScore(dict *SynonymDict, word1, word2 string) float64 {
 	// 完全匹配
 	if word1 == word2 {
 		return 1.0
 	}
 
 	// 检查同义词
-	if synonyms := globalSynonymDict.GetSynonyms(word1); synonyms != nil {
+	if synonyms := dict.GetSynonyms(word1); synonyms != nil {
 		for _, syn := range synonyms {
 			if syn == word2 {
 				return 0.9
@@ -797,11 +613,12 @@ func safeDiv(a, b float64) float64 {
 	return a / b
 }
 
-// calculateSemanticF1 计算语义相似度
-func calculateSemanticF1(actual, predicted string, seg gse.Segmenter) TextSimilarity {
-	// 分词
-	actualWords := seg.Cut(actual, true)
-	predictedWords := seg.Cut(predicted, true)
+// calculateSemanticF1 计算语义相似度，dict 为本次评分使用的同义词词典，filter 决定哪些词参与统计
+// 函数不修改任何共享状态，可在多个协程中并发调用
+func calculateSemanticF1(actual, predicted string, seg *gse.Segmenter, dict *SynonymDict, filter tokenFilter) TextSimilarity {
+	// 分词，并按需排除停用词和标点
+	actualWords := filter.apply(seg.Cut(actual, true))
+	predictedWords := filter.apply(seg.Cut(predicted, true))
 
 	// 创建词频和位置映射
 	actualMatches := make([]WordMatch, 0)
@@ -813,7 +630,7 @@ func calculateSemanticF1(actual, predicted string, seg gse.Segmenter) TextSimila
 			word:     word,
 			position: i,
 			score:    1.0,
-			wordType: getWordType(word),
+			wordType: getWordType(dict, word),
 		})
 	}
 
@@ -822,12 +639,12 @@ func calculateSemanticF1(actual, predicted string, seg gse.Segmenter) TextSimila
 			word:     word,
 			position: i,
 			score:    1.0,
-			wordType: getWordType(word),
+			wordType: getWordType(dict, word),
 		})
 	}
 
 	// 计算匹配分数
-	matches := calculateMatches(actualMatches, predictedMatches, len(actualWords), len(predictedWords))
+	matches := calculateMatches(dict, actualMatches, predictedMatches, len(actualWords), len(predictedWords))
 
 	// 计算基础指标
 	truePositives := matches.exactMatches
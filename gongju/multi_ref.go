@@ -0,0 +1,232 @@
+package gongju
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fuzhu_2/models"
+	"fuzhu_2/quota"
+	"fuzhu_2/storage"
+	"fuzhu_2/utils"
+)
+
+// referenceSpec 标准答案的读取方式：可以是多个标准答案列，也可以在单元格内用分隔符给出多个答案
+type referenceSpec struct {
+	cols      []int
+	predCol   int
+	idCol     int // 未指定ID列时为 -1
+	delimiter string
+	sliceCols []int // 分组统计的列
+}
+
+// multi 是否为多标准答案模式
+func (s referenceSpec) multi() bool {
+	return len(s.cols) > 1 || s.delimiter != ""
+}
+
+// references 返回一行中所有非空的标准答案
+func (s referenceSpec) references(row []string) []string {
+	refs := make([]string, 0, len(s.cols))
+	for _, col := range s.cols {
+		cell := cellAt(row, col)
+		if s.delimiter == "" {
+			if cell != "" {
+				refs = append(refs, cell)
+			}
+			continue
+		}
+		for _, part := range strings.Split(cell, s.delimiter) {
+			if part = strings.TrimSpace(part); part != "" {
+				refs = append(refs, part)
+			}
+		}
+	}
+	return refs
+}
+
+// scoreMultiRef 计算每个预测文本与其所有标准答案的分数，返回每行的最大值和平均值。
+// 所有文本对展开后一次性交给指标计算，便于并发和批量请求
+func scoreMultiRef(ctx *scoringContext, metric metricFunc, refs [][]string, preds []string) ([]float64, []float64, error) {
+	flatRefs := make([]string, 0, len(refs))
+	flatPreds := make([]string, 0, len(refs))
+	for i, rowRefs := range refs {
+		for _, ref := range rowRefs {
+			flatRefs = append(flatRefs, ref)
+			flatPreds = append(flatPreds, preds[i])
+		}
+	}
+
+	scores, err := metric(ctx, flatRefs, flatPreds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maxScores := make([]float64, len(refs))
+	meanScores := make([]float64, len(refs))
+	offset := 0
+	for i, rowRefs := range refs {
+		rowScores := scores[offset : offset+len(rowRefs)]
+		offset += len(rowRefs)
+		for j, score := range rowScores {
+			if j == 0 || score > maxScores[i] {
+				maxScores[i] = score
+			}
+		}
+		meanScores[i] = mean(rowScores)
+	}
+	return maxScores, meanScores, nil
+}
+
+// scoringResult 数据集评分结果
+type scoringResult struct {
+	ResultFile   string
+	Scored       int
+	SkippedRows  []string
+	Mean         float64        // 计分行的平均分（多标准答案时为最大值的平均）
+	Metric       string         // 指标名称
+	ScoreColumns []string       // 结果文件中追加的分数列表头，生成报告时按此查找
+	ScoreMeans   []float64      // 各分数列的平均分，与 ScoreColumns 对应
+	Slices       []SliceSummary // 按分组列与标准答案长度的分组统计
+	spec         referenceSpec  // 列映射，人工复核时按此从结果文件读取标准答案与预测
+	file         *models.File   // 结果文件的存储记录，批量评分打包 zip 时使用
+}
+
+// scoreUploadedDataset 读取上传的数据集（批量提交中的一个文件或工作表），按请求的列映射计算指标，
+// 结果保存为任务 jobID 的结果文件 <baseName>.<格式>。结果文件保留原数据的全部列，在末尾追加分数列；
+// 计分的行数在评分前由 meter 计入配额。出错时同时返回建议的HTTP状态码
+func scoreUploadedDataset(r *http.Request, part utils.BatchPart, jobID, baseName, metricName, label string, meter *quota.Meter) (*scoringResult, int, error) {
+	metric, err := lookupMetric(metricName)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	ctx, err := newScoringContext(r, meter.ProjectID())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	// 数据按行流式读取：第一遍统计行数与列数，第二遍收集待评分的文本，第三遍写出结果
+	mapping := parseColumnMapping(r)
+	filename := part.Filename
+	ds, err := openUploadedDataset(r, part.Data, filename, part.Sheet)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	headers, rowCount, width, err := ds.shape()
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if rowCount < 2 {
+		return nil, http.StatusBadRequest, fmt.Errorf("文件 %q 为空或只有表头", filename)
+	}
+
+	// 第一行为表头，先校验列映射再开始计算
+	spec, err := mapping.resolve(headers, width)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	// 收集有标准答案的行，没有标准答案的行保留在结果中但不计分
+	result := &scoringResult{}
+	rowIdxs := make([]int, 0, rowCount)
+	refs := make([][]string, 0, rowCount)
+	preds := make([]string, 0, rowCount)
+	groups := make([][]string, 0, rowCount)
+	err = ds.each(func(i int, row []string) error {
+		if i == 0 {
+			return nil
+		}
+		rowRefs := spec.references(row)
+		if len(rowRefs) == 0 {
+			result.SkippedRows = append(result.SkippedRows, spec.rowID(row, i+1))
+			return nil
+		}
+		rowIdxs = append(rowIdxs, i)
+		refs = append(refs, rowRefs)
+		preds = append(preds, cellAt(row, spec.predCol))
+		groups = append(groups, spec.sliceValues(row, rowRefs))
+		return nil
+	})
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if len(rowIdxs) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("文件 %q 中没有有效数据", filename)
+	}
+
+	if err := meter.AddRows(len(rowIdxs)); err != nil {
+		return nil, quota.HTTPStatus(err), err
+	}
+	maxScores, meanScores, err := scoreMultiRef(ctx, metric, refs, preds)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("计算%s失败: %v", label, err)
+	}
+
+	// 单标准答案追加一列分数，多标准答案追加标准答案数、最大值与平均值
+	scoreHeaders := []string{label}
+	if spec.multi() {
+		scoreHeaders = []string{"标准答案数", label + "(最大值)", label + "(平均值)"}
+	}
+	scoreCells := func(k int) []interface{} {
+		if spec.multi() {
+			return []interface{}{len(refs[k]), maxScores[k], meanScores[k]}
+		}
+		return []interface{}{maxScores[k]}
+	}
+
+	// 复制原数据的所有列，并在末尾追加分数列
+	writer, file, err := ds.resultWriter(r, jobID, baseName)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	next := 0 // rowIdxs 中下一个计分行
+	err = ds.each(func(i int, row []string) error {
+		cells := make([]interface{}, width, width+len(scoreHeaders))
+		for j, cell := range row {
+			cells[j] = cell
+		}
+		if i == 0 {
+			for _, header := range scoreHeaders {
+				cells = append(cells, header)
+			}
+		} else if next < len(rowIdxs) && rowIdxs[next] == i {
+			cells = append(cells, scoreCells(next)...)
+			next++
+		}
+		return writer.WriteRow(cells)
+	})
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = storage.Commit(file)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("保存结果文件失败: %v", err)
+	}
+
+	result.ResultFile = storage.URL(file)
+	result.file = file
+	result.spec = spec
+	result.Scored = len(rowIdxs)
+	result.Mean = mean(maxScores)
+	result.Metric = metricName
+	result.ScoreColumns = scoreHeaders
+	result.ScoreMeans = []float64{result.Mean}
+	if spec.multi() {
+		result.ScoreColumns = scoreHeaders[1:] // 标准答案数不是分数
+		result.ScoreMeans = append(result.ScoreMeans, mean(meanScores))
+	}
+
+	// 按分组列与标准答案长度统计各组的平均分
+	sliceRows := make([]reportRow, len(rowIdxs))
+	for k := range rowIdxs {
+		scores := []float64{maxScores[k]}
+		if spec.multi() {
+			scores = append(scores, meanScores[k])
+		}
+		sliceRows[k] = reportRow{scores: scores, groups: groups[k]}
+	}
+	result.Slices = buildSlices(spec.sliceNames(headers), len(result.ScoreColumns), sliceRows)
+	return result, http.StatusOK, nil
+}
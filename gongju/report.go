@@ -0,0 +1,592 @@
+package gongju
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/storage"
+	"fuzhu_2/utils"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 分数分布直方图的区间数，分数范围为 0-1
+const histogramBins = 10
+
+// summarySheet 报告中汇总工作表的名称
+const summarySheet = "汇总"
+
+// HistogramBin 分数分布的一个区间
+type HistogramBin struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// ScoreSummary 一个分数列的汇总统计
+type ScoreSummary struct {
+	Column    string         `json:"column"`
+	Count     int            `json:"count"`
+	Mean      float64        `json:"mean"`
+	Median    float64        `json:"median"`
+	Min       float64        `json:"min"`
+	Max       float64        `json:"max"`
+	Histogram []HistogramBin `json:"histogram"`
+}
+
+// SliceGroup 分组中的一组，Means 与 ReportSummary.Scores 的顺序一致
+type SliceGroup struct {
+	Value string    `json:"value"`
+	Count int       `json:"count"`
+	Means []float64 `json:"means"`
+}
+
+// SliceSummary 按某一列分组的统计
+type SliceSummary struct {
+	Name   string       `json:"name"`
+	Groups []SliceGroup `json:"groups"`
+}
+
+// ReportSummary 评估报告的汇总数据
+type ReportSummary struct {
+	JobID       string         `json:"jobId"`
+	JobName     string         `json:"jobName"`
+	Metric      string         `json:"metric"`
+	Rows        int            `json:"rows"`
+	Scores      []ScoreSummary `json:"scores"`
+	Slices      []SliceSummary `json:"slices,omitempty"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+}
+
+// ReportResponse 生成报告接口的响应结构
+type ReportResponse struct {
+	Status    string         `json:"status"`
+	Message   string         `json:"message"`
+	ExcelFile string         `json:"excelFile,omitempty"`
+	HTMLFile  string         `json:"htmlFile,omitempty"`
+	Summary   *ReportSummary `json:"summary,omitempty"`
+}
+
+// reportRow 结果文件中的一个计分行
+type reportRow struct {
+	scores []float64 // 与 scoreColumns 一一对应，缺失为 NaN
+	groups []string  // 与 slices 一一对应
+}
+
+// reportSource 一个结果文件及其所属子任务
+type reportSource struct {
+	name   string
+	result *scoringResult
+}
+
+// reportSources 返回任务的结果文件：批量任务为所有成功的子任务，否则为任务本身
+func reportSources(job *jobs.Job, children []*jobs.Job) ([]reportSource, error) {
+	if job.Status != jobs.StatusSucceeded {
+		return nil, fmt.Errorf("任务尚未成功完成，当前状态: %s", job.Status)
+	}
+	candidates := children
+	if len(children) == 0 {
+		candidates = []*jobs.Job{job}
+	}
+
+	sources := make([]reportSource, 0, len(candidates))
+	for _, candidate := range candidates {
+		result, ok := candidate.Result.(*scoringResult)
+		if candidate.Status != jobs.StatusSucceeded || !ok {
+			continue
+		}
+		sources = append(sources, reportSource{name: candidate.Name, result: result})
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("任务 %s 不是评分任务或没有可用的结果", job.ID)
+	}
+	return sources, nil
+}
+
+// loadReportRows 读取结果文件中的分数列。categoryColumns 为分组列（表头名称或列字母）；
+// 批量任务另按文件分组
+func loadReportRows(sources []reportSource, categoryColumns []string) ([]string, []string, []reportRow, error) {
+	scoreColumns := sources[0].result.ScoreColumns
+	sliceNames := append([]string(nil), categoryColumns...)
+	if len(sources) > 1 {
+		sliceNames = append(sliceNames, "文件")
+	}
+
+	rows := make([]reportRow, 0)
+	for _, source := range sources {
+		reader, err := utils.OpenDataset(source.result.file.Path, utils.DatasetOptions{})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", source.name, err)
+		}
+
+		var scoreCols []int
+		categoryCols := make([]int, len(categoryColumns))
+		for rowIndex := 0; ; rowIndex++ {
+			row, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				reader.Close()
+				return nil, nil, nil, fmt.Errorf("%s: %v", source.name, err)
+			}
+
+			// 表头：定位分数列与分组列
+			if rowIndex == 0 {
+				scoreCols = make([]int, len(scoreColumns))
+				for i, name := range scoreColumns {
+					if scoreCols[i], err = resolveColumn(row, len(row), name); err != nil {
+						reader.Close()
+						return nil, nil, nil, fmt.Errorf("%s: 分数%v", source.name, err)
+					}
+				}
+				for i, name := range categoryColumns {
+					if categoryCols[i], err = resolveColumn(row, len(row), name); err != nil {
+						reader.Close()
+						return nil, nil, nil, fmt.Errorf("%s: 分组%v", source.name, err)
+					}
+				}
+				continue
+			}
+
+			// 未计分的行（缺少标准答案）分数为空，不计入报告
+			scores := make([]float64, len(scoreCols))
+			scored := false
+			for i, col := range scoreCols {
+				scores[i] = math.NaN()
+				if v, err := strconv.ParseFloat(cellAt(row, col), 64); err == nil {
+					scores[i] = v
+					scored = true
+				}
+			}
+			if !scored {
+				continue
+			}
+
+			groups := make([]string, 0, len(sliceNames))
+			for _, col := range categoryCols {
+				groups = append(groups, groupValue(cellAt(row, col)))
+			}
+			if len(sources) > 1 {
+				groups = append(groups, source.name)
+			}
+			rows = append(rows, reportRow{scores: scores, groups: groups})
+		}
+		reader.Close()
+	}
+	if len(rows) == 0 {
+		return nil, nil, nil, fmt.Errorf("结果文件中没有计分的行")
+	}
+	return scoreColumns, sliceNames, rows, nil
+}
+
+// groupValue 空值单独归为一组
+func groupValue(value string) string {
+	if value == "" {
+		return "（空）"
+	}
+	return value
+}
+
+// summarizeScores 计算一个分数列的统计量与分布
+func summarizeScores(column string, values []float64) ScoreSummary {
+	summary := ScoreSummary{Column: column, Count: len(values), Histogram: make([]HistogramBin, histogramBins)}
+	for i := range summary.Histogram {
+		summary.Histogram[i].Label = fmt.Sprintf("%.1f-%.1f", float64(i)/histogramBins, float64(i+1)/histogramBins)
+	}
+	if len(values) == 0 {
+		return summary
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	summary.Mean = mean(sorted)
+	summary.Median = percentile(sorted, 0.5)
+	summary.Min = sorted[0]
+	summary.Max = sorted[len(sorted)-1]
+	for _, v := range values {
+		bin := int(v * histogramBins)
+		if bin < 0 {
+			bin = 0
+		}
+		if bin >= histogramBins {
+			bin = histogramBins - 1
+		}
+		summary.Histogram[bin].Count++
+	}
+	return summary
+}
+
+// summarizeSlice 按第 index 个分组列统计每组的行数与各分数列的平均值
+func summarizeSlice(name string, index int, scoreCount int, rows []reportRow) SliceSummary {
+	type accumulator struct {
+		count  int
+		sums   []float64
+		counts []int
+	}
+	groups := make(map[string]*accumulator)
+	order := make([]string, 0)
+	for _, row := range rows {
+		value := row.groups[index]
+		acc, ok := groups[value]
+		if !ok {
+			acc = &accumulator{sums: make([]float64, scoreCount), counts: make([]int, scoreCount)}
+			groups[value] = acc
+			order = append(order, value)
+		}
+		acc.count++
+		for i, score := range row.scores {
+			if !math.IsNaN(score) {
+				acc.sums[i] += score
+				acc.counts[i]++
+			}
+		}
+	}
+
+	slice := SliceSummary{Name: name, Groups: make([]SliceGroup, 0, len(order))}
+	for _, value := range order {
+		acc := groups[value]
+		means := make([]float64, scoreCount)
+		for i := range means {
+			if acc.counts[i] > 0 {
+				means[i] = acc.sums[i] / float64(acc.counts[i])
+			}
+		}
+		slice.Groups = append(slice.Groups, SliceGroup{Value: value, Count: acc.count, Means: means})
+	}
+	sortSlice(&slice)
+	return slice
+}
+
+// buildReportSummary 汇总所有计分行。评分时已统计的分组（sliceColumns 与标准答案长度）
+// 排在报告指定的分组之后
+func buildReportSummary(job *jobs.Job, sources []reportSource, scoreColumns, sliceNames []string, rows []reportRow) *ReportSummary {
+	summary := &ReportSummary{
+		JobID:       job.ID,
+		JobName:     job.Name,
+		Metric:      sources[0].result.Metric,
+		Rows:        len(rows),
+		GeneratedAt: time.Now(),
+	}
+	for i, column := range scoreColumns {
+		values := make([]float64, 0, len(rows))
+		for _, row := range rows {
+			if !math.IsNaN(row.scores[i]) {
+				values = append(values, row.scores[i])
+			}
+		}
+		summary.Scores = append(summary.Scores, summarizeScores(column, values))
+	}
+	summary.Slices = buildSlices(sliceNames, len(scoreColumns), rows)
+
+	scored := make([][]SliceSummary, len(sources))
+	for i, source := range sources {
+		scored[i] = source.result.Slices
+	}
+	for _, slice := range mergeSlices(scored...) {
+		duplicate := false
+		for _, name := range sliceNames {
+			duplicate = duplicate || name == slice.Name
+		}
+		if !duplicate {
+			summary.Slices = append(summary.Slices, slice)
+		}
+	}
+	return summary
+}
+
+// sheetRange 返回带工作表名的绝对区域引用，用于图表数据源
+func sheetRange(sheet string, col1, row1, col2, row2 int) string {
+	start, _ := excelize.CoordinatesToCellName(col1, row1, true)
+	end, _ := excelize.CoordinatesToCellName(col2, row2, true)
+	return fmt.Sprintf("'%s'!%s:%s", sheet, start, end)
+}
+
+// writeSummarySheet 在工作簿中添加汇总工作表：指标汇总、分数分布与分组统计，并插入图表
+func writeSummarySheet(f *excelize.File, summary *ReportSummary) error {
+	if idx, _ := f.GetSheetIndex(summarySheet); idx >= 0 {
+		f.DeleteSheet(summarySheet)
+	}
+	idx, err := f.NewSheet(summarySheet)
+	if err != nil {
+		return err
+	}
+	f.SetActiveSheet(idx)
+
+	row := 1
+	setRow := func(values ...interface{}) {
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		f.SetSheetRow(summarySheet, cell, &values)
+		row++
+	}
+	chartCell := func() string {
+		cell, _ := excelize.CoordinatesToCellName(len(summary.Scores)+4, row)
+		return cell
+	}
+
+	setRow("评估报告")
+	setRow("任务", summary.JobName)
+	setRow("指标", summary.Metric)
+	setRow("计分行数", summary.Rows)
+	setRow("生成时间", summary.GeneratedAt.Format("2006-01-02 15:04:05"))
+	row++
+
+	// 指标汇总
+	setRow("分数列", "平均值", "中位数", "最小值", "最大值", "计分行数")
+	for _, score := range summary.Scores {
+		setRow(score.Column, score.Mean, score.Median, score.Min, score.Max, score.Count)
+	}
+	row++
+
+	// 分数分布，每个分数列一列
+	histogramTitle := make([]interface{}, 0, len(summary.Scores)+1)
+	histogramTitle = append(histogramTitle, "分数区间")
+	for _, score := range summary.Scores {
+		histogramTitle = append(histogramTitle, score.Column)
+	}
+	anchor := chartCell()
+	headerRow := row
+	setRow(histogramTitle...)
+	for bin := 0; bin < histogramBins; bin++ {
+		values := []interface{}{summary.Scores[0].Histogram[bin].Label}
+		for _, score := range summary.Scores {
+			values = append(values, score.Histogram[bin].Count)
+		}
+		setRow(values...)
+	}
+	if err := addColumnChart(f, anchor, "分数分布", headerRow, row-1, len(summary.Scores)); err != nil {
+		return err
+	}
+	row = max(row, headerRow+16) + 1
+
+	// 分组统计
+	for _, slice := range summary.Slices {
+		title := []interface{}{slice.Name, "行数"}
+		for _, score := range summary.Scores {
+			title = append(title, score.Column)
+		}
+		anchor := chartCell()
+		headerRow := row
+		setRow(title...)
+		for _, group := range slice.Groups {
+			values := []interface{}{group.Value, group.Count}
+			for _, m := range group.Means {
+				values = append(values, m)
+			}
+			setRow(values...)
+		}
+		if err := addGroupChart(f, anchor, slice.Name+"：平均分", headerRow, row-1, len(summary.Scores)); err != nil {
+			return err
+		}
+		row = max(row, headerRow+16) + 1
+	}
+
+	f.SetColWidth(summarySheet, "A", "A", 24)
+	return nil
+}
+
+// addColumnChart 为分数分布插入柱形图，数据位于 headerRow 的下一行至 lastRow，第一列为区间
+func addColumnChart(f *excelize.File, cell, title string, headerRow, lastRow, seriesCount int) error {
+	series := make([]excelize.ChartSeries, seriesCount)
+	for i := range series {
+		series[i] = excelize.ChartSeries{
+			Name:       sheetRange(summarySheet, i+2, headerRow, i+2, headerRow),
+			Categories: sheetRange(summarySheet, 1, headerRow+1, 1, lastRow),
+			Values:     sheetRange(summarySheet, i+2, headerRow+1, i+2, lastRow),
+		}
+	}
+	return f.AddChart(summarySheet, cell, &excelize.Chart{
+		Type:   excelize.Col,
+		Series: series,
+		Title:  []excelize.RichTextRun{{Text: title}},
+		Legend: excelize.ChartLegend{Position: "bottom"},
+	})
+}
+
+// addGroupChart 为分组统计插入条形图，第一列为分组、第二列为行数，之后为各分数列的平均值
+func addGroupChart(f *excelize.File, cell, title string, headerRow, lastRow, seriesCount int) error {
+	series := make([]excelize.ChartSeries, seriesCount)
+	for i := range series {
+		series[i] = excelize.ChartSeries{
+			Name:       sheetRange(summarySheet, i+3, headerRow, i+3, headerRow),
+			Categories: sheetRange(summarySheet, 1, headerRow+1, 1, lastRow),
+			Values:     sheetRange(summarySheet, i+3, headerRow+1, i+3, lastRow),
+		}
+	}
+	return f.AddChart(summarySheet, cell, &excelize.Chart{
+		Type:   excelize.Bar,
+		Series: series,
+		Title:  []excelize.RichTextRun{{Text: title}},
+		Legend: excelize.ChartLegend{Position: "bottom"},
+	})
+}
+
+// saveExcelReport 保存Excel报告：单个xlsx结果文件时在其副本中添加汇总工作表，否则新建工作簿
+func saveExcelReport(sources []reportSource, summary *ReportSummary, path string) error {
+	var f *excelize.File
+	var err error
+	if len(sources) == 1 && strings.EqualFold(filepath.Ext(sources[0].result.file.Name), ".xlsx") {
+		f, err = excelize.OpenFile(sources[0].result.file.Path)
+	} else {
+		f = excelize.NewFile()
+		f.SetSheetName("Sheet1", summarySheet)
+	}
+	if err != nil {
+		return fmt.Errorf("打开结果文件失败: %v", err)
+	}
+	defer f.Close()
+
+	if err := writeSummarySheet(f, summary); err != nil {
+		return fmt.Errorf("生成汇总工作表失败: %v", err)
+	}
+	return f.SaveAs(path)
+}
+
+// reportTemplate 独立的HTML报告，样式内联、不依赖脚本，便于作为邮件正文或附件发送
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"score": func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) },
+	"percent": func(count, total int) string {
+		if total == 0 {
+			return "0"
+		}
+		return strconv.FormatFloat(float64(count)*100/float64(total), 'f', 1, 64)
+	},
+	"barWidth": func(v float64) string {
+		return strconv.FormatFloat(math.Max(0, math.Min(1, v))*100, 'f', 1, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="UTF-8">
+<title>评估报告 - {{.JobName}}</title>
+</head>
+<body style="font-family: -apple-system, 'Microsoft YaHei', sans-serif; color: #222; max-width: 960px; margin: 24px auto; padding: 0 16px;">
+<h1 style="font-size: 24px;">评估报告</h1>
+<table style="border-collapse: collapse; margin-bottom: 24px;">
+<tr><td style="padding: 4px 16px 4px 0; color: #666;">任务</td><td>{{.JobName}}</td></tr>
+<tr><td style="padding: 4px 16px 4px 0; color: #666;">指标</td><td>{{.Metric}}</td></tr>
+<tr><td style="padding: 4px 16px 4px 0; color: #666;">计分行数</td><td>{{.Rows}}</td></tr>
+<tr><td style="padding: 4px 16px 4px 0; color: #666;">生成时间</td><td>{{.GeneratedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+</table>
+
+<h2 style="font-size: 18px;">指标汇总</h2>
+<table style="border-collapse: collapse; width: 100%; margin-bottom: 24px;">
+<tr style="background: #f0f3f7;"><th style="text-align: left; padding: 6px;">分数列</th><th style="text-align: right; padding: 6px;">平均值</th><th style="text-align: right; padding: 6px;">中位数</th><th style="text-align: right; padding: 6px;">最小值</th><th style="text-align: right; padding: 6px;">最大值</th><th style="text-align: right; padding: 6px;">计分行数</th></tr>
+{{range .Scores}}<tr style="border-bottom: 1px solid #e5e5e5;"><td style="padding: 6px;">{{.Column}}</td><td style="text-align: right; padding: 6px;"><b>{{score .Mean}}</b></td><td style="text-align: right; padding: 6px;">{{score .Median}}</td><td style="text-align: right; padding: 6px;">{{score .Min}}</td><td style="text-align: right; padding: 6px;">{{score .Max}}</td><td style="text-align: right; padding: 6px;">{{.Count}}</td></tr>
+{{end}}</table>
+
+{{range .Scores}}{{$total := .Count}}
+<h2 style="font-size: 18px;">分数分布：{{.Column}}</h2>
+<table style="border-collapse: collapse; width: 100%; margin-bottom: 24px;">
+{{range .Histogram}}<tr><td style="width: 80px; padding: 3px 6px; color: #666;">{{.Label}}</td><td style="padding: 3px 6px;"><div style="background: #4e79a7; height: 14px; width: {{percent .Count $total}}%;"></div></td><td style="width: 100px; text-align: right; padding: 3px 6px;">{{.Count}}（{{percent .Count $total}}%）</td></tr>
+{{end}}</table>
+{{end}}
+
+{{$scores := .Scores}}{{range .Slices}}
+<h2 style="font-size: 18px;">分组统计：{{.Name}}</h2>
+<table style="border-collapse: collapse; width: 100%; margin-bottom: 24px;">
+<tr style="background: #f0f3f7;"><th style="text-align: left; padding: 6px;">{{.Name}}</th><th style="text-align: right; padding: 6px;">行数</th>{{range $scores}}<th style="text-align: left; padding: 6px;">{{.Column}}</th>{{end}}</tr>
+{{range .Groups}}<tr style="border-bottom: 1px solid #e5e5e5;"><td style="padding: 6px;">{{.Value}}</td><td style="text-align: right; padding: 6px;">{{.Count}}</td>{{range .Means}}<td style="padding: 6px; min-width: 160px;"><div style="display: inline-block; vertical-align: middle; background: #f28e2b; height: 12px; width: {{barWidth .}}px;"></div> {{score .}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// saveHTMLReport 渲染并保存HTML报告
+func saveHTMLReport(summary *ReportSummary, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(file, summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeReportResponse 写入报告接口的JSON响应
+func writeReportResponse(w http.ResponseWriter, status int, response ReportResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GenerateReport 为已完成的评分任务生成汇总报告（Excel与HTML）。参数 jobId 为评分接口返回的
+// 任务ID，categoryColumn 可选，按这些列（逗号分隔的表头名称或列字母）分组统计
+func GenerateReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, children, ok := jobs.Get(strings.TrimSpace(r.FormValue("jobId")))
+	if !ok || !jobs.CanAccess(jobs.OwnerFrom(r.Context()), job, models.ProjectRoleViewer) {
+		writeReportResponse(w, http.StatusNotFound, ReportResponse{Status: "error", Message: "任务不存在"})
+		return
+	}
+	sources, err := reportSources(job, children)
+	if err != nil {
+		writeReportResponse(w, http.StatusBadRequest, ReportResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	categoryColumns := make([]string, 0)
+	for _, name := range strings.Split(r.FormValue("categoryColumn"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			categoryColumns = append(categoryColumns, name)
+		}
+	}
+	scoreColumns, sliceNames, rows, err := loadReportRows(sources, categoryColumns)
+	if err != nil {
+		writeReportResponse(w, http.StatusBadRequest, ReportResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	summary := buildReportSummary(job, sources, scoreColumns, sliceNames, rows)
+
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	baseName := fmt.Sprintf("评估报告_%s_%s", utils.SafeFileName(strings.TrimSuffix(job.Name, filepath.Ext(job.Name))), timestamp)
+	excelFile, err := storage.New(job.Owner, job.ID, storage.KindReport, baseName+".xlsx")
+	if err == nil {
+		err = saveExcelReport(sources, summary, excelFile.Path)
+	}
+	if err == nil {
+		err = storage.Commit(excelFile)
+	}
+	if err != nil {
+		writeReportResponse(w, http.StatusInternalServerError, ReportResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	htmlFile, err := storage.New(job.Owner, job.ID, storage.KindReport, baseName+".html")
+	if err == nil {
+		err = saveHTMLReport(summary, htmlFile.Path)
+	}
+	if err == nil {
+		err = storage.Commit(htmlFile)
+	}
+	if err != nil {
+		writeReportResponse(w, http.StatusInternalServerError, ReportResponse{Status: "error", Message: fmt.Sprintf("生成HTML报告失败: %v", err)})
+		return
+	}
+
+	response := ReportResponse{
+		Status:    "success",
+		Message:   "报告生成完成",
+		ExcelFile: storage.URL(excelFile),
+		HTMLFile:  storage.URL(htmlFile),
+		Summary:   summary,
+	}
+	writeReportResponse(w, http.StatusOK, response)
+}
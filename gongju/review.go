@@ -0,0 +1,427 @@
+package gongju
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/utils"
+)
+
+// maxReviewItems 一个复核任务最多包含的条目数，行数更多时需要指定抽样数量
+const maxReviewItems = 5000
+
+// MetricAgreement 人工评分与一个自动指标的一致性。人工评分按满分归一化到 0-1 后比较；
+// kappa 将两者按分数线分为通过与不通过两类计算。数据不足时相关系数为空
+type MetricAgreement struct {
+	Column   string   `json:"column"`
+	N        int      `json:"n"`
+	Pearson  *float64 `json:"pearson"`
+	Spearman *float64 `json:"spearman"`
+	Kappa    *float64 `json:"kappa"`
+}
+
+// ReviewResponse 人工复核接口的响应结构
+type ReviewResponse struct {
+	Status      string              `json:"status"`
+	Message     string              `json:"message,omitempty"`
+	Task        *models.ReviewTask  `json:"task,omitempty"`
+	Tasks       []models.ReviewTask `json:"tasks,omitempty"`
+	Item        *models.ReviewItem  `json:"item,omitempty"`
+	Agreement   []MetricAgreement   `json:"agreement,omitempty"`
+	LabelCounts map[string]int      `json:"labelCounts,omitempty"`
+}
+
+func writeReviewResponse(w http.ResponseWriter, status int, response ReviewResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// splitList 解析逗号分隔的参数，去掉空项与重复项
+func splitList(value string) []string {
+	items := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" && !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CreateReview 为已完成的评分任务创建人工复核任务。参数 jobId 为评分任务ID（自己的任务，或有 editor 角色的项目中的任务）；reviewers 为复核人
+// （逗号分隔，默认为自己，须为已有用户，项目中的任务还须是项目成员），条目按顺序轮流分配；sampleSize 为随机抽样条数（默认全部），seed 为随机种子；
+// scale 为人工评分的满分（默认1），threshold 为 kappa 的通过分数线（0-1，默认0.5）；
+// labels 为可选标签（逗号分隔）；name 为任务名称
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	owner := jobs.OwnerFrom(r.Context())
+	job, children, ok := jobs.Get(strings.TrimSpace(r.FormValue("jobId")))
+	if !ok || !jobs.CanAccess(owner, job, models.ProjectRoleEditor) {
+		writeReviewResponse(w, http.StatusNotFound, ReviewResponse{Status: "error", Message: "任务不存在"})
+		return
+	}
+	sources, err := reportSources(job, children)
+	if err != nil {
+		writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	task := &models.ReviewTask{
+		Name:         formValueOr(r, "name", job.Name),
+		JobID:        job.ID,
+		Metric:       sources[0].result.Metric,
+		ScoreColumns: sources[0].result.ScoreColumns,
+		Reviewers:    splitList(r.FormValue("reviewers")),
+		Labels:       splitList(r.FormValue("labels")),
+		Scale:        1,
+		Threshold:    0.5,
+		CreatedBy:    owner,
+	}
+	if len(task.Reviewers) == 0 {
+		task.Reviewers = []string{owner}
+	}
+	if status, err := checkReviewers(job, task.Reviewers); err != nil {
+		writeReviewResponse(w, status, ReviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	if v := strings.TrimSpace(r.FormValue("scale")); v != "" {
+		if task.Scale, err = strconv.ParseFloat(v, 64); err != nil || task.Scale <= 0 {
+			writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{Status: "error", Message: "满分必须为正数"})
+			return
+		}
+	}
+	if v := strings.TrimSpace(r.FormValue("threshold")); v != "" {
+		if task.Threshold, err = strconv.ParseFloat(v, 64); err != nil || task.Threshold < 0 || task.Threshold > 1 {
+			writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{Status: "error", Message: "分数线应在0到1之间"})
+			return
+		}
+	}
+
+	items, err := loadReviewItems(sources)
+	if err != nil {
+		writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	sampleSize, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("sampleSize")))
+	if sampleSize > 0 && sampleSize < len(items) {
+		seed, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("seed")), 10, 64)
+		if err != nil {
+			seed = time.Now().UnixNano()
+		}
+		items = sampleReviewItems(items, sampleSize, rand.New(rand.NewSource(seed)))
+	}
+	if len(items) > maxReviewItems {
+		writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("共 %d 条计分行，超过 %d 条，请指定抽样数量", len(items), maxReviewItems),
+		})
+		return
+	}
+
+	for i := range items {
+		items[i].Seq = i + 1
+		items[i].Reviewer = task.Reviewers[i%len(task.Reviewers)]
+	}
+	if err := task.Create(items); err != nil {
+		writeReviewResponse(w, http.StatusInternalServerError, ReviewResponse{Status: "error", Message: "保存复核任务失败"})
+		return
+	}
+	writeReviewResponse(w, http.StatusOK, ReviewResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("已创建复核任务，共 %d 条，分配给 %d 位复核人", len(items), len(task.Reviewers)),
+		Task:    task,
+	})
+}
+
+// checkReviewers 检查复核人都是启用的用户；项目中的任务要求复核人至少有项目 viewer 角色，
+// 避免把项目数据分配给项目外的人。出错时同时返回建议的HTTP状态码
+func checkReviewers(job *jobs.Job, reviewers []string) (int, error) {
+	for _, name := range reviewers {
+		user, err := models.GetUserByUsername(name)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("查询复核人失败")
+		}
+		if user == nil || user.Disabled {
+			return http.StatusBadRequest, fmt.Errorf("复核人 %s 不存在", name)
+		}
+		if job.ProjectID == 0 {
+			continue
+		}
+		ok, err := models.HasProjectAccess(user, job.ProjectID, models.ProjectRoleViewer)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("查询复核人的项目角色失败")
+		}
+		if !ok {
+			return http.StatusBadRequest, fmt.Errorf("复核人 %s 不是任务所属项目的成员", name)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// loadReviewItems 从评分结果文件中读取计分行的标准答案、预测与各分数列
+func loadReviewItems(sources []reportSource) ([]models.ReviewItem, error) {
+	items := make([]models.ReviewItem, 0)
+	for _, source := range sources {
+		spec := source.result.spec
+		reader, err := utils.OpenDataset(source.result.file.Path, utils.DatasetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source.name, err)
+		}
+
+		var scoreCols []int
+		for rowIndex := 0; ; rowIndex++ {
+			row, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				reader.Close()
+				return nil, fmt.Errorf("%s: %v", source.name, err)
+			}
+			if rowIndex == 0 {
+				scoreCols = make([]int, len(source.result.ScoreColumns))
+				for i, name := range source.result.ScoreColumns {
+					if scoreCols[i], err = resolveColumn(row, len(row), name); err != nil {
+						reader.Close()
+						return nil, fmt.Errorf("%s: 分数%v", source.name, err)
+					}
+				}
+				continue
+			}
+
+			// 未计分的行没有分数，不参与复核
+			scores := make([]float64, len(scoreCols))
+			scored := true
+			for i, col := range scoreCols {
+				if scores[i], err = strconv.ParseFloat(cellAt(row, col), 64); err != nil {
+					scored = false
+					break
+				}
+			}
+			if !scored {
+				continue
+			}
+
+			item := models.ReviewItem{
+				RowID:      spec.rowID(row, rowIndex+1),
+				References: spec.references(row),
+				Prediction: cellAt(row, spec.predCol),
+				Scores:     scores,
+			}
+			if len(sources) > 1 {
+				item.Source = source.name
+			}
+			items = append(items, item)
+		}
+		reader.Close()
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("结果文件中没有计分的行")
+	}
+	return items, nil
+}
+
+// sampleReviewItems 随机抽取 n 条，保持原来的先后顺序
+func sampleReviewItems(items []models.ReviewItem, n int, rng *rand.Rand) []models.ReviewItem {
+	picked := rng.Perm(len(items))[:n]
+	sort.Ints(picked)
+	sample := make([]models.ReviewItem, n)
+	for i, idx := range picked {
+		sample[i] = items[idx]
+	}
+	return sample
+}
+
+// reviewTaskFor 按参数查询复核任务，只有创建人和复核人可以访问
+func reviewTaskFor(r *http.Request, param string) (*models.ReviewTask, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue(param)))
+	if err != nil {
+		return nil, fmt.Errorf("复核任务不存在")
+	}
+	task, err := models.GetReviewTask(id)
+	if err != nil || task == nil || !task.HasMember(jobs.OwnerFrom(r.Context())) {
+		return nil, fmt.Errorf("复核任务不存在")
+	}
+	return task, nil
+}
+
+// ListReviews 查询复核任务：带 id 参数时返回该任务及人工评分与各自动指标的一致性，
+// 否则列出自己创建或参与的任务
+func ListReviews(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("id") == "" {
+		tasks, err := models.ListReviewTasks(jobs.OwnerFrom(r.Context()))
+		if err != nil {
+			writeReviewResponse(w, http.StatusInternalServerError, ReviewResponse{Status: "error", Message: "查询复核任务失败"})
+			return
+		}
+		writeReviewResponse(w, http.StatusOK, ReviewResponse{Status: "success", Tasks: tasks})
+		return
+	}
+
+	task, err := reviewTaskFor(r, "id")
+	if err != nil {
+		writeReviewResponse(w, http.StatusNotFound, ReviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	items, err := models.ListReviewedItems(task.ID)
+	if err != nil {
+		writeReviewResponse(w, http.StatusInternalServerError, ReviewResponse{Status: "error", Message: "查询复核结果失败"})
+		return
+	}
+	agreement, labelCounts := reviewAgreement(task, items)
+	writeReviewResponse(w, http.StatusOK, ReviewResponse{
+		Status:      "success",
+		Task:        task,
+		Agreement:   agreement,
+		LabelCounts: labelCounts,
+	})
+}
+
+// reviewAgreement 计算已复核条目中人工评分与各自动指标的一致性，并统计各标签的条数
+func reviewAgreement(task *models.ReviewTask, items []models.ReviewItem) ([]MetricAgreement, map[string]int) {
+	labelCounts := make(map[string]int)
+	human := make([]float64, 0, len(items))
+	scored := make([]models.ReviewItem, 0, len(items))
+	for _, item := range items {
+		if item.Label != "" {
+			labelCounts[item.Label]++
+		}
+		if item.HumanScore != nil {
+			human = append(human, *item.HumanScore/task.Scale)
+			scored = append(scored, item)
+		}
+	}
+
+	passFail := func(v float64) string {
+		if v >= task.Threshold {
+			return "通过"
+		}
+		return "不通过"
+	}
+	humanClasses := make([]string, len(human))
+	for i, v := range human {
+		humanClasses[i] = passFail(v)
+	}
+
+	agreement := make([]MetricAgreement, len(task.ScoreColumns))
+	for j, column := range task.ScoreColumns {
+		auto := make([]float64, 0, len(scored))
+		autoClasses := make([]string, 0, len(scored))
+		for _, item := range scored {
+			v := math.NaN()
+			if j < len(item.Scores) {
+				v = item.Scores[j]
+			}
+			auto = append(auto, v)
+			autoClasses = append(autoClasses, passFail(v))
+		}
+		agreement[j] = MetricAgreement{
+			Column:   column,
+			N:        len(scored),
+			Pearson:  finite(pearson(human, auto)),
+			Spearman: finite(spearman(human, auto)),
+			Kappa:    finite(cohenKappa(humanClasses, autoClasses)),
+		}
+	}
+	return agreement, labelCounts
+}
+
+// finite 将 NaN 和无穷大转换为空值，便于 JSON 输出
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// NextReviewItem 返回当前用户在复核任务（参数 taskId）中的下一条待复核条目，全部完成时 item 为空
+func NextReviewItem(w http.ResponseWriter, r *http.Request) {
+	task, err := reviewTaskFor(r, "taskId")
+	if err != nil {
+		writeReviewResponse(w, http.StatusNotFound, ReviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	item, err := models.NextReviewItem(task.ID, jobs.OwnerFrom(r.Context()))
+	if err != nil {
+		writeReviewResponse(w, http.StatusInternalServerError, ReviewResponse{Status: "error", Message: "查询复核条目失败"})
+		return
+	}
+	response := ReviewResponse{Status: "success", Task: task, Item: item}
+	if item == nil {
+		response.Message = "分配给你的条目已全部复核"
+	}
+	writeReviewResponse(w, http.StatusOK, response)
+}
+
+// SubmitReview 提交一条复核结果。参数 itemId 为条目ID，label 为标签，score 为人工评分
+// （0 到任务满分），comment 为备注；标签与评分至少填写一项。只能提交分配给自己的条目
+func SubmitReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("itemId")))
+	item, err := models.GetReviewItem(id)
+	if err != nil || item == nil || item.Reviewer != jobs.OwnerFrom(r.Context()) {
+		writeReviewResponse(w, http.StatusNotFound, ReviewResponse{Status: "error", Message: "复核条目不存在"})
+		return
+	}
+	task, err := models.GetReviewTask(item.TaskID)
+	if err != nil || task == nil {
+		writeReviewResponse(w, http.StatusNotFound, ReviewResponse{Status: "error", Message: "复核任务不存在"})
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label != "" && len(task.Labels) > 0 && !containsString(task.Labels, label) {
+		writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("标签应为: %s", strings.Join(task.Labels, ", ")),
+		})
+		return
+	}
+	var score *float64
+	if v := strings.TrimSpace(r.FormValue("score")); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 || parsed > task.Scale {
+			writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{
+				Status:  "error",
+				Message: fmt.Sprintf("评分应在0到%g之间", task.Scale),
+			})
+			return
+		}
+		score = &parsed
+	}
+	if label == "" && score == nil {
+		writeReviewResponse(w, http.StatusBadRequest, ReviewResponse{Status: "error", Message: "请填写标签或评分"})
+		return
+	}
+
+	if err := item.Submit(label, score, strings.TrimSpace(r.FormValue("comment"))); err != nil {
+		writeReviewResponse(w, http.StatusInternalServerError, ReviewResponse{Status: "error", Message: "保存复核结果失败"})
+		return
+	}
+	writeReviewResponse(w, http.StatusOK, ReviewResponse{Status: "success", Message: "已保存", Item: item})
+}
+
+// containsString 判断切片中是否包含指定字符串
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
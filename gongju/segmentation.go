@@ -0,0 +1,495 @@
+package gongju
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+
+	"github.com/go-ego/gse"
+)
+
+const (
+	// 项目分词配置目录：gongju/seg/<项目ID>/userdict.txt 与 stopwords.txt
+	segProfileDir = "gongju/seg"
+	// 最多缓存的项目分词器数，每个分词器都加载一份完整的默认词典
+	maxSegProfiles = 4
+
+	userDictFileName  = "userdict.txt"
+	stopWordsFileName = "stopwords.txt"
+)
+
+var (
+	// 词林词典与默认停用词表，相对于服务的工作目录
+	cilinDictPath        = "gongju/cilin.txt"
+	defaultStopWordsPath = "gongju/stopwords.txt"
+
+	// 默认停用词，在 initialize 中加载
+	defaultStopWords = make(map[string]bool)
+
+	// 已加载的项目分词器缓存，key 为项目ID
+	segProfiles = newFileCache(maxSegProfiles)
+)
+
+// segProfile 项目的分词配置：加载了用户词典的分词器和停用词表
+type segProfile struct {
+	seg       *gse.Segmenter
+	stopWords map[string]bool
+}
+
+// tokenFilter 统计F1时对分词结果的过滤规则
+type tokenFilter struct {
+	stopWords    map[string]bool
+	excludeStop  bool // 排除停用词
+	excludePunct bool // 排除标点、符号和空白
+}
+
+// SegToken 分词预览中的单个词
+type SegToken struct {
+	Word    string `json:"word"`
+	Stop    bool   `json:"stop"`
+	Punct   bool   `json:"punct"`
+	Counted bool   `json:"counted"` // 是否参与F1统计
+}
+
+// SegProfileInfo 项目分词配置信息
+type SegProfileInfo struct {
+	ProjectID int       `json:"projectId"`
+	UserWords int       `json:"userWords"`
+	StopWords int       `json:"stopWords"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SegPreviewRequest 分词预览请求
+type SegPreviewRequest struct {
+	Text         string `json:"text"`
+	ProjectID    int    `json:"projectId"`
+	ExcludeStop  bool   `json:"excludeStop"`
+	ExcludePunct bool   `json:"excludePunct"`
+}
+
+// SegPreviewResponse 分词预览响应
+type SegPreviewResponse struct {
+	Status  string     `json:"status"`
+	Message string     `json:"message"`
+	Tokens  []SegToken `json:"tokens,omitempty"`
+	Counted []string   `json:"counted,omitempty"`
+}
+
+// SegProfileResponse 项目分词配置接口响应
+type SegProfileResponse struct {
+	Status   string           `json:"status"`
+	Message  string           `json:"message"`
+	Profiles []SegProfileInfo `json:"profiles,omitempty"`
+}
+
+// newTokenFilter 根据请求参数创建分词过滤规则
+func newTokenFilter(stopWords map[string]bool, r *http.Request) tokenFilter {
+	return tokenFilter{
+		stopWords:    stopWords,
+		excludeStop:  formBool(r.FormValue("excludeStop")),
+		excludePunct: formBool(r.FormValue("excludePunct")),
+	}
+}
+
+// formBool 解析表单中的布尔值
+func formBool(value string) bool {
+	b, _ := strconv.ParseBool(strings.TrimSpace(value))
+	return b || value == "on"
+}
+
+// isPunctToken 判断词是否只由标点、符号或空白组成
+func isPunctToken(word string) bool {
+	for _, r := range word {
+		if !unicode.IsPunct(r) && !unicode.IsSymbol(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// keep 判断词是否参与F1统计
+func (f tokenFilter) keep(word string) bool {
+	if f.excludePunct && isPunctToken(word) {
+		return false
+	}
+	if f.excludeStop && f.stopWords[word] {
+		return false
+	}
+	return true
+}
+
+// apply 过滤分词结果
+func (f tokenFilter) apply(words []string) []string {
+	if !f.excludeStop && !f.excludePunct {
+		return words
+	}
+	kept := make([]string, 0, len(words))
+	for _, word := range words {
+		if f.keep(word) {
+			kept = append(kept, word)
+		}
+	}
+	return kept
+}
+
+// loadWordList 读取每行一个词的词表，忽略空行和 # 注释，返回词及其附加字段
+func loadWordList(reader io.Reader) ([][]string, error) {
+	entries := make([][]string, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Fields(line))
+	}
+	return entries, scanner.Err()
+}
+
+// loadStopWordFile 加载停用词文件到 stopWords
+func loadStopWordFile(path string, stopWords map[string]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := loadWordList(file)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		stopWords[entry[0]] = true
+	}
+	return nil
+}
+
+// addUserDict 将用户词典加入分词器。每行格式为 "词 [词频] [词性]"，未给出词频时自动推荐一个
+// 能保证该词不被切分的词频
+func addUserDict(seg *gse.Segmenter, entries [][]string) error {
+	for _, entry := range entries {
+		word := entry[0]
+		freq := 0.0
+		if len(entry) > 1 {
+			freq, _ = strconv.ParseFloat(entry[1], 64)
+		}
+		if freq <= 0 {
+			freq = seg.SuggestFreq(word)
+		}
+		pos := ""
+		if len(entry) > 2 {
+			pos = entry[2]
+		}
+		if err := seg.AddToken(word, freq, pos); err != nil {
+			return fmt.Errorf("添加词 %q 失败: %v", word, err)
+		}
+	}
+	seg.CalcToken()
+	return nil
+}
+
+// segProfilePaths 返回项目的用户词典与停用词文件路径
+func segProfilePaths(projectID int) (string, string) {
+	dir := filepath.Join(segProfileDir, strconv.Itoa(projectID))
+	return filepath.Join(dir, userDictFileName), filepath.Join(dir, stopWordsFileName)
+}
+
+// fileMTimes 返回存在的文件的修改时间
+func fileMTimes(paths ...string) map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			mtimes[path] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// segmenterFor 返回项目使用的分词器与停用词表，projectID 为0或项目没有分词配置时使用默认分词器。
+// 项目分词器按配置文件的修改时间缓存，配置更新后下一次请求自动重新加载
+func segmenterFor(projectID int) (*gse.Segmenter, map[string]bool, error) {
+	ensureInitialized()
+	if projectID <= 0 {
+		return &segmenter, defaultStopWords, nil
+	}
+
+	dictPath, stopPath := segProfilePaths(projectID)
+	mtimes := fileMTimes(dictPath, stopPath)
+	if len(mtimes) == 0 {
+		return &segmenter, defaultStopWords, nil
+	}
+
+	profile, err := segProfiles.get(strconv.Itoa(projectID), mtimes, func() (interface{}, error) {
+		return loadSegProfile(projectID, dictPath, stopPath, mtimes)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	p := profile.(*segProfile)
+	return p.seg, p.stopWords, nil
+}
+
+// loadSegProfile 加载默认词典与项目的用户词典、停用词表，mtimes 中没有的文件跳过
+func loadSegProfile(projectID int, dictPath, stopPath string, mtimes map[string]time.Time) (*segProfile, error) {
+	startTime := time.Now()
+	seg := &gse.Segmenter{SkipLog: true}
+	if err := seg.LoadDict(); err != nil {
+		return nil, fmt.Errorf("加载默认分词词典失败: %v", err)
+	}
+
+	if _, ok := mtimes[dictPath]; ok {
+		file, err := os.Open(dictPath)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := loadWordList(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取用户词典失败: %v", err)
+		}
+		if err := addUserDict(seg, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	// 项目停用词在默认停用词的基础上追加
+	stopWords := make(map[string]bool, len(defaultStopWords))
+	for word := range defaultStopWords {
+		stopWords[word] = true
+	}
+	if _, ok := mtimes[stopPath]; ok {
+		if err := loadStopWordFile(stopPath, stopWords); err != nil {
+			return nil, fmt.Errorf("读取停用词表失败: %v", err)
+		}
+	}
+
+	log.Printf("已加载项目 %d 的分词配置，耗时: %v", projectID, time.Since(startTime))
+	return &segProfile{seg: seg, stopWords: stopWords}, nil
+}
+
+// hasSegProfile 判断项目是否上传了分词配置
+func hasSegProfile(projectID int) bool {
+	if projectID <= 0 {
+		return false
+	}
+	return len(fileMTimes(segProfilePaths(projectID))) > 0
+}
+
+// writeSegProfileResponse 写入项目分词配置接口的JSON响应
+func writeSegProfileResponse(w http.ResponseWriter, status int, response SegProfileResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// countWordList 统计词表文件中的词数，文件不存在时返回0
+func countWordList(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	entries, _ := loadWordList(file)
+	return len(entries)
+}
+
+// ListSegProfiles 列出当前用户所属项目的分词配置
+func ListSegProfiles(w http.ResponseWriter, r *http.Request) {
+	visible, err := visibleProjects(r)
+	if err != nil {
+		writeSegProfileResponse(w, http.StatusInternalServerError, SegProfileResponse{Status: "error", Message: "查询用户项目失败"})
+		return
+	}
+	dirs, err := filepath.Glob(filepath.Join(segProfileDir, "*"))
+	if err != nil {
+		writeSegProfileResponse(w, http.StatusInternalServerError, SegProfileResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	profiles := make([]SegProfileInfo, 0)
+	for _, dir := range dirs {
+		projectID, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil || projectID <= 0 {
+			continue
+		}
+		if visible != nil && !containsInt(visible, projectID) {
+			continue
+		}
+		profiles = append(profiles, segProfileInfo(projectID))
+	}
+
+	writeSegProfileResponse(w, http.StatusOK, SegProfileResponse{Status: "success", Message: "查询成功", Profiles: profiles})
+}
+
+// segProfileInfo 统计项目分词配置的词数与更新时间
+func segProfileInfo(projectID int) SegProfileInfo {
+	dictPath, stopPath := segProfilePaths(projectID)
+	info := SegProfileInfo{
+		ProjectID: projectID,
+		UserWords: countWordList(dictPath),
+		StopWords: countWordList(stopPath),
+	}
+	for _, t := range fileMTimes(dictPath, stopPath) {
+		if t.After(info.UpdatedAt) {
+			info.UpdatedAt = t
+		}
+	}
+	return info
+}
+
+// saveWordListUpload 校验并保存上传的词表文件，未上传时返回 false
+func saveWordListUpload(r *http.Request, field, path string) (int, bool, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return 0, false, nil
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return 0, true, fmt.Errorf("读取文件失败: %v", err)
+	}
+	entries, err := loadWordList(strings.NewReader(string(content)))
+	if err != nil {
+		return 0, true, fmt.Errorf("解析词表失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, true, fmt.Errorf("创建配置目录失败: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return 0, true, fmt.Errorf("保存词表失败: %v", err)
+	}
+	return len(entries), true, nil
+}
+
+// UploadSegProfile 上传项目 projectId 的用户词典（userDict）和/或停用词表（stopWords），需要项目 editor 角色，
+// 无需重启即可生效
+func UploadSegProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, status, msg := projects.Require(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeSegProfileResponse(w, status, SegProfileResponse{Status: "error", Message: msg})
+		return
+	}
+	dictPath, stopPath := segProfilePaths(projectID)
+
+	userWords, hasDict, err := saveWordListUpload(r, "userDict", dictPath)
+	if err != nil {
+		writeSegProfileResponse(w, http.StatusBadRequest, SegProfileResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	stopWords, hasStop, err := saveWordListUpload(r, "stopWords", stopPath)
+	if err != nil {
+		writeSegProfileResponse(w, http.StatusBadRequest, SegProfileResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	if !hasDict && !hasStop {
+		writeSegProfileResponse(w, http.StatusBadRequest, SegProfileResponse{Status: "error", Message: "请上传用户词典或停用词表"})
+		return
+	}
+	log.Printf("已更新项目 %d 的分词配置，用户词 %d 个，停用词 %d 个", projectID, userWords, stopWords)
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditConfigChange, fmt.Sprintf("seg:%d", projectID),
+		fmt.Sprintf("更新分词配置，用户词 %d 个，停用词 %d 个", userWords, stopWords))
+
+	writeSegProfileResponse(w, http.StatusOK, SegProfileResponse{
+		Status:   "success",
+		Message:  "分词配置已更新",
+		Profiles: []SegProfileInfo{segProfileInfo(projectID)},
+	})
+}
+
+// DeleteSegProfile 删除项目 projectId 的分词配置，需要项目 editor 角色
+func DeleteSegProfile(w http.ResponseWriter, r *http.Request) {
+	projectID, status, msg := projects.Require(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeSegProfileResponse(w, status, SegProfileResponse{Status: "error", Message: msg})
+		return
+	}
+
+	dir := filepath.Join(segProfileDir, strconv.Itoa(projectID))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		writeSegProfileResponse(w, http.StatusNotFound, SegProfileResponse{Status: "error", Message: "项目分词配置不存在"})
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		writeSegProfileResponse(w, http.StatusInternalServerError, SegProfileResponse{Status: "error", Message: "删除分词配置失败"})
+		return
+	}
+
+	segProfiles.remove(strconv.Itoa(projectID), nil)
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditConfigChange, fmt.Sprintf("seg:%d", projectID), "删除分词配置")
+
+	writeSegProfileResponse(w, http.StatusOK, SegProfileResponse{Status: "success", Message: "分词配置已删除"})
+}
+
+// PreviewSegmentation 预览句子的分词结果，标出停用词、标点以及参与F1统计的词
+func PreviewSegmentation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req SegPreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SegPreviewResponse{Status: "error", Message: "参数解析失败"})
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SegPreviewResponse{Status: "error", Message: "文本不能为空"})
+		return
+	}
+
+	if req.ProjectID > 0 {
+		if status, msg := projects.Check(r, req.ProjectID, models.ProjectRoleViewer); status != http.StatusOK {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(SegPreviewResponse{Status: "error", Message: msg})
+			return
+		}
+	}
+	seg, stopWords, err := segmenterFor(req.ProjectID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SegPreviewResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	filter := tokenFilter{stopWords: stopWords, excludeStop: req.ExcludeStop, excludePunct: req.ExcludePunct}
+	tokens := make([]SegToken, 0)
+	counted := make([]string, 0)
+	for _, word := range seg.Cut(req.Text, true) {
+		token := SegToken{
+			Word:    word,
+			Stop:    stopWords[word],
+			Punct:   isPunctToken(word),
+			Counted: filter.keep(word),
+		}
+		if token.Counted {
+			counted = append(counted, word)
+		}
+		tokens = append(tokens, token)
+	}
+
+	json.NewEncoder(w).Encode(SegPreviewResponse{
+		Status:  "success",
+		Message: "分词完成",
+		Tokens:  tokens,
+		Counted: counted,
+	})
+}
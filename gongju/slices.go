@@ -0,0 +1,161 @@
+package gongju
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// refLengthSlice 按标准答案长度自动分桶的分组名称
+const refLengthSlice = "标准答案长度"
+
+// refLengthBuckets 标准答案长度（字符数）的分桶上界
+var refLengthBuckets = []int{10, 30, 100, 300}
+
+// parseSliceColumns 解析 sliceColumns 参数（逗号分隔的表头名称或列字母），按这些列分组统计
+func parseSliceColumns(r *http.Request) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(r.FormValue("sliceColumns"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// lengthBucket 返回文本长度所在的分桶标签，如 "11-30字"
+func lengthBucket(text string) string {
+	n := utf8.RuneCountInString(text)
+	for i, upper := range refLengthBuckets {
+		if n <= upper {
+			return lengthBucketLabel(i)
+		}
+	}
+	return lengthBucketLabel(len(refLengthBuckets))
+}
+
+// lengthBucketLabel 返回第 i 个长度分桶的标签
+func lengthBucketLabel(i int) string {
+	switch {
+	case i == 0:
+		return fmt.Sprintf("≤%d字", refLengthBuckets[0])
+	case i < len(refLengthBuckets):
+		return fmt.Sprintf("%d-%d字", refLengthBuckets[i-1]+1, refLengthBuckets[i])
+	default:
+		return fmt.Sprintf(">%d字", refLengthBuckets[len(refLengthBuckets)-1])
+	}
+}
+
+// sliceNames 返回分组名称：用户指定的列在前，标准答案长度分桶在后
+func (s referenceSpec) sliceNames(headers []string) []string {
+	names := make([]string, 0, len(s.sliceCols)+1)
+	for _, col := range s.sliceCols {
+		name := cellAt(headers, col)
+		if name == "" {
+			name = columnLetter(col)
+		}
+		names = append(names, name)
+	}
+	return append(names, refLengthSlice)
+}
+
+// sliceValues 返回一行在各分组中的取值，长度分桶使用最长的标准答案
+func (s referenceSpec) sliceValues(row []string, refs []string) []string {
+	values := make([]string, 0, len(s.sliceCols)+1)
+	for _, col := range s.sliceCols {
+		values = append(values, groupValue(cellAt(row, col)))
+	}
+	longest := ""
+	for _, ref := range refs {
+		if utf8.RuneCountInString(ref) > utf8.RuneCountInString(longest) {
+			longest = ref
+		}
+	}
+	return append(values, lengthBucket(longest))
+}
+
+// buildSlices 对所有计分行按各分组统计
+func buildSlices(names []string, scoreCount int, rows []reportRow) []SliceSummary {
+	slices := make([]SliceSummary, len(names))
+	for i, name := range names {
+		slices[i] = summarizeSlice(name, i, scoreCount, rows)
+	}
+	return slices
+}
+
+// mergeSlices 合并多个子任务的分组统计，同名分组中相同取值的组按行数加权平均
+func mergeSlices(sets ...[]SliceSummary) []SliceSummary {
+	type accumulator struct {
+		count int
+		sums  []float64
+	}
+	merged := make([]SliceSummary, 0)
+	index := make(map[string]int)
+	groups := make(map[string]map[string]*accumulator)
+	order := make(map[string][]string)
+
+	for _, set := range sets {
+		for _, slice := range set {
+			if _, ok := index[slice.Name]; !ok {
+				index[slice.Name] = len(merged)
+				merged = append(merged, SliceSummary{Name: slice.Name})
+				groups[slice.Name] = make(map[string]*accumulator)
+			}
+			for _, group := range slice.Groups {
+				acc, ok := groups[slice.Name][group.Value]
+				if !ok {
+					acc = &accumulator{sums: make([]float64, len(group.Means))}
+					groups[slice.Name][group.Value] = acc
+					order[slice.Name] = append(order[slice.Name], group.Value)
+				}
+				acc.count += group.Count
+				for i, m := range group.Means {
+					if i < len(acc.sums) {
+						acc.sums[i] += m * float64(group.Count)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range merged {
+		name := merged[i].Name
+		for _, value := range order[name] {
+			acc := groups[name][value]
+			means := make([]float64, len(acc.sums))
+			for j, sum := range acc.sums {
+				means[j] = sum / float64(acc.count)
+			}
+			merged[i].Groups = append(merged[i].Groups, SliceGroup{Value: value, Count: acc.count, Means: means})
+		}
+		sortSlice(&merged[i])
+	}
+	return merged
+}
+
+// sortSlice 长度分桶按区间顺序排列，其他分组按行数降序排列
+func sortSlice(slice *SliceSummary) {
+	if slice.Name == refLengthSlice {
+		rank := make(map[string]int, len(refLengthBuckets)+1)
+		for i := 0; i <= len(refLengthBuckets); i++ {
+			rank[lengthBucketLabel(i)] = i
+		}
+		sort.SliceStable(slice.Groups, func(i, j int) bool {
+			return rank[slice.Groups[i].Value] < rank[slice.Groups[j].Value]
+		})
+		return
+	}
+	sort.SliceStable(slice.Groups, func(i, j int) bool {
+		return slice.Groups[i].Count > slice.Groups[j].Count
+	})
+}
+
+// columnLetter 将列下标（从0开始）转换为列字母
+func columnLetter(col int) string {
+	letter, _ := excelize.ColumnNumberToName(col + 1)
+	return letter
+}
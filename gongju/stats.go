@@ -0,0 +1,161 @@
+package gongju
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// mean 计算平均值
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// percentile 计算已排序切片的分位数（线性插值），q 取值 0-1
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// bootstrapMeanCI 使用百分位 bootstrap 方法估计平均值的置信区间
+func bootstrapMeanCI(values []float64, iterations int, confidence float64, rng *rand.Rand) (float64, float64) {
+	n := len(values)
+	if n == 0 || iterations <= 0 {
+		return 0, 0
+	}
+
+	means := make([]float64, iterations)
+	for it := 0; it < iterations; it++ {
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += values[rng.Intn(n)]
+		}
+		means[it] = sum / float64(n)
+	}
+	sort.Float64s(means)
+
+	alpha := (1 - confidence) / 2
+	return percentile(means, alpha), percentile(means, 1-alpha)
+}
+
+// pairedPermutationTest 配对置换检验（随机翻转每对差值的符号），返回双侧 p 值
+func pairedPermutationTest(diffs []float64, permutations int, rng *rand.Rand) float64 {
+	n := len(diffs)
+	if n == 0 || permutations <= 0 {
+		return 1
+	}
+
+	observed := math.Abs(mean(diffs))
+	extreme := 0
+	for p := 0; p < permutations; p++ {
+		sum := 0.0
+		for _, d := range diffs {
+			if rng.Intn(2) == 0 {
+				sum += d
+			} else {
+				sum -= d
+			}
+		}
+		// 加入微小容差，避免浮点误差导致与观测值相等的情况被漏计
+		if math.Abs(sum/float64(n)) >= observed-1e-12 {
+			extreme++
+		}
+	}
+	return float64(extreme+1) / float64(permutations+1)
+}
+
+// pearson 计算两组数据的皮尔逊相关系数，任一组方差为0时返回 NaN
+func pearson(x, y []float64) float64 {
+	n := len(x)
+	if n < 2 || n != len(y) {
+		return math.NaN()
+	}
+	mx, my := mean(x), mean(y)
+	var sxy, sxx, syy float64
+	for i := 0; i < n; i++ {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// spearman 计算斯皮尔曼等级相关系数（并列值取平均秩）
+func spearman(x, y []float64) float64 {
+	if len(x) != len(y) {
+		return math.NaN()
+	}
+	return pearson(ranks(x), ranks(y))
+}
+
+// ranks 返回每个值的秩（从1开始），并列值取平均秩
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return values[order[a]] < values[order[b]]
+	})
+
+	result := make([]float64, len(values))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && values[order[j+1]] == values[order[i]] {
+			j++
+		}
+		rank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			result[order[k]] = rank
+		}
+		i = j + 1
+	}
+	return result
+}
+
+// cohenKappa 计算两组分类结果的 Cohen's kappa 系数，期望一致率为1时返回 NaN
+func cohenKappa(a, b []string) float64 {
+	n := len(a)
+	if n == 0 || n != len(b) {
+		return math.NaN()
+	}
+	agree := 0
+	countA := make(map[string]int)
+	countB := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if a[i] == b[i] {
+			agree++
+		}
+		countA[a[i]]++
+		countB[b[i]]++
+	}
+
+	observed := float64(agree) / float64(n)
+	expected := 0.0
+	for category, ca := range countA {
+		expected += float64(ca) / float64(n) * float64(countB[category]) / float64(n)
+	}
+	if expected == 1 {
+		return math.NaN()
+	}
+	return (observed - expected) / (1 - expected)
+}
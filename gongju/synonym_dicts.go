@@ -0,0 +1,522 @@
+package gongju
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/projects"
+)
+
+const (
+	// 自定义同义词词典的存放目录，按项目分子目录：gongju/dicts/<项目ID>/<name>.txt
+	customDictDir = "gongju/dicts"
+	// 最多缓存的合并词典数，每个合并词典都包含一份完整的词林
+	maxMergedDicts = 16
+)
+
+var (
+	// 词林格式的行首编码，例如 Aa01A01=
+	cilinCodePattern = regexp.MustCompile(`^[A-Za-z]{2}\d{2}[A-Za-z]\d{2}[=#@]?$`)
+	// name 只允许字母、数字、下划线、中划线和汉字，防止路径穿越
+	dictNamePattern = regexp.MustCompile(`^[\p{Han}A-Za-z0-9_\-]{1,64}$`)
+
+	// 合并后词典的缓存，key 为排序后的词典ID列表
+	mergedDicts = newFileCache(maxMergedDicts)
+)
+
+// CustomDictInfo 自定义词典信息
+type CustomDictInfo struct {
+	ID        string    `json:"id"`
+	ProjectID int       `json:"projectId"`
+	Name      string    `json:"name"`
+	Groups    int       `json:"groups"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DictResponse 自定义词典接口响应结构
+type DictResponse struct {
+	Status  string           `json:"status"`
+	Message string           `json:"message"`
+	Dicts   []CustomDictInfo `json:"dicts,omitempty"`
+}
+
+// newSynonymDict 创建空的同义词字典
+func newSynonymDict() *SynonymDict {
+	return &SynonymDict{
+		cilinMap: make(map[string]CiLinCode),
+		codemap:  make(map[string][]string),
+	}
+}
+
+// ensureInitialized 确保词林词典已加载
+func ensureInitialized() {
+	initLock.Do(func() {
+		if err := initialize(); err != nil {
+			log.Printf("初始化失败: %v", err)
+		}
+	})
+}
+
+// Clone 复制同义词字典，合并自定义词典时不会影响原字典
+func (sd *SynonymDict) Clone() *SynonymDict {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	clone := newSynonymDict()
+	clone.customSeq = sd.customSeq
+	for word, code := range sd.cilinMap {
+		clone.cilinMap[word] = code
+	}
+	for code, words := range sd.codemap {
+		clone.codemap[code] = append([]string(nil), words...)
+	}
+	return clone
+}
+
+// Merge 将另一个词典合并进来。词林编码相同的组取并集，自定义组分配新编码；
+// 同一个词出现在多个组时，以后合并的词典为准，便于领域词典覆盖词林
+func (sd *SynonymDict) Merge(other *SynonymDict) {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	for code, words := range other.codemap {
+		if strings.HasPrefix(code, customCodePrefix) {
+			sd.addCustomGroupLocked(words)
+			continue
+		}
+		cilinCode := ParseCiLinCode(code)
+		sd.codemap[code] = appendUnique(sd.codemap[code], words...)
+		for _, word := range words {
+			sd.cilinMap[word] = cilinCode
+		}
+	}
+}
+
+// 自定义组使用 Z 开头的编码，不与词林的 A-L 大类冲突，词性按"其他词"处理
+const customCodePrefix = "Z"
+
+// addCustomGroupLocked 添加一个自定义同义词组，调用方需持有写锁
+func (sd *SynonymDict) addCustomGroupLocked(words []string) {
+	sd.customSeq++
+	cilinCode := ParseCiLinCode(fmt.Sprintf("%s%06d", customCodePrefix, sd.customSeq))
+	code := cilinCode.String()
+	sd.codemap[code] = append([]string(nil), words...)
+	for _, word := range words {
+		sd.cilinMap[word] = cilinCode
+	}
+}
+
+// LoadCustomDict 从读取器加载自定义词典。每行一个同义词组，词之间用空格、制表符或逗号分隔；
+// 行首为词林编码（如 Aa01A01=）时，该行的词并入对应的词林组。以 # 开头的行为注释
+func (sd *SynonymDict) LoadCustomDict(reader io.Reader) (int, error) {
+	groups := 0
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '，'
+		})
+		// 只有分隔符的行没有词
+		if len(words) == 0 {
+			continue
+		}
+
+		sd.mu.Lock()
+		if cilinCodePattern.MatchString(words[0]) {
+			// 只有 = 标记的同义词组参与合并，相关词（#）和独立词（@）行忽略
+			if len(words) > 1 && isSynonymGroupCode(words[0]) {
+				cilinCode := ParseCiLinCode(words[0])
+				code := cilinCode.String()
+				sd.codemap[code] = appendUnique(sd.codemap[code], words[1:]...)
+				for _, word := range words[1:] {
+					sd.cilinMap[word] = cilinCode
+				}
+				groups++
+			}
+		} else if len(words) > 1 {
+			sd.addCustomGroupLocked(words)
+			groups++
+		}
+		sd.mu.Unlock()
+	}
+
+	return groups, scanner.Err()
+}
+
+// appendUnique 追加切片中尚不存在的词
+func appendUnique(list []string, words ...string) []string {
+	for _, word := range words {
+		exists := false
+		for _, w := range list {
+			if w == word {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			list = append(list, word)
+		}
+	}
+	return list
+}
+
+// parseDictIDs 解析逗号分隔的词典ID列表，ID格式为 项目ID/名称
+func parseDictIDs(value string) []string {
+	ids := make([]string, 0)
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// customDictPath 校验词典名称并返回项目 projectID 中该词典的文件路径
+func customDictPath(projectID int, name string) (string, error) {
+	if projectID <= 0 {
+		return "", fmt.Errorf("无效的项目ID: %d", projectID)
+	}
+	if !dictNamePattern.MatchString(name) {
+		return "", fmt.Errorf("无效的词典名称: %q", name)
+	}
+	return filepath.Join(customDictDir, strconv.Itoa(projectID), name+".txt"), nil
+}
+
+// splitDictID 将 项目ID/名称 形式的ID拆分
+func splitDictID(id string) (int, string, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("词典ID格式应为 项目ID/名称: %q", id)
+	}
+	projectID, err := strconv.Atoi(parts[0])
+	if err != nil || projectID <= 0 {
+		return 0, "", fmt.Errorf("词典ID格式应为 项目ID/名称: %q", id)
+	}
+	return projectID, parts[1], nil
+}
+
+// checkDictAccess 检查评分请求选择的词典都属于当前用户所在的项目（管理员不受限制）
+func checkDictAccess(r *http.Request, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	visible, err := visibleProjects(r)
+	if err != nil {
+		return fmt.Errorf("查询用户项目失败: %v", err)
+	}
+	for _, id := range ids {
+		projectID, _, err := splitDictID(id)
+		if err != nil {
+			return err
+		}
+		if visible != nil && !containsInt(visible, projectID) {
+			return fmt.Errorf("词典 %s 不存在", id)
+		}
+	}
+	return nil
+}
+
+// containsInt 判断切片中是否包含 v
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// synonymDictFor 返回词林与所选自定义词典合并后的词典。
+// 结果按词典文件的修改时间缓存，文件被上传、修改或删除后下一次请求自动重新合并
+func synonymDictFor(ids []string) (*SynonymDict, error) {
+	ensureInitialized()
+	if len(ids) == 0 {
+		return globalSynonymDict, nil
+	}
+
+	ids = append([]string(nil), ids...)
+	sort.Strings(ids)
+	key := strings.Join(ids, ",")
+
+	// 收集依赖文件的当前修改时间
+	paths := make([]string, 0, len(ids))
+	mtimes := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		projectID, name, err := splitDictID(id)
+		if err != nil {
+			return nil, err
+		}
+		path, err := customDictPath(projectID, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("词典 %s 不存在", id)
+		}
+		paths = append(paths, path)
+		mtimes[path] = info.ModTime()
+	}
+
+	dict, err := mergedDicts.get(key, mtimes, func() (interface{}, error) {
+		merged := globalSynonymDict.Clone()
+		for i, path := range paths {
+			custom := newSynonymDict()
+			if err := loadCustomDictFile(custom, path); err != nil {
+				return nil, fmt.Errorf("加载词典 %s 失败: %v", ids[i], err)
+			}
+			merged.Merge(custom)
+		}
+		log.Printf("已合并自定义同义词词典: %s", key)
+		return merged, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dict.(*SynonymDict), nil
+}
+
+// sameMTimes 判断两组文件修改时间是否一致
+func sameMTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, t := range a {
+		if !b[path].Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCustomDictFile 从文件加载自定义词典
+func loadCustomDictFile(sd *SynonymDict, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = sd.LoadCustomDict(file)
+	return err
+}
+
+// invalidateMergedDicts 清空合并词典缓存
+func invalidateMergedDicts() {
+	mergedDicts.clear()
+}
+
+// listCustomDicts 列出项目 projectIDs 中的自定义词典，projectIDs 为 nil 时列出全部
+func listCustomDicts(projectIDs []int) ([]CustomDictInfo, error) {
+	dicts := make([]CustomDictInfo, 0)
+	paths, err := filepath.Glob(filepath.Join(customDictDir, "*", "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		projectID, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil || projectID <= 0 {
+			continue
+		}
+		if projectIDs != nil && !containsInt(projectIDs, projectID) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".txt")
+
+		custom := newSynonymDict()
+		if err := loadCustomDictFile(custom, path); err != nil {
+			log.Printf("读取自定义词典失败 %s: %v", path, err)
+			continue
+		}
+
+		dicts = append(dicts, CustomDictInfo{
+			ID:        fmt.Sprintf("%d/%s", projectID, name),
+			ProjectID: projectID,
+			Name:      name,
+			Groups:    len(custom.codemap),
+			Size:      info.Size(),
+			UpdatedAt: info.ModTime(),
+		})
+	}
+	return dicts, nil
+}
+
+// writeDictResponse 写入自定义词典接口的JSON响应
+func writeDictResponse(w http.ResponseWriter, status int, response DictResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListSynonymDicts 列出当前用户所属项目的自定义同义词词典，可通过 projectId 参数筛选项目
+func ListSynonymDicts(w http.ResponseWriter, r *http.Request) {
+	projectID, status, msg := projects.Resolve(r, models.ProjectRoleViewer)
+	if status != http.StatusOK {
+		writeDictResponse(w, status, DictResponse{Status: "error", Message: msg})
+		return
+	}
+	visible := []int{projectID}
+	if projectID == 0 {
+		var err error
+		if visible, err = visibleProjects(r); err != nil {
+			writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: "查询用户项目失败"})
+			return
+		}
+	}
+	dicts, err := listCustomDicts(visible)
+	if err != nil {
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	writeDictResponse(w, http.StatusOK, DictResponse{Status: "success", Message: "查询成功", Dicts: dicts})
+}
+
+// UploadSynonymDict 上传或覆盖项目 projectId 的自定义同义词词典，需要项目 editor 角色，
+// 无需重启即可在评分请求中使用
+func UploadSynonymDict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, status, msg := projects.Require(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeDictResponse(w, status, DictResponse{Status: "error", Message: msg})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeDictResponse(w, http.StatusBadRequest, DictResponse{Status: "error", Message: "获取文件失败"})
+		return
+	}
+	defer file.Close()
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	}
+	path, err := customDictPath(projectID, name)
+	if err != nil {
+		writeDictResponse(w, http.StatusBadRequest, DictResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: "读取文件失败"})
+		return
+	}
+
+	// 先解析校验，避免保存无效词典
+	groups, err := newSynonymDict().LoadCustomDict(strings.NewReader(string(content)))
+	if err != nil {
+		writeDictResponse(w, http.StatusBadRequest, DictResponse{Status: "error", Message: fmt.Sprintf("解析词典失败: %v", err)})
+		return
+	}
+	if groups == 0 {
+		writeDictResponse(w, http.StatusBadRequest, DictResponse{Status: "error", Message: "词典中没有有效的同义词组"})
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: "创建词典目录失败"})
+		return
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: "保存词典失败"})
+		return
+	}
+	invalidateMergedDicts()
+	id := fmt.Sprintf("%d/%s", projectID, name)
+	log.Printf("已保存自定义同义词词典 %s，共 %d 组", id, groups)
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditConfigChange, "dict:"+id,
+		fmt.Sprintf("上传同义词词典，共 %d 组", groups))
+
+	writeDictResponse(w, http.StatusOK, DictResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("词典上传成功，共 %d 组同义词", groups),
+		Dicts:   []CustomDictInfo{{ID: id, ProjectID: projectID, Name: name, Groups: groups, Size: int64(len(content)), UpdatedAt: time.Now()}},
+	})
+}
+
+// DeleteSynonymDict 删除项目 projectId 的自定义同义词词典 name，需要项目 editor 角色
+func DeleteSynonymDict(w http.ResponseWriter, r *http.Request) {
+	projectID, status, msg := projects.Require(r, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		writeDictResponse(w, status, DictResponse{Status: "error", Message: msg})
+		return
+	}
+	name := r.URL.Query().Get("name")
+	path, err := customDictPath(projectID, name)
+	if err != nil {
+		writeDictResponse(w, http.StatusBadRequest, DictResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			writeDictResponse(w, http.StatusNotFound, DictResponse{Status: "error", Message: "词典不存在"})
+			return
+		}
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: "删除词典失败"})
+		return
+	}
+	invalidateMergedDicts()
+	log.Printf("已删除自定义同义词词典 %s", path)
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditConfigChange,
+		fmt.Sprintf("dict:%d/%s", projectID, name), "删除同义词词典")
+
+	writeDictResponse(w, http.StatusOK, DictResponse{Status: "success", Message: "词典已删除"})
+}
+
+// ReloadSynonymDicts 重新加载词林词典并清空合并缓存
+func ReloadSynonymDicts(w http.ResponseWriter, r *http.Request) {
+	ensureInitialized()
+
+	base := newSynonymDict()
+	if err := base.LoadCiLinDict(cilinDictPath); err != nil {
+		writeDictResponse(w, http.StatusInternalServerError, DictResponse{Status: "error", Message: fmt.Sprintf("加载词林词典失败: %v", err)})
+		return
+	}
+
+	globalSynonymDict.mu.Lock()
+	globalSynonymDict.cilinMap = base.cilinMap
+	globalSynonymDict.codemap = base.codemap
+	globalSynonymDict.customSeq = 0
+	globalSynonymDict.mu.Unlock()
+	invalidateMergedDicts()
+
+	var dicts []CustomDictInfo
+	if visible, err := visibleProjects(r); err == nil {
+		dicts, _ = listCustomDicts(visible)
+	}
+	writeDictResponse(w, http.StatusOK, DictResponse{Status: "success", Message: "词典已重新加载", Dicts: dicts})
+}
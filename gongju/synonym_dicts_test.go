@@ -0,0 +1,41 @@
+package gongju
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadCiLinDictSynonymGroups 只有 = 标记的行作为同义词组，相关词（#）和独立词（@）不互为同义词
+func TestLoadCiLinDictSynonymGroups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cilin.txt")
+	content := "Aa01A01= 人 士 人物\nAa01A02# 甲 乙\nAa01A03@ 丙\nAa01A04# 人 丁\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dict := newSynonymDict()
+	if err := dict.LoadCiLinDict(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := dict.GetSynonyms("人"); !reflect.DeepEqual(got, []string{"士", "人物"}) {
+		t.Fatalf("人 的同义词为 %v", got)
+	}
+	if got := dict.GetSynonyms("甲"); len(got) != 0 {
+		t.Fatalf("相关词不应作为同义词: %v", got)
+	}
+	if len(dict.codemap) != 1 {
+		t.Fatalf("同义词组 %d 个，应为1个", len(dict.codemap))
+	}
+	if getWordType(dict, "丙") != TypeNoun {
+		t.Fatal("独立词仍应按编码判断词性")
+	}
+
+	custom := newSynonymDict()
+	groups, err := custom.LoadCustomDict(strings.NewReader("Aa01A01= 人士 人家\nAa01A02# 戊 己\n"))
+	if err != nil || groups != 1 {
+		t.Fatalf("自定义词典解析出 %d 组, %v，应只合并 = 标记的组", groups, err)
+	}
+}
@@ -0,0 +1,66 @@
+package gongju
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 单次评分请求允许的最大并发数
+const maxScoringWorkers = 64
+
+// scoringWorkers 返回评分使用的并发数：优先使用请求参数 workers，其次是环境变量
+// SCORING_WORKERS，默认为 CPU 核数
+func scoringWorkers(r *http.Request) int {
+	workers := 0
+	if r != nil {
+		workers, _ = strconv.Atoi(strings.TrimSpace(r.FormValue("workers")))
+	}
+	if workers <= 0 {
+		workers, _ = strconv.Atoi(strings.TrimSpace(os.Getenv("SCORING_WORKERS")))
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > maxScoringWorkers {
+		workers = maxScoringWorkers
+	}
+	return workers
+}
+
+// parallelFor 使用 workers 个协程并发执行 fn(0) 到 fn(n-1)，全部完成后返回。
+// 每完成一项都会更新全局处理进度
+func parallelFor(n, workers int, fn func(i int)) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	updateProgress(0, n)
+	var processed int64
+
+	indexes := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+				updateProgress(int(atomic.AddInt64(&processed, 1)), n)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,119 @@
+package gongju
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// TestMain 按测试文件所在目录加载词林词典与停用词表，不依赖进程的工作目录
+func TestMain(m *testing.M) {
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(file)
+	cilinDictPath = filepath.Join(dir, "cilin.txt")
+	defaultStopWordsPath = filepath.Join(dir, "stopwords.txt")
+	os.Exit(m.Run())
+}
+
+// 评分样例：参考答案与模型输出
+var scoringSamples = [][2]string{
+	{"今天天气很好，我们一起去公园散步吧", "今天的天气不错，我们去公园走走吧"},
+	{"这款手机的电池续航时间很长，拍照效果也很好", "这个手机电池很耐用，照相效果很好"},
+	{"请在下周一之前提交项目报告", "项目报告需要在周一前交上来"},
+	{"医生建议他多喝水、按时休息", "医生让他多喝水并且注意休息"},
+	{"北京是中国的首都，也是政治和文化中心", "中国的首都是北京，它是文化中心"},
+	{"我们公司今年的销售额比去年增长了百分之二十", "公司今年销售额同比增长两成"},
+}
+
+// scoringFixture 返回使用默认分词器、同义词词典和过滤规则的评分配置，以及 n 行评分数据
+func scoringFixture(tb testing.TB, n int) (refs, preds []string, ctx *scoringContext) {
+	tb.Helper()
+	seg, stopWords, err := segmenterFor(0)
+	if err != nil {
+		tb.Fatalf("加载分词器失败: %v", err)
+	}
+	if len(globalSynonymDict.codemap) == 0 || len(stopWords) == 0 {
+		tb.Fatal("词林词典或停用词表没有加载")
+	}
+	refs = make([]string, n)
+	preds = make([]string, n)
+	for i := 0; i < n; i++ {
+		sample := scoringSamples[i%len(scoringSamples)]
+		refs[i], preds[i] = sample[0], sample[1]
+	}
+	ctx = &scoringContext{seg: seg, dict: globalSynonymDict, filter: tokenFilter{stopWords: stopWords, excludeStop: true, excludePunct: true}}
+	return refs, preds, ctx
+}
+
+// TestCalculateSemanticF1Concurrent 多个协程共用分词器和同义词词典评分，结果应与顺序执行一致。
+// 使用 go test -race 运行以检查数据竞争
+func TestCalculateSemanticF1Concurrent(t *testing.T) {
+	refs, preds, ctx := scoringFixture(t, 600)
+
+	want := make([]TextSimilarity, len(refs))
+	for i := range refs {
+		want[i] = calculateSemanticF1(refs[i], preds[i], ctx.seg, ctx.dict, ctx.filter)
+	}
+
+	got := make([]TextSimilarity, len(refs))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(refs); i += 8 {
+				got[i] = calculateSemanticF1(refs[i], preds[i], ctx.seg, ctx.dict, ctx.filter)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("第 %d 行并发结果 %+v 与顺序结果 %+v 不一致", i, got[i], want[i])
+		}
+	}
+}
+
+// TestParallelFor 每一项恰好执行一次
+func TestParallelFor(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 100} {
+		counts := make([]int32, 50)
+		var mu sync.Mutex
+		parallelFor(len(counts), workers, func(i int) {
+			mu.Lock()
+			counts[i]++
+			mu.Unlock()
+		})
+		for i, c := range counts {
+			if c != 1 {
+				t.Fatalf("workers=%d 时第 %d 项执行了 %d 次", workers, i, c)
+			}
+		}
+	}
+}
+
+// BenchmarkCalculateSemanticF1 比较顺序评分与按 CPU 核数并发评分 1000 行的耗时
+func BenchmarkCalculateSemanticF1(b *testing.B) {
+	refs, preds, ctx := scoringFixture(b, 1000)
+	results := make([]TextSimilarity, len(refs))
+
+	b.Run("sequential", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := range refs {
+				results[i] = calculateSemanticF1(refs[i], preds[i], ctx.seg, ctx.dict, ctx.filter)
+			}
+		}
+	})
+
+	b.Run(fmt.Sprintf("parallel-%d", runtime.NumCPU()), func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			parallelFor(len(refs), runtime.NumCPU(), func(i int) {
+				results[i] = calculateSemanticF1(refs[i], preds[i], ctx.seg, ctx.dict, ctx.filter)
+			})
+		}
+	})
+}
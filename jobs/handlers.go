@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/models"
+)
+
+// JobResponse 任务查询的响应结构
+type JobResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Job      *Job   `json:"job,omitempty"`
+	Children []*Job `json:"children,omitempty"`
+	Jobs     []*Job `json:"jobs,omitempty"`
+}
+
+func writeJobResponse(w http.ResponseWriter, status int, response JobResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CanAccess 用户能否以不低于 minRole 的项目角色访问任务：任务所有者始终可以访问，
+// 属于项目的任务还可由相应角色的项目成员访问
+func CanAccess(username string, job *Job, minRole string) bool {
+	if job.Owner == username {
+		return true
+	}
+	return job.ProjectID > 0 && models.CanAccessProject(username, job.ProjectID, minRole)
+}
+
+// RecordStart 在审计日志中记录任务的提交，extra 为附加的详情（如所用的 prompt）
+func RecordStart(r *http.Request, job *Job, datasetID int, extra map[string]interface{}) {
+	details := map[string]interface{}{
+		"kind":      job.Kind,
+		"name":      job.Name,
+		"datasetId": datasetID,
+		"projectId": job.ProjectID,
+	}
+	for key, value := range extra {
+		details[key] = value
+	}
+	audit.Record(r, job.Owner, models.AuditJobStart, job.ID, audit.Details(details))
+}
+
+// ListJobs 列出当前用户的任务，带 projectId 参数时列出该项目中所有成员的任务
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	owner := OwnerFrom(r.Context())
+	if value := strings.TrimSpace(r.FormValue("projectId")); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil || !models.CanAccessProject(owner, projectID, models.ProjectRoleViewer) {
+			writeJobResponse(w, http.StatusNotFound, JobResponse{Status: "error", Message: "项目不存在"})
+			return
+		}
+		writeJobResponse(w, http.StatusOK, JobResponse{Status: "success", Jobs: ListProject(projectID)})
+		return
+	}
+	writeJobResponse(w, http.StatusOK, JobResponse{Status: "success", Jobs: List(owner)})
+}
+
+// GetJob 查询任务状态及其子任务，参数 id 为任务ID。只能查询自己的任务或所在项目的任务
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	job, children, ok := Get(id)
+	if !ok || !CanAccess(OwnerFrom(r.Context()), job, models.ProjectRoleViewer) {
+		writeJobResponse(w, http.StatusNotFound, JobResponse{Status: "error", Message: "任务不存在"})
+		return
+	}
+	writeJobResponse(w, http.StatusOK, JobResponse{Status: "success", Job: job, Children: children})
+}
+
+// CancelJob 取消未结束的任务及其子任务，参数 id 为任务ID。可取消自己的任务，
+// 项目 owner 还可取消项目中其他成员的任务
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	owner := OwnerFrom(r.Context())
+	id := strings.TrimSpace(r.FormValue("id"))
+	job, _, ok := Get(id)
+	if !ok || !CanAccess(owner, job, models.ProjectRoleOwner) {
+		writeJobResponse(w, http.StatusNotFound, JobResponse{Status: "error", Message: "任务不存在"})
+		return
+	}
+	if !Cancel(id) {
+		writeJobResponse(w, http.StatusConflict, JobResponse{Status: "error", Message: "任务已结束，无法取消"})
+		return
+	}
+	audit.Record(r, owner, models.AuditJobCancel, id, job.Kind+" "+job.Name)
+	job, children, _ := Get(id)
+	writeJobResponse(w, http.StatusOK, JobResponse{Status: "success", Message: "任务已取消", Job: job, Children: children})
+}
@@ -0,0 +1,333 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status 任务状态
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// ErrCancelled 任务已被取消
+var ErrCancelled = errors.New("任务已取消")
+
+// Job 一次批处理或评分任务。多文件、多工作表的提交会创建一个父任务，每个文件/工作表为一个子任务
+type Job struct {
+	ID         string      `json:"id"`
+	ParentID   string      `json:"parentId,omitempty"`
+	Owner      string      `json:"owner,omitempty"`
+	Kind       string      `json:"kind"`                // upload、semantic_f1、acc、ass 等
+	Name       string      `json:"name"`                // 文件名或 文件名/工作表名
+	DatasetID  int         `json:"datasetId,omitempty"` // 使用数据集库中的数据集时为其 ID
+	ProjectID  int         `json:"projectId,omitempty"` // 所属项目，项目成员可查看任务和结果
+	Status     Status      `json:"status"`
+	Message    string      `json:"message,omitempty"`
+	Processed  int         `json:"processed"`
+	Total      int         `json:"total"`
+	ResultFile string      `json:"resultFile,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Children   []string    `json:"children,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+}
+
+// registry 内存中的任务表，服务重启后清空
+var registry = struct {
+	sync.RWMutex
+	jobs map[string]*Job
+}{jobs: make(map[string]*Job)}
+
+// maxAge 已结束任务在内存中保留的时间
+const maxAge = 24 * time.Hour
+
+// newID 生成随机任务ID
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Create 创建任务，parentID 不为空时作为该任务的子任务登记，并继承父任务所属的项目
+func Create(kind, name, owner, parentID string) *Job {
+	registry.Lock()
+	defer registry.Unlock()
+	return createLocked(kind, name, owner, parentID)
+}
+
+// CreateIfAllowed 创建属于项目 projectID（为0时不属于项目）的顶层任务。统计提交人和项目未结束的顶层任务数、
+// 调用 allow 检查、登记任务在同一次加锁中完成，并发提交不会越过同时运行任务数的上限。allow 返回错误时不创建任务
+func CreateIfAllowed(kind, name, owner string, projectID int, allow func(ownerActive, projectActive int) error) (*Job, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	ownerActive := countActiveLocked(func(job *Job) bool { return job.Owner == owner })
+	projectActive := 0
+	if projectID > 0 {
+		projectActive = countActiveLocked(func(job *Job) bool { return job.ProjectID == projectID })
+	}
+	if err := allow(ownerActive, projectActive); err != nil {
+		return nil, err
+	}
+	job := createLocked(kind, name, owner, "")
+	job.ProjectID = projectID
+	return job, nil
+}
+
+// createLocked 创建并登记任务，调用方需持有写锁
+func createLocked(kind, name, owner, parentID string) *Job {
+	now := time.Now()
+	job := &Job{
+		ID:        newID(),
+		ParentID:  parentID,
+		Owner:     owner,
+		Kind:      kind,
+		Name:      name,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	pruneLocked(now)
+	registry.jobs[job.ID] = job
+	if parent, ok := registry.jobs[parentID]; ok {
+		job.ProjectID = parent.ProjectID
+		parent.Children = append(parent.Children, job.ID)
+		parent.Total = len(parent.Children)
+		parent.UpdatedAt = now
+	}
+	return job
+}
+
+// finished 任务是否已结束（成功、失败或取消）
+func (job *Job) finished() bool {
+	return job.Status == StatusSucceeded || job.Status == StatusFailed || job.Status == StatusCancelled
+}
+
+// pruneLocked 清理过期的已结束任务，调用方需持有写锁
+func pruneLocked(now time.Time) {
+	for id, job := range registry.jobs {
+		if job.finished() && now.Sub(job.UpdatedAt) > maxAge {
+			delete(registry.jobs, id)
+		}
+	}
+}
+
+// update 在写锁内修改任务
+func update(id string, fn func(job *Job)) {
+	registry.Lock()
+	defer registry.Unlock()
+	if job, ok := registry.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+// Start 将任务标记为运行中
+func Start(id string) {
+	update(id, func(job *Job) {
+		job.Status = StatusRunning
+	})
+}
+
+// SetDataset 记录任务使用的数据集库中的数据集
+func SetDataset(id string, datasetID int) {
+	update(id, func(job *Job) {
+		job.DatasetID = datasetID
+	})
+}
+
+// SetProgress 更新任务进度
+func SetProgress(id string, processed, total int) {
+	update(id, func(job *Job) {
+		job.Processed = processed
+		job.Total = total
+	})
+}
+
+// Succeed 将任务标记为成功并记录结果，resultFile 为结果文件的签名下载链接。已取消的任务保持取消状态
+func Succeed(id, resultFile string, result interface{}) {
+	update(id, func(job *Job) {
+		if job.Status == StatusCancelled {
+			return
+		}
+		job.Status = StatusSucceeded
+		job.ResultFile = resultFile
+		job.Result = result
+		if len(job.Children) == 0 {
+			job.Processed = job.Total
+		}
+	})
+	childDone(id)
+}
+
+// Fail 将任务标记为失败。已取消的任务保持取消状态
+func Fail(id string, err error) {
+	update(id, func(job *Job) {
+		if job.Status == StatusCancelled {
+			return
+		}
+		job.Status = StatusFailed
+		job.Message = err.Error()
+	})
+	childDone(id)
+}
+
+// Cancel 取消未结束的任务及其子任务，任务已结束时返回 false。
+// 正在执行的处理在下一行或下一个子任务前检查 Cancelled 后停止
+func Cancel(id string) bool {
+	return Abort(id, nil)
+}
+
+// Abort 与 Cancel 相同，但记录终止的原因（如配额已用完）
+func Abort(id string, reason error) bool {
+	registry.Lock()
+	defer registry.Unlock()
+	job, ok := registry.jobs[id]
+	if !ok || job.finished() {
+		return false
+	}
+	now := time.Now()
+	for _, childID := range job.Children {
+		if child, ok := registry.jobs[childID]; ok && !child.finished() {
+			child.Status = StatusCancelled
+			child.UpdatedAt = now
+		}
+	}
+	job.Status = StatusCancelled
+	if reason != nil {
+		job.Message = reason.Error()
+	}
+	job.UpdatedAt = now
+	return true
+}
+
+// Cancelled 任务是否已被取消
+func Cancelled(id string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+	job, ok := registry.jobs[id]
+	return ok && job.Status == StatusCancelled
+}
+
+// childDone 子任务结束后更新父任务的进度
+func childDone(id string) {
+	registry.Lock()
+	defer registry.Unlock()
+	job, ok := registry.jobs[id]
+	if !ok {
+		return
+	}
+	parent, ok := registry.jobs[job.ParentID]
+	if !ok {
+		return
+	}
+	done := 0
+	for _, childID := range parent.Children {
+		if child, ok := registry.jobs[childID]; ok && child.finished() {
+			done++
+		}
+	}
+	parent.Processed = done
+	parent.UpdatedAt = time.Now()
+}
+
+// Get 返回任务及其子任务的快照
+func Get(id string) (*Job, []*Job, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	job, ok := registry.jobs[id]
+	if !ok {
+		return nil, nil, false
+	}
+	snapshot := *job
+	snapshot.Children = append([]string(nil), job.Children...)
+	children := make([]*Job, 0, len(job.Children))
+	for _, childID := range job.Children {
+		if child, ok := registry.jobs[childID]; ok {
+			c := *child
+			children = append(children, &c)
+		}
+	}
+	return &snapshot, children, true
+}
+
+// List 按创建时间倒序列出某个用户的顶层任务
+func List(owner string) []*Job {
+	return list(func(job *Job) bool { return job.Owner == owner })
+}
+
+// ListProject 按创建时间倒序列出某个项目的顶层任务
+func ListProject(projectID int) []*Job {
+	return list(func(job *Job) bool { return job.ProjectID == projectID })
+}
+
+func list(match func(job *Job) bool) []*Job {
+	registry.RLock()
+	defer registry.RUnlock()
+	list := make([]*Job, 0)
+	for _, job := range registry.jobs {
+		if job.ParentID == "" && match(job) {
+			snapshot := *job
+			snapshot.Children = append([]string(nil), job.Children...)
+			list = append(list, &snapshot)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Active 统计某个用户未结束的顶层任务数
+func Active(owner string) int {
+	return countActive(func(job *Job) bool { return job.Owner == owner })
+}
+
+// ActiveInProject 统计某个项目未结束的顶层任务数
+func ActiveInProject(projectID int) int {
+	return countActive(func(job *Job) bool { return job.ProjectID == projectID })
+}
+
+func countActive(match func(job *Job) bool) int {
+	registry.RLock()
+	defer registry.RUnlock()
+	return countActiveLocked(match)
+}
+
+// countActiveLocked 统计未结束的顶层任务数，调用方需持有锁
+func countActiveLocked(match func(job *Job) bool) int {
+	n := 0
+	for _, job := range registry.jobs {
+		if job.ParentID == "" && !job.finished() && match(job) {
+			n++
+		}
+	}
+	return n
+}
+
+type ownerKey struct{}
+
+// WithOwner 在请求上下文中记录当前登录用户，任务创建时以此作为所有者
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// OwnerFrom 读取请求上下文中的当前用户，未登录时返回空字符串
+func OwnerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
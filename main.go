@@ -3,7 +3,7 @@ package main
 import (
 	//"bytes"
 	"fmt"
-	//"io"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -11,17 +11,24 @@ import (
 	"sync"
 	"time"
 
+	"fuzhu_2/admin"
 	"fuzhu_2/api"
+	"fuzhu_2/audit"
 	"fuzhu_2/config"
 	"fuzhu_2/gongju"
+	"fuzhu_2/jobs"
+	"fuzhu_2/middleware"
 
 	//"fuzhu_2/handlers"
 	"fuzhu_2/models"
+	"fuzhu_2/projects"
+	"fuzhu_2/quota"
+	"fuzhu_2/session"
+	"fuzhu_2/storage"
 	"fuzhu_2/types"
 	"fuzhu_2/utils"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	//"github.com/gin-gonic/gin/binding"
 	//"github.com/pkg/errors"
@@ -36,57 +43,66 @@ func main() {
 	config.InitDB()
 	// 在 main 函数结束时，确保数据库连接被关闭。defer 关键字用于延迟执行 config.DB.Close() 这个函数，直到包含它的函数（在这里是 main 函数）返回。这是一个常见的做法，用于确保资源（如数据库连接）在不再需要时被正确释放，避免资源泄漏。
 	defer config.DB.Close()
+	// 创建运行记录等数据表
+	if err := models.InitSchema(); err != nil {
+		log.Fatal("初始化数据表失败：", err)
+	}
 
+	// 初始化文件存储（目录和下载链接签名通过 STORAGE_DIR、FILE_URL_* 环境变量配置）
+	if err := storage.Init(); err != nil {
+		log.Fatalf("初始化文件存储失败: %v", err)
+	}
 	// 启动文件清理任务
 	// 设置文件最大保存时间为24小时，清理间隔为1小时
-	utils.StartCleanupScheduler(
-		"./uploads",  // 上传目录
+	storage.StartCleanup(
 		24*time.Hour, // 文件最大保存时间
 		1*time.Hour,  // 清理检查间隔
 	)
 
-	// 创建测试用户
-	if err := models.CreateTestUser(); err != nil {
-		log.Printf("创建测试用户失败: %v", err)
-	} else {
-		log.Printf("测试用户初始化完成")
+	// 首次启动时创建管理员账号
+	if err := models.BootstrapAdmin(); err != nil {
+		log.Printf("初始化管理员失败: %v", err)
 	}
 
 	// 初始化Gin引擎
 	r := gin.Default()
+	// 只信任 TRUSTED_PROXIES 中列出的反向代理（逗号分隔）设置的 X-Forwarded-For，
+	// 否则客户端可以伪造 IP 绕过按 IP 的登录限制
+	if err := r.SetTrustedProxies(config.EnvList("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("TRUSTED_PROXIES 配置错误: %v", err)
+	}
 
 	// 设置 session 中间件
 	/*用途：
 	用户身份验证：会话中间件用于跟踪用户的登录状态。当用户成功登录后，可以将用户信息存储在会话中，以便在后续请求中验证用户身份。
 	状态管理：通过会话，可以在多个请求之间存储用户的状态信息，例如购物车内容、用户偏好设置等。
 	安全性：使用加密的 Cookie 存储会话数据，可以防止会话劫持和伪造攻击。只有使用正确的密钥才能解密和验证会话数据。*/
-	store := cookie.NewStore([]byte("secret"))
+	// 会话保存在服务端（SESSION_STORE=mysql 或 memory），Cookie 中只有签名后的会话ID，
+	// 密钥、超时和 Cookie 选项通过 SESSION_* 环境变量配置
+	store := session.Init()
 	r.Use(sessions.Sessions("mysession", store))
 
-	// 添加认证中间件，用于检查用户登录状态。
-	auth := func(c *gin.Context) {
-		session := sessions.Default(c) // 获取会话
-		user := session.Get("user")    // 获取用户信息
-		if user == nil {
-			c.Redirect(http.StatusFound, "/login") // 如果用户未登录，重定向到登录页面
-			c.Abort()                              // 停止处理请求
-			return
-		}
-		c.Next() // 继续处理请求
-	}
+	// 记录客户端 IP，供审计日志使用
+	r.Use(middleware.ClientIP())
+	// 修改数据的请求须带上 CSRF 令牌（页面通过 /web/js/csrf.js 自动添加）
+	r.Use(middleware.CSRF())
+	// 按 accessPolicy 统一检查每个路由的访问权限（admin > analyst > viewer），未配置的路由拒绝访问。
+	// 除会话外也接受个人访问令牌（Authorization: Bearer），令牌另受 read/write/admin 权限范围限制
+	r.Use(accessPolicy.Enforce())
 
 	// 设置静态文件目录
 	r.Static("/web", "./web")
 	// 添加 chengshi 目录的静态文件服务
 	r.Static("/chengshi", "./chengshi")
 
-	// 确保上传目录存在
-	if err := os.MkdirAll("./uploads", 0755); err != nil {
-		log.Fatalf("创建上传目录失败: %v", err)
-	}
-
-	// 添加静态文件服务以提供对上传文件的访问
-	r.Static("/uploads", "./uploads")
+	// 通过签名链接下载文件，另要求当前用户是文件所有者或管理员
+	r.GET("/files/:id", func(c *gin.Context) {
+		storage.Download(c.Writer, c.Request)
+	})
+	// 列出当前用户的文件，附带新的下载链接
+	r.GET("/api/files", func(c *gin.Context) {
+		storage.ListFiles(c.Writer, c.Request)
+	})
 
 	// 设置首页路由
 	r.GET("/", func(c *gin.Context) {
@@ -99,7 +115,7 @@ func main() {
 	})
 
 	// 设置功能页面路由（需要登录）
-	r.GET("/dashboard", auth, func(c *gin.Context) {
+	r.GET("/dashboard", func(c *gin.Context) {
 		c.File("./web/index.html") // 返回功能页面
 	})
 
@@ -110,6 +126,7 @@ func main() {
 		- 如果密码验证成功，则设置 session，将用户信息存储在会话中，以便在后续请求中验证用户身份。
 		- 最后，返回一个 HTTP 200（OK）状态码，表示登录成功。响应中包含一条消息，告知用户登录成功，并重定向到关于页面。
 	*/
+	loginLimiter := middleware.NewLoginLimiter()
 	r.POST("/api/login", func(c *gin.Context) {
 		var user models.User
 		if err := c.ShouldBindJSON(&user); err != nil {
@@ -118,17 +135,51 @@ func main() {
 			return
 		}
 
+		// 同一用户名或 IP 连续失败后须等待，失败次数过多时临时锁定。
+		// 检查时即把这次尝试记为失败，并发的请求不能绕过计数，登录成功后撤销
+		ip := c.ClientIP()
+		if wait, locked := loginLimiter.Check(user.Username, ip); wait > 0 {
+			seconds := int(wait.Seconds()) + 1
+			message := fmt.Sprintf("尝试次数过多，请 %d 秒后再试", seconds)
+			if locked {
+				message = fmt.Sprintf("登录失败次数过多，已临时锁定，请 %d 分钟后再试", (seconds+59)/60)
+			}
+			c.Header("Retry-After", fmt.Sprint(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": message})
+			return
+		}
+
 		if user.Authenticate() {
+			loginLimiter.Succeed(user.Username, ip)
+			models.RecordAudit(models.AuditEntry{Username: user.Username, Action: models.AuditLogin, IP: ip})
+
 			// 设置 session
 			session := sessions.Default(c) // 获取会话
 			session.Set("user", user.Username)
 			session.Save()
 
+			// 使用初始密码登录时先修改密码
+			account, _ := models.GetUserByUsername(user.Username)
+			if account != nil && account.MustChangePassword {
+				c.JSON(http.StatusOK, gin.H{
+					"message":            "登录成功，请先修改初始密码",
+					"redirect":           "/account/password",
+					"mustChangePassword": true,
+				})
+				return
+			}
+
 			c.JSON(http.StatusOK, gin.H{
 				"message":  "登录成功",
 				"redirect": "/about", // 登录成功后重定向到关于页面
 			})
 		} else {
+			log.Printf("用户 %s 登录失败（IP %s）", user.Username, ip)
+			models.RecordAudit(models.AuditEntry{Username: user.Username, Action: models.AuditLoginFailed, IP: ip})
+			if loginLimiter.Fail(user.Username, ip) {
+				log.Printf("用户 %s 或 IP %s 登录失败次数过多，已临时锁定", user.Username, ip)
+				models.RecordAudit(models.AuditEntry{Username: user.Username, Action: models.AuditLoginLocked, IP: ip})
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"message": "用户名或密码错误",
 			})
@@ -137,6 +188,9 @@ func main() {
 
 	// 添加登出 API
 	r.POST("/api/logout", func(c *gin.Context) {
+		if user := middleware.CurrentUser(c); user != nil {
+			models.RecordAudit(models.AuditEntry{Username: user.Username, Action: models.AuditLogout, IP: c.ClientIP()})
+		}
 		session := sessions.Default(c)
 		session.Clear()
 		session.Save()
@@ -145,30 +199,87 @@ func main() {
 		})
 	})
 
+	// 修改密码（须修改初始密码的用户也可访问，不接受访问令牌）
+	requireSession := middleware.RequireSession()
+	r.GET("/account/password", func(c *gin.Context) {
+		c.File("./web/change_password.html")
+	})
+	r.POST("/api/account/password", requireSession, func(c *gin.Context) {
+		admin.ChangePassword(c.Writer, c.Request)
+	})
+
+	// 个人访问令牌：脚本可用 Authorization: Bearer <令牌> 调用接口，令牌只能在登录后管理
+	r.GET("/account/tokens", func(c *gin.Context) {
+		c.File("./web/api_tokens.html")
+	})
+	r.GET("/api/account/tokens", requireSession, func(c *gin.Context) {
+		admin.ListTokens(c.Writer, c.Request)
+	})
+	r.POST("/api/account/tokens", requireSession, func(c *gin.Context) {
+		admin.CreateToken(c.Writer, c.Request)
+	})
+	r.DELETE("/api/account/tokens", requireSession, func(c *gin.Context) {
+		admin.RevokeToken(c.Writer, c.Request)
+	})
+	r.GET("/api/password/policy", func(c *gin.Context) {
+		admin.GetPasswordPolicy(c.Writer, c.Request)
+	})
+
+	// 使用管理员签发的一次性链接重置密码（无需登录）
+	r.GET("/reset-password", func(c *gin.Context) {
+		c.File("./web/reset_password.html")
+	})
+	r.POST("/api/password/reset", func(c *gin.Context) {
+		admin.ResetPassword(c.Writer, c.Request)
+	})
+
 	// 设置文件上传的路由
-	r.POST("/upload", auth, func(c *gin.Context) {
-		processedRows = 0 // 重置处理行数
+	r.POST("/upload", func(c *gin.Context) {
+		projectID, prompt, ok := resolveUploadPrompt(c)
+		if !ok {
+			return
+		}
+
+		// 使用数据集库中的数据集，无需重新上传
+		if id := c.PostForm("datasetId"); id != "" {
+			dataset, file, err := gongju.OpenRegisteredDataset(c.Request, id)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			file.Close()
+			processFile(dataset.StoragePath, dataset.Filename, dataset.ID, projectID, prompt, c)
+			return
+		}
+
 		file, err := c.FormFile("file")
 		if err != nil {
 			c.String(http.StatusBadRequest, "获取文件失败: %v", err)
 			return
 		}
 
-		// 检查文件类型
-		if filepath.Ext(file.Filename) != ".xlsx" {
-			c.String(http.StatusBadRequest, "不支持的文件类型，请上传.xlsx文件")
+		// 检查文件类型，支持 xlsx、csv、tsv、json、jsonl，以及包含多个文件的 zip
+		if _, err := utils.DetectFormat(file.Filename); err != nil && !utils.IsZip(file.Filename) {
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		// 保存上传的文件
-		filePath := fmt.Sprintf("./uploads/%s", file.Filename)
-		if err := c.SaveUploadedFile(file, filePath); err != nil {
+		// 保存上传的文件，存储空间不足时拒绝
+		if err := quota.CheckStorage(jobs.OwnerFrom(c.Request.Context()), projectID, file.Size); err != nil {
+			c.String(quota.HTTPStatus(err), err.Error())
+			return
+		}
+		input, err := storage.SaveUpload(jobs.OwnerFrom(c.Request.Context()), "", storage.KindInput, file)
+		if err != nil {
 			c.String(http.StatusInternalServerError, "保存文件失败: %v", err)
 			return
 		}
 
+		audit.Record(c.Request, input.Owner, models.AuditUpload, input.ID,
+			audit.Details(map[string]interface{}{"name": input.Name, "size": input.Size, "sha256": input.SHA256}))
+
 		// 处理上传的文件
-		processFile(filePath, c)
+		processFile(input.Path, file.Filename, 0, projectID, prompt, c)
 	})
 
 	// 提供进度查询服务
@@ -192,8 +303,7 @@ func main() {
 
 	// 添加检查登录状态的 API
 	r.GET("/api/check-status", func(c *gin.Context) {
-		session := sessions.Default(c)
-		user := session.Get("user")
+		user := middleware.CurrentUser(c)
 		if user == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"message": "未登录",
@@ -201,8 +311,10 @@ func main() {
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
-			"message": "已登录",
-			"user":    user,
+			"message":            "已登录",
+			"user":               user.Username,
+			"role":               user.Role,
+			"mustChangePassword": user.MustChangePassword,
 		})
 	})
 
@@ -211,8 +323,18 @@ func main() {
 		c.File("./web/about.html")
 	})
 
+	// 人工复核页面
+	r.GET("/review", func(c *gin.Context) {
+		c.File("./web/review.html")
+	})
+
+	// 模型排行榜页面
+	r.GET("/leaderboard", func(c *gin.Context) {
+		c.File("./web/leaderboard.html")
+	})
+
 	// 设置模型分值计算页面路由
-	r.GET("/model-score", auth, func(c *gin.Context) {
+	r.GET("/model-score", func(c *gin.Context) {
 		c.File("./web/model_score.html")
 	})
 
@@ -222,37 +344,203 @@ func main() {
 	})
 
 	// 处理Excel文件并计算F1分数
-	r.POST("/api/process-excel", auth, func(c *gin.Context) {
+	r.POST("/api/process-excel", func(c *gin.Context) {
 		gongju.ProcessExcelFile(c.Writer, c.Request)
 	})
 
 	// 处理Excel文件并计算ACC分数
-	r.POST("/api/calculate-acc", auth, func(c *gin.Context) {
+	r.POST("/api/calculate-acc", func(c *gin.Context) {
 		gongju.CalculateACCScore(c.Writer, c.Request)
 	})
 
 	// 处理Excel文件并计算ASS分数
-	r.POST("/api/calculate-ass", auth, func(c *gin.Context) {
+	r.POST("/api/calculate-ass", func(c *gin.Context) {
 		gongju.CalculateASSScore(c.Writer, c.Request)
 	})
 
+	// 在同一份标准答案上对比两个系统，给出置信区间与显著性检验
+	r.POST("/api/compare", func(c *gin.Context) {
+		gongju.CompareSystems(c.Writer, c.Request)
+	})
+
+	// 为已完成的评分任务生成汇总报告（Excel与HTML）
+	r.POST("/api/report", func(c *gin.Context) {
+		gongju.GenerateReport(c.Writer, c.Request)
+	})
+
+	// 数据集库：上传登记数据集（按内容哈希去重并分配版本号），查询数据集及其版本
+	r.POST("/api/datasets", func(c *gin.Context) {
+		gongju.RegisterDataset(c.Writer, c.Request)
+	})
+	r.GET("/api/datasets", func(c *gin.Context) {
+		gongju.ListDatasets(c.Writer, c.Request)
+	})
+
+	// 评分运行记录，可按数据集、模型、指标过滤
+	r.GET("/api/eval-runs", func(c *gin.Context) {
+		gongju.ListEvalRuns(c.Writer, c.Request)
+	})
+
+	// 按数据集与指标查询模型排行榜
+	r.GET("/api/leaderboard", func(c *gin.Context) {
+		gongju.GetLeaderboard(c.Writer, c.Request)
+	})
+
+	// 人工复核：从评分任务创建复核任务并分配复核人，逐条复核，统计人工评分与自动指标的一致性
+	r.POST("/api/reviews", func(c *gin.Context) {
+		gongju.CreateReview(c.Writer, c.Request)
+	})
+	r.GET("/api/reviews", func(c *gin.Context) {
+		gongju.ListReviews(c.Writer, c.Request)
+	})
+	r.GET("/api/reviews/next", func(c *gin.Context) {
+		gongju.NextReviewItem(c.Writer, c.Request)
+	})
+	r.POST("/api/reviews/submit", func(c *gin.Context) {
+		gongju.SubmitReview(c.Writer, c.Request)
+	})
+
+	// 查询批处理与评分任务：带 id 参数时返回该任务及其子任务，带 projectId 参数时列出项目中的任务，
+	// 否则列出当前用户的任务
+	r.GET("/api/jobs", func(c *gin.Context) {
+		if c.Query("id") != "" {
+			jobs.GetJob(c.Writer, c.Request)
+			return
+		}
+		jobs.ListJobs(c.Writer, c.Request)
+	})
+	// 取消自己的未结束任务（参数 id），正在执行的处理在下一行或下一个文件前停止
+	r.POST("/api/jobs/cancel", func(c *gin.Context) {
+		jobs.CancelJob(c.Writer, c.Request)
+	})
+
+	// 配额：查询当前用户及其项目的配额与本月用量
+	r.GET("/api/quota", func(c *gin.Context) {
+		quota.GetUsage(c.Writer, c.Request)
+	})
+
+	// 项目：成员按项目角色共享项目中的数据集、prompt、任务和结果
+	r.GET("/projects", func(c *gin.Context) {
+		c.File("./web/projects.html")
+	})
+	r.GET("/api/projects", func(c *gin.Context) {
+		projects.ListProjects(c.Writer, c.Request)
+	})
+	r.POST("/api/projects", func(c *gin.Context) {
+		projects.CreateProject(c.Writer, c.Request)
+	})
+	r.DELETE("/api/projects", func(c *gin.Context) {
+		projects.DeleteProject(c.Writer, c.Request)
+	})
+	r.GET("/api/projects/members", func(c *gin.Context) {
+		projects.ListMembers(c.Writer, c.Request)
+	})
+	r.POST("/api/projects/members", func(c *gin.Context) {
+		projects.SetMember(c.Writer, c.Request)
+	})
+	r.DELETE("/api/projects/members", func(c *gin.Context) {
+		projects.RemoveMember(c.Writer, c.Request)
+	})
+	r.GET("/api/projects/prompts", func(c *gin.Context) {
+		projects.ListPrompts(c.Writer, c.Request)
+	})
+	r.POST("/api/projects/prompts", func(c *gin.Context) {
+		projects.SavePrompt(c.Writer, c.Request)
+	})
+	r.DELETE("/api/projects/prompts", func(c *gin.Context) {
+		projects.DeletePrompt(c.Writer, c.Request)
+	})
+
+	// 自定义同义词词典管理，评分时通过 dicts 参数选择，与词林合并使用
+	r.GET("/api/dicts", func(c *gin.Context) {
+		gongju.ListSynonymDicts(c.Writer, c.Request)
+	})
+	r.POST("/api/dicts", func(c *gin.Context) {
+		gongju.UploadSynonymDict(c.Writer, c.Request)
+	})
+	r.DELETE("/api/dicts", func(c *gin.Context) {
+		gongju.DeleteSynonymDict(c.Writer, c.Request)
+	})
+	r.POST("/api/dicts/reload", func(c *gin.Context) {
+		gongju.ReloadSynonymDicts(c.Writer, c.Request)
+	})
+
+	// 项目分词配置（用户词典、停用词表）与分词预览
+	r.GET("/api/seg/profiles", func(c *gin.Context) {
+		gongju.ListSegProfiles(c.Writer, c.Request)
+	})
+	r.POST("/api/seg/profiles", func(c *gin.Context) {
+		gongju.UploadSegProfile(c.Writer, c.Request)
+	})
+	r.DELETE("/api/seg/profiles", func(c *gin.Context) {
+		gongju.DeleteSegProfile(c.Writer, c.Request)
+	})
+	r.POST("/api/seg/preview", func(c *gin.Context) {
+		gongju.PreviewSegmentation(c.Writer, c.Request)
+	})
+
+	// 用户管理（仅管理员）
+	r.GET("/admin/users", func(c *gin.Context) {
+		c.File("./web/admin_users.html")
+	})
+	r.GET("/api/admin/users", func(c *gin.Context) {
+		admin.ListUsers(c.Writer, c.Request)
+	})
+	r.POST("/api/admin/users", func(c *gin.Context) {
+		admin.CreateUser(c.Writer, c.Request)
+	})
+	r.POST("/api/admin/users/update", func(c *gin.Context) {
+		admin.UpdateUser(c.Writer, c.Request)
+	})
+	r.DELETE("/api/admin/users", func(c *gin.Context) {
+		admin.DeleteUser(c.Writer, c.Request)
+	})
+	r.POST("/api/admin/users/reset-token", func(c *gin.Context) {
+		admin.IssueResetToken(c.Writer, c.Request)
+	})
+	r.POST("/api/admin/users/revoke-sessions", func(c *gin.Context) {
+		admin.RevokeSessions(c.Writer, c.Request)
+	})
+
+	// 配额管理：默认配额来自环境变量 QUOTA_USER_*、QUOTA_PROJECT_*，可为用户或项目单独设置
+	r.GET("/api/admin/quotas", func(c *gin.Context) {
+		admin.ListQuotas(c.Writer, c.Request)
+	})
+	r.POST("/api/admin/quotas", func(c *gin.Context) {
+		admin.SetQuota(c.Writer, c.Request)
+	})
+	r.DELETE("/api/admin/quotas", func(c *gin.Context) {
+		admin.DeleteQuota(c.Writer, c.Request)
+	})
+
+	// 审计日志：分页查询与 CSV 导出，参数 username、action、target、ip、from、to
+	r.GET("/admin/audit", func(c *gin.Context) {
+		c.File("./web/admin_audit.html")
+	})
+	r.GET("/api/admin/audit", func(c *gin.Context) {
+		admin.ListAuditLogs(c.Writer, c.Request)
+	})
+	r.GET("/api/admin/audit/export", func(c *gin.Context) {
+		admin.ExportAuditLogs(c.Writer, c.Request)
+	})
+
 	// 设置数据分析页面路由
 	r.GET("/data_analysis", func(c *gin.Context) {
 		c.File("./web/data_analysis.html")
 	})
 
+	// 每个路由都必须在 accessPolicy 中配置访问级别
+	if err := accessPolicy.Check(r.Routes()); err != nil {
+		log.Fatal(err)
+	}
+
 	// 启动服务器，监听所有IP地址
 	r.Run("0.0.0.0:8081")
 }
 
-func processFile(filePath string, c *gin.Context) {
-	// 获取前端传来的 prompt
-	prompt := c.PostForm("prompt")
-	if prompt == "" {
-		c.String(http.StatusBadRequest, "Prompt 不能为空")
-		return
-	}
-
+// processFile 用大模型处理输入文件，filename 为原文件名（用于识别格式），
+// datasetID 为数据集库中的数据集ID，上传文件时为0
+func processFile(filePath, filename string, datasetID, projectID int, prompt string, c *gin.Context) {
 	// 初始化日志系统
 	logFile, err := utils.InitLogger()
 	if err != nil {
@@ -263,90 +551,447 @@ func processFile(filePath string, c *gin.Context) {
 	startTime := time.Now()
 	log.Printf("程序开始执行，正在打开输入文件 '%s'...", filePath)
 
-	// 初始化Excel处理器
-	excelHandler, err := utils.NewExcelHandler(filePath)
+	input, err := os.Open(filePath)
 	if err != nil {
-		log.Printf("❌ 初始化Excel处理器失败: %v", err)
-		c.String(http.StatusInternalServerError, "初始化Excel处理器失败")
+		log.Printf("❌ 打开输入文件失败: %v", err)
+		c.String(http.StatusInternalServerError, "打开输入文件失败")
+		return
+	}
+	defer input.Close()
+	info, err := input.Stat()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "打开输入文件失败")
+		return
+	}
+
+	// zip 中的每个文件、或 allSheets=true 时工作簿的每个工作表作为一个子任务
+	allSheets := c.PostForm("allSheets") == "true" || c.PostForm("allSheets") == "1"
+	parts, err := utils.ExpandBatch(input, info.Size(), filename, c.PostForm("sheet"), allSheets)
+	if err != nil {
+		log.Printf("❌ 打开输入文件失败: %v", err)
+		c.String(http.StatusBadRequest, "打开输入文件失败: %v", err)
+		return
+	}
+	defer utils.ReleaseBatch(parts)
+
+	owner := jobs.OwnerFrom(c.Request.Context())
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+
+	// 提交前检查提交人和项目的配额，处理过程中按行数与 token 数计量
+	meter, err := quota.Begin(owner, projectID)
+	if err != nil {
+		c.String(quota.HTTPStatus(err), err.Error())
+		return
+	}
+	defer meter.Finish()
+
+	// 单个数据集：直接返回结果文件
+	if len(parts) == 1 {
+		job, err := meter.CreateJob("upload", parts[0].Name)
+		if err != nil {
+			c.String(quota.HTTPStatus(err), err.Error())
+			return
+		}
+		jobs.SetDataset(job.ID, datasetID)
+		jobs.Start(job.ID)
+		recordJobStart(c, job, datasetID, prompt)
+		output, rows, err := processBatchPart(c, job.ID, parts[0], prompt, "output_"+timestamp, meter)
+		if err != nil {
+			jobs.Fail(job.ID, err)
+			log.Printf("❌ 处理失败: %v", err)
+			status := http.StatusBadRequest
+			if meter.Err() != nil {
+				status = quota.HTTPStatus(err)
+			}
+			c.String(status, "处理失败: %v", err)
+			return
+		}
+		url := storage.URL(output)
+		jobs.Succeed(job.ID, url, gin.H{"rows": rows})
+
+		// 输出统计信息
+		log.Printf("✅ 处理完成！")
+		log.Printf("总行数: %d", rows)
+		log.Printf("总耗时: %v", time.Since(startTime))
+		log.Printf("结果已保存到 %s", output.Path)
+
+		// 返回结果给用户
+		c.JSON(http.StatusOK, gin.H{
+			"message": "处理完成！",
+			"file":    output.Name,
+			"url":     url,
+			"jobId":   job.ID,
+		})
+		return
+	}
+
+	// 多个文件或工作表：依次处理，结果打包为 zip
+	parent, err := meter.CreateJob("upload", filename)
+	if err != nil {
+		c.String(quota.HTTPStatus(err), err.Error())
+		return
+	}
+	jobs.SetDataset(parent.ID, datasetID)
+	jobs.Start(parent.ID)
+	recordJobStart(c, parent, datasetID, prompt)
+	subJobs := make([]*jobs.Job, len(parts))
+	for i, part := range parts {
+		subJobs[i] = jobs.Create("upload", part.Name, owner, parent.ID)
+		jobs.SetDataset(subJobs[i].ID, datasetID)
+	}
+
+	summaries := make([]gin.H, len(parts))
+	resultFiles := make([]string, 0, len(parts))
+	resultNames := make([]string, 0, len(parts))
+	totalProcessed := 0
+	for i, part := range parts {
+		// 取消父任务后不再处理剩余的文件
+		if jobs.Cancelled(parent.ID) {
+			summaries[i] = gin.H{"jobId": subJobs[i].ID, "name": part.Name, "status": jobs.StatusCancelled}
+			continue
+		}
+		jobs.Start(subJobs[i].ID)
+		partName := part.OutputName()
+		output, rows, err := processBatchPart(c, subJobs[i].ID, part, prompt, fmt.Sprintf("output_%s_%d_%s", timestamp, i+1, partName), meter)
+		part.Release()
+		if err != nil {
+			log.Printf("❌ 子任务 %s 处理失败: %v", part.Name, err)
+			jobs.Fail(subJobs[i].ID, err)
+			summaries[i] = gin.H{"jobId": subJobs[i].ID, "name": part.Name, "status": jobs.StatusFailed, "message": err.Error()}
+			continue
+		}
+		url := storage.URL(output)
+		jobs.Succeed(subJobs[i].ID, url, gin.H{"rows": rows})
+		summaries[i] = gin.H{"jobId": subJobs[i].ID, "name": part.Name, "status": jobs.StatusSucceeded, "rows": rows, "file": output.Name, "url": url}
+		totalProcessed += rows
+		resultFiles = append(resultFiles, output.Path)
+		resultNames = append(resultNames, partName+filepath.Ext(output.Name))
+	}
+
+	if err := meter.Err(); err != nil {
+		c.JSON(quota.HTTPStatus(err), gin.H{
+			"message": err.Error(),
+			"jobId":   parent.ID,
+			"subJobs": summaries,
+		})
+		return
+	}
+	if jobs.Cancelled(parent.ID) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": jobs.ErrCancelled.Error(),
+			"jobId":   parent.ID,
+			"subJobs": summaries,
+		})
+		return
+	}
+	if len(resultFiles) == 0 {
+		jobs.Fail(parent.ID, fmt.Errorf("所有文件处理均失败"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "所有文件处理均失败",
+			"jobId":   parent.ID,
+			"subJobs": summaries,
+		})
 		return
 	}
-	defer excelHandler.Close()
 
-	// 读取所有行
-	rows, err := excelHandler.GetRows()
+	archive, err := storage.New(owner, parent.ID, storage.KindArchive, fmt.Sprintf("output_%s.zip", timestamp))
+	if err == nil {
+		err = utils.WriteZip(archive.Path, resultFiles, resultNames)
+	}
+	if err == nil {
+		err = storage.Commit(archive)
+	}
 	if err != nil {
-		log.Printf("❌ 读取工作表失败: %v", err)
-		c.String(http.StatusInternalServerError, "读取工作表失败")
+		jobs.Fail(parent.ID, err)
+		log.Printf("保存文件失败: %v", err)
+		c.String(http.StatusInternalServerError, "保存文件失败")
 		return
 	}
-	log.Printf("✅ 成功读取输入文件，共有 %d 行数据需要处理", len(rows))
-	totalRows = len(rows)
+	url := storage.URL(archive)
+	jobs.Succeed(parent.ID, url, summaries)
+
+	log.Printf("✅ 处理完成！共 %d 个子任务，%d 行", len(parts), totalProcessed)
+	log.Printf("总耗时: %v", time.Since(startTime))
+	log.Printf("结果已保存到 %s", archive.Path)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "处理完成！",
+		"file":    archive.Name,
+		"url":     url,
+		"jobId":   parent.ID,
+		"rows":    totalProcessed,
+		"subJobs": summaries,
+	})
+}
+
+// recordJobStart 在审计日志中记录任务的提交及所用的 prompt
+func recordJobStart(c *gin.Context, job *jobs.Job, datasetID int, prompt string) {
+	jobs.RecordStart(c.Request, job, datasetID, map[string]interface{}{
+		"promptId": c.PostForm("promptId"),
+		"prompt":   prompt,
+	})
+}
+
+// resolveUploadPrompt 读取批处理的项目与 prompt：参数 projectId 指定项目（需要 editor 角色），
+// promptId 引用项目中保存的 prompt，否则使用参数 prompt 的内容。失败时已写入响应
+func resolveUploadPrompt(c *gin.Context) (int, string, bool) {
+	projectID, status, msg := projects.Resolve(c.Request, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		c.String(status, msg)
+		return 0, "", false
+	}
+	saved, status, msg := projects.ResolvePrompt(c.Request, models.ProjectRoleEditor)
+	if status != http.StatusOK {
+		c.String(status, msg)
+		return 0, "", false
+	}
+	prompt := c.PostForm("prompt")
+	if saved != nil {
+		prompt = saved.Content
+	}
+	if prompt == "" {
+		c.String(http.StatusBadRequest, "Prompt 不能为空")
+		return 0, "", false
+	}
+	return projectID, prompt, true
+}
+
+// processBatchPart 用大模型逐行处理一个文件或工作表，结果保存为任务 jobID 的结果文件 <baseName>.<格式>，
+// 返回结果文件记录与处理的行数。每行调用大模型前由 meter 计入配额，超出配额时停止处理
+func processBatchPart(c *gin.Context, jobID string, part utils.BatchPart, prompt, baseName string, meter *quota.Meter) (*models.File, int, error) {
+	// 打开数据集，xlsx 可通过 sheet 参数选择工作表，文本格式可通过 encoding 参数指定编码
+	opts := utils.DatasetOptions{
+		Sheet:    part.Sheet,
+		Encoding: c.PostForm("encoding"),
+	}
+	if _, err := part.Data.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	reader, err := utils.OpenDatasetReader(part.Data, part.Filename, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	inputFormat := reader.Format()
+	opts.Sheet = reader.Sheet()
+
+	// 输出格式默认与输入相同
+	outputFormat, err := utils.NormalizeFormat(c.PostForm("outputFormat"), inputFormat)
+	if err != nil {
+		reader.Close()
+		return nil, 0, err
+	}
+
+	// 第一行是否为表头：headerRow 参数指定，JSON/JSONL 的第一行总是字段名。表头不参与处理
+	headerRow := c.PostForm("headerRow") == "true" || c.PostForm("headerRow") == "1" ||
+		inputFormat == utils.FormatJSON || inputFormat == utils.FormatJSONL
+	firstRow := 0
+	if headerRow {
+		firstRow = 1
+	}
 
 	// 初始化API客户端
 	apiClient := api.NewAPIClient("sk-ad297c6e95034aa896725120f452bac1")
 	apiClient.SystemPrompt = prompt // 使用前端传的 prompt
+	apiClient.OnUsage = meter.AddTokens
 
-	// 配置并发处理参数
+	// 配置并发处理参数：固定数量的协程从任务队列中取行，大文件也不会创建过多协程
 	maxWorkers := 4
-	resultChan := make(chan types.Result, len(rows))
+	jobQueue := make(chan types.Result, maxWorkers)
+	resultChan := make(chan types.Result, maxWorkers)
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, maxWorkers)
-
-	// 并发处理数据
-	log.Printf("开始并发处理数据，并发数: %d", maxWorkers)
-	for i, row := range rows {
-		if len(row) == 0 {
-			log.Printf("⚠️ 跳过第 %d 行：空行", i+1)
-			continue
-		}
 
+	processedRows = 0 // 重置处理行数
+	log.Printf("开始并发处理 %s，并发数: %d", part.Name, maxWorkers)
+	for w := 0; w < maxWorkers; w++ {
 		wg.Add(1)
-		go func(rowIndex int, input string) {
+		go func() {
 			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
-			output := apiClient.ProcessText(input)
-			resultChan <- types.Result{
-				RowIndex: rowIndex,
-				Input:    input,
-				Output:   output,
+			for job := range jobQueue {
+				// 任务取消后丢弃队列中剩余的行，不再调用大模型
+				if jobs.Cancelled(jobID) || meter.AddRows(1) != nil {
+					continue
+				}
+				job.Output = apiClient.ProcessText(job.Input)
+				resultChan <- job
+				log.Printf("已处理第 %d 行", job.RowIndex+1)
+				processedRows++
 			}
-			log.Printf("已处理第 %d 行", rowIndex+1)
-			processedRows++
-		}(i, row[0])
+		}()
 	}
 
+	// 逐行读取输入文件并分发，只保留每行第一列的文本
+	totalRows = 0
+	var readErr error
+	go func() {
+		defer close(jobQueue)
+		defer reader.Close()
+		for i := 0; ; i++ {
+			row, err := reader.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			if i < firstRow {
+				continue
+			}
+			if jobs.Cancelled(jobID) {
+				return
+			}
+			if len(row) == 0 || row[0] == "" {
+				log.Printf("⚠️ 跳过第 %d 行：空行", i+1)
+				continue
+			}
+			totalRows++
+			jobQueue <- types.Result{RowIndex: i, Input: row[0]}
+		}
+	}()
+
 	// 等待所有处理完成
 	go func() {
 		wg.Wait()
 		close(resultChan)
 	}()
 
-	// 收集并保存结果
+	// 收集结果
+	outputs := make(map[int]string)
 	for result := range resultChan {
-		excelHandler.WriteResult(result.RowIndex, result.Input, result.Output)
+		outputs[result.RowIndex] = result.Output
 	}
+	if readErr != nil {
+		return nil, 0, fmt.Errorf("读取输入文件失败: %v", readErr)
+	}
+	if err := meter.Err(); err != nil {
+		return nil, 0, err
+	}
+	if jobs.Cancelled(jobID) {
+		return nil, 0, jobs.ErrCancelled
+	}
+	log.Printf("✅ %s 处理完成，共有 %d 行数据", part.Name, len(outputs))
 
-	// 生成带时间戳的输出文件名
-	outputFileName := fmt.Sprintf("output_%s.xlsx", time.Now().Format("2006-01-02_15-04-05"))
+	// 输出为输入数据的副本，处理结果追加在最后一列之后
+	output, err := storage.New(jobs.OwnerFrom(c.Request.Context()), jobID, storage.KindResult, baseName+"."+outputFormat)
+	if err != nil {
+		return nil, 0, fmt.Errorf("保存文件失败: %v", err)
+	}
+	if inputFormat == utils.FormatXLSX && outputFormat == utils.FormatXLSX {
+		err = saveWorkbookOutput(part.Data, output.Path, opts.Sheet, headerRow, outputs)
+	} else {
+		err = saveDatasetOutput(part, output.Path, outputFormat, opts, headerRow, outputs)
+	}
+	if err == nil {
+		err = storage.Commit(output)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("保存文件失败: %v", err)
+	}
+	return output, len(outputs), nil
+}
 
-	// 保存输出文件
-	if err := excelHandler.SaveOutput(filepath.Join("./uploads", outputFileName)); err != nil {
-		log.Printf("保存文件失败: %v", err)
-		c.String(http.StatusInternalServerError, "保存文件失败")
-		return
+// outputColumnTitle 输出列的表头
+const outputColumnTitle = "模型输出"
+
+// saveWorkbookOutput 复制输入工作簿，在所选工作表最后一列之后写入处理结果，其他工作表与样式保持不变
+func saveWorkbookOutput(input io.ReadSeeker, outputPath, sheet string, headerRow bool, outputs map[int]string) error {
+	excelHandler, err := utils.NewExcelHandlerFromReader(input, sheet, headerRow)
+	if err != nil {
+		return err
 	}
+	defer excelHandler.Close()
 
-	// 输出统计信息
-	log.Printf("✅ 处理完成！")
-	log.Printf("总行数: %d", len(rows))
-	log.Printf("总耗时: %v", time.Since(startTime))
-	log.Printf("结果已保存到 %s", outputFileName)
+	if err := excelHandler.WriteHeader(outputColumnTitle); err != nil {
+		return err
+	}
+	for rowIndex, output := range outputs {
+		if err := excelHandler.WriteResult(rowIndex, output); err != nil {
+			return err
+		}
+	}
+	return excelHandler.SaveOutput(outputPath)
+}
 
-	// 返回结果给用户
-	c.JSON(http.StatusOK, gin.H{
-		"message": "处理完成！",
-		"file":    outputFileName,
-	})
+// saveDatasetOutput 再次逐行读取输入文件，保留所有列并在末尾追加处理结果，按输出格式写出。
+// 没有表头时，JSON/JSONL 输出以 column_N 作为字段名
+func saveDatasetOutput(part utils.BatchPart, outputPath, format string, opts utils.DatasetOptions, headerRow bool, outputs map[int]string) error {
+	open := func() (utils.DatasetReader, error) {
+		if _, err := part.Data.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return utils.OpenDatasetReader(part.Data, part.Filename, opts)
+	}
+
+	// 第一遍统计最大列数，使输出列对齐
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	width := 0
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reader.Close()
+			return err
+		}
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	reader.Close()
+
+	writer, err := utils.CreateDataset(outputPath, format, opts.Sheet)
+	if err != nil {
+		return err
+	}
+	reader, err = open()
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	defer reader.Close()
+
+	// JSON/JSONL 的第一行写出的是字段名，没有表头时补一行
+	if !headerRow && (format == utils.FormatJSON || format == utils.FormatJSONL) {
+		keys := make([]interface{}, width+1)
+		for i := 0; i < width; i++ {
+			keys[i] = fmt.Sprintf("column_%d", i+1)
+		}
+		keys[width] = outputColumnTitle
+		if err := writer.WriteRow(keys); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+
+	for i := 0; ; i++ {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		cells := make([]interface{}, width+1)
+		for j := 0; j < width; j++ {
+			cells[j] = ""
+			if j < len(row) {
+				cells[j] = row[j]
+			}
+		}
+		if headerRow && i == 0 {
+			cells[width] = outputColumnTitle
+		} else if output, ok := outputs[i]; ok {
+			cells[width] = output
+		}
+		if err := writer.WriteRow(cells); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
 }
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"fuzhu_2/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIP 在请求上下文中记录客户端 IP（按 TRUSTED_PROXIES 解析 X-Forwarded-For），
+// 供 net/http 形式的处理函数写审计日志
+func ClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(audit.WithClientIP(c.Request.Context(), c.ClientIP()))
+		c.Next()
+	}
+}
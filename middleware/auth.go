@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserKey gin 上下文中保存当前用户的键
+const currentUserKey = "currentUser"
+
+// apiTokenKey gin 上下文中保存本次请求使用的访问令牌的键，使用会话登录时不存在
+const apiTokenKey = "apiToken"
+
+// changePasswordPage 须修改初始密码的用户被重定向到的页面
+const changePasswordPage = "/account/password"
+
+// RequireRole 要求已登录且未停用的用户至少拥有 role 角色（admin > analyst > viewer）。
+// 登录方式为会话或请求头 Authorization: Bearer <访问令牌>，使用令牌时还要求令牌的权限范围覆盖本次请求。
+// 未登录时页面重定向到登录页、接口返回 401；权限不足时返回 403；
+// 须修改初始密码的用户页面重定向到改密页面、接口返回 403。
+// 通过后将当前用户记录到 gin 上下文和请求上下文（任务所有者）
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorizeRole(c, role) {
+			c.Next()
+		}
+	}
+}
+
+// authorizeRole 执行 RequireRole 的检查，不通过时中止请求并返回 false
+func authorizeRole(c *gin.Context, role string) bool {
+	user := requireUser(c)
+	if user == nil {
+		return false
+	}
+	if user.MustChangePassword {
+		if isAPI(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "请先修改密码", "mustChangePassword": true})
+			return false
+		}
+		c.Redirect(http.StatusFound, changePasswordPage)
+		c.Abort()
+		return false
+	}
+	if !models.RoleAtLeast(user.Role, role) {
+		if isAPI(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "权限不足"})
+			return false
+		}
+		c.String(http.StatusForbidden, "权限不足")
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// RequireLogin 只要求已登录，不检查角色和是否须修改密码，用于改密页面和接口。
+// 访问令牌同样受权限范围限制
+func RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requireUser(c) == nil {
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession 要求使用会话登录，不接受访问令牌，用于修改密码、管理令牌等账号操作，
+// 避免泄露的令牌被用来接管账号。须在登录检查（访问策略或 RequireRole）之后
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(apiTokenKey); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "该操作不支持访问令牌，请登录后操作"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireUser 返回当前用户，未登录时中止请求（页面重定向到登录页、接口返回 401）并返回 nil。
+// 使用访问令牌时检查令牌的权限范围，不足时返回 403
+func requireUser(c *gin.Context) *models.User {
+	user := loadUser(c)
+	if user == nil {
+		if bearerToken(c) != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "访问令牌无效、已撤销或已过期"})
+			return nil
+		}
+		if isAPI(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "未登录"})
+			return nil
+		}
+		c.Redirect(http.StatusFound, "/login")
+		c.Abort()
+		return nil
+	}
+	if token, ok := c.Get(apiTokenKey); ok {
+		scope := requiredScope(c)
+		if !token.(*models.APIToken).Allows(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "访问令牌没有 " + scope + " 权限"})
+			return nil
+		}
+	}
+	return user
+}
+
+// requiredScope 本次请求需要的令牌权限范围：管理接口需要 admin，GET 请求需要 read，其余需要 write
+func requiredScope(c *gin.Context) string {
+	path := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/admin/") || strings.HasPrefix(path, "/admin/"):
+		return models.ScopeAdmin
+	case c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead:
+		return models.ScopeRead
+	default:
+		return models.ScopeWrite
+	}
+}
+
+// bearerToken 返回请求头 Authorization: Bearer 中的令牌，没有时返回空字符串
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// loadUser 读取访问令牌或会话中的用户，每次请求都查询数据库，停用或删除的用户立即失效。
+// 请求带有 Bearer 令牌时只按令牌认证，不再读取会话
+func loadUser(c *gin.Context) *models.User {
+	if user, ok := c.Get(currentUserKey); ok {
+		return user.(*models.User)
+	}
+
+	if token := bearerToken(c); token != "" {
+		user, apiToken, err := models.AuthenticateAPIToken(token)
+		if err != nil || user == nil {
+			return nil
+		}
+		c.Set(apiTokenKey, apiToken)
+		c.Set(currentUserKey, user)
+		c.Request = c.Request.WithContext(jobs.WithOwner(c.Request.Context(), user.Username))
+		return user
+	}
+
+	session := sessions.Default(c)
+	username, ok := session.Get("user").(string)
+	if !ok || username == "" {
+		return nil
+	}
+	user, err := models.GetUserByUsername(username)
+	if err != nil || user == nil || user.Disabled {
+		session.Clear()
+		session.Save()
+		return nil
+	}
+
+	c.Set(currentUserKey, user)
+	c.Request = c.Request.WithContext(jobs.WithOwner(c.Request.Context(), user.Username))
+	return user
+}
+
+// CurrentUser 返回当前登录用户，未登录时返回 nil
+func CurrentUser(c *gin.Context) *models.User {
+	return loadUser(c)
+}
+
+// isAPI 接口请求返回 JSON，页面请求重定向
+func isAPI(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/api/") || c.Request.Method != http.MethodGet || bearerToken(c) != ""
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"fuzhu_2/config"
+)
+
+// LoginLimiter 按用户名和 IP 统计登录失败次数。每次尝试在 Check 时先记为失败，登录成功后再撤销，
+// 并发的请求都会被计入。同一用户名连续失败超过 freeAttempts 次后，
+// 每次失败后须等待的时间翻倍（不超过 maxDelay）；用户名或 IP 的失败次数达到上限后锁定一段时间。
+// 计数只保存在内存中，重启后清零；距上次失败超过 window 的计数自动失效
+type LoginLimiter struct {
+	mu    sync.Mutex
+	users map[string]*loginFailures
+	ips   map[string]*loginFailures
+
+	freeAttempts   int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+	maxUserFailure int
+	maxIPFailure   int
+	lockout        time.Duration
+	window         time.Duration
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// maxTrackedKeys 记录数超过后清理已失效的计数，避免被大量随机用户名撑满内存
+const maxTrackedKeys = 10000
+
+// NewLoginLimiter 按环境变量创建登录限制：LOGIN_MAX_FAILURES 同一用户名连续失败多少次后锁定（默认5），
+// LOGIN_IP_MAX_FAILURES 同一 IP 失败多少次后锁定（默认20），LOGIN_LOCKOUT 锁定时长（默认15m），
+// LOGIN_DELAY_MAX 渐进等待的上限（默认30s）
+func NewLoginLimiter() *LoginLimiter {
+	lockout := config.EnvDuration("LOGIN_LOCKOUT", 15*time.Minute)
+	return &LoginLimiter{
+		users:          make(map[string]*loginFailures),
+		ips:            make(map[string]*loginFailures),
+		freeAttempts:   2,
+		baseDelay:      time.Second,
+		maxDelay:       config.EnvDuration("LOGIN_DELAY_MAX", 30*time.Second),
+		maxUserFailure: config.EnvInt("LOGIN_MAX_FAILURES", 5),
+		maxIPFailure:   config.EnvInt("LOGIN_IP_MAX_FAILURES", 20),
+		lockout:        lockout,
+		window:         lockout,
+	}
+}
+
+// Check 返回还需等待的时间，为 0 时允许尝试登录，并在同一次加锁中把这次尝试记为失败，
+// 登录成功后须调用 Succeed 撤销；locked 表示已被锁定（而不只是渐进等待）。
+// 渐进等待只按用户名计算，IP 只在失败次数达到上限后锁定，避免同一出口 IP 的其他用户受影响
+func (l *LoginLimiter) Check(username, ip string) (wait time.Duration, locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	user := l.get(l.users, userKey(username), now)
+	for _, f := range []*loginFailures{user, l.get(l.ips, ip, now)} {
+		if f != nil && now.Before(f.lockedUntil) && f.lockedUntil.Sub(now) > wait {
+			wait, locked = f.lockedUntil.Sub(now), true
+		}
+	}
+	if !locked && user != nil {
+		if d := user.lastFailure.Add(l.delay(user.count)).Sub(now); d > 0 {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		l.recordAttempt(username, ip, now)
+	}
+	return wait, locked
+}
+
+// recordAttempt 将一次尝试记为失败，调用方须持有锁
+func (l *LoginLimiter) recordAttempt(username, ip string, now time.Time) {
+	if len(l.users)+len(l.ips) > maxTrackedKeys {
+		l.prune(now)
+	}
+	l.record(l.users, userKey(username), now, l.maxUserFailure)
+	if ip != "" {
+		l.record(l.ips, ip, now, l.maxIPFailure)
+	}
+}
+
+// Fail 登录失败后调用（失败已在 Check 时记录），返回用户名或 IP 是否已被锁定
+func (l *LoginLimiter) Fail(username, ip string) (locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, f := range []*loginFailures{l.get(l.users, userKey(username), now), l.get(l.ips, ip, now)} {
+		if f != nil && now.Before(f.lockedUntil) {
+			locked = true
+		}
+	}
+	return locked
+}
+
+// Succeed 登录成功后清除该用户名的失败计数，并撤销 Check 时为这次尝试记下的 IP 失败。
+// IP 之前的计数保留，避免用自己的账号登录来重置
+func (l *LoginLimiter) Succeed(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, userKey(username))
+	if f := l.get(l.ips, ip, time.Now()); f != nil && f.count > 0 {
+		f.count--
+	}
+}
+
+func (l *LoginLimiter) record(m map[string]*loginFailures, key string, now time.Time, max int) {
+	f := l.get(m, key, now)
+	if f == nil {
+		f = &loginFailures{}
+		m[key] = f
+	}
+	f.count++
+	f.lastFailure = now
+	if max > 0 && f.count >= max && !now.Before(f.lockedUntil) {
+		f.lockedUntil = now.Add(l.lockout)
+		f.count = 0
+	}
+}
+
+// get 返回仍然有效的计数，过期的计数会被删除
+func (l *LoginLimiter) get(m map[string]*loginFailures, key string, now time.Time) *loginFailures {
+	f, ok := m[key]
+	if !ok {
+		return nil
+	}
+	if now.Before(f.lockedUntil) || now.Sub(f.lastFailure) < l.window {
+		return f
+	}
+	delete(m, key)
+	return nil
+}
+
+// delay 连续失败 count 次后下次尝试前须等待的时间
+func (l *LoginLimiter) delay(count int) time.Duration {
+	if count <= l.freeAttempts {
+		return 0
+	}
+	d := l.baseDelay
+	for i := l.freeAttempts + 1; i < count && d < l.maxDelay; i++ {
+		d *= 2
+	}
+	if d > l.maxDelay {
+		d = l.maxDelay
+	}
+	return d
+}
+
+func (l *LoginLimiter) prune(now time.Time) {
+	for _, m := range []map[string]*loginFailures{l.users, l.ips} {
+		for key := range m {
+			l.get(m, key, now)
+		}
+	}
+}
+
+// userKey 用户名不区分大小写和首尾空白，避免换个写法绕过计数
+func userKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
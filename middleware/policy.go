@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"fuzhu_2/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 路由的访问级别。除 AccessPublic 和 AccessLogin 外，其余为角色名称，要求至少拥有该角色
+const (
+	AccessPublic  = "public"           // 无需登录
+	AccessLogin   = "login"            // 已登录即可，包括须修改初始密码的用户
+	AccessViewer  = models.RoleViewer  // 查看结果、完成分配给自己的复核
+	AccessAnalyst = models.RoleAnalyst // 上传、评分、维护词典
+	AccessAdmin   = models.RoleAdmin   // 用户管理
+)
+
+// Policy 路由访问策略，键为 "方法 路由模式"（与 gin 注册的路由一致，如 "GET /api/jobs"、
+// "GET /web/*filepath"），值为访问级别。未列出的路由一律拒绝访问
+type Policy map[string]string
+
+// Enforce 返回按策略检查每个请求的中间件，需在注册路由前通过 r.Use 加载。
+// 没有匹配路由的请求（404）直接放行，由 gin 处理
+func (p Policy) Enforce() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+		level, ok := p[c.Request.Method+" "+route]
+		if !ok {
+			log.Printf("路由 %s %s 未配置访问策略，拒绝访问", c.Request.Method, route)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "拒绝访问"})
+			return
+		}
+
+		switch level {
+		case AccessPublic:
+		case AccessLogin:
+			if requireUser(c) == nil {
+				return
+			}
+		default:
+			if !authorizeRole(c, level) {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// Check 确认每个已注册的路由都配置了有效的访问策略，启动时调用，避免新增路由时遗漏
+func (p Policy) Check(routes gin.RoutesInfo) error {
+	var missing []string
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		level, ok := p[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		if level != AccessPublic && level != AccessLogin && !models.ValidRole(level) {
+			return fmt.Errorf("路由 %s 的访问级别 %q 无效", key, level)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("以下路由未配置访问策略: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
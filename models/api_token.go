@@ -0,0 +1,166 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fuzhu_2/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// 访问令牌的权限范围：read 只能发起 GET 请求；write 可提交任务、上传和修改数据（含 read）；
+// admin 可调用管理接口（含 write）。令牌的实际权限同时受所属用户角色的限制
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// apiTokenPrefix 令牌明文的前缀，便于在日志和代码库中识别
+const apiTokenPrefix = "fz_"
+
+var scopeRank = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// ValidScope 判断权限范围名称是否有效
+func ValidScope(scope string) bool {
+	return scopeRank[scope] > 0
+}
+
+// APIToken 个人访问令牌，数据库只保存令牌的 SHA-256
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Allows 判断令牌是否拥有 scope 权限，高的权限范围包含低的
+func (t *APIToken) Allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken 为用户创建访问令牌，返回令牌明文（只在此时可见）
+func CreateAPIToken(userID int, name string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	t := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	result, err := config.DB.Exec(`INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, t.UserID, t.Name, t.Prefix, hashToken(token), strings.Join(t.Scopes, ","), t.ExpiresAt, t.CreatedAt)
+	if err != nil {
+		log.Printf("保存访问令牌失败: %v", err)
+		return "", nil, err
+	}
+	id, _ := result.LastInsertId()
+	t.ID = int(id)
+	return token, t, nil
+}
+
+const apiTokenColumns = "id, user_id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &lastUsedAt, &revokedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取访问令牌失败: %v", err)
+		return nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// ListAPITokens 列出用户的访问令牌（含已撤销和已过期的），新的在前
+func ListAPITokens(userID int) ([]APIToken, error) {
+	rows, err := config.DB.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		log.Printf("查询访问令牌失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken 撤销用户自己的令牌，令牌不存在或已撤销时返回 false
+func RevokeAPIToken(userID, id int) (bool, error) {
+	result, err := config.DB.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now(), id, userID)
+	if err != nil {
+		log.Printf("撤销访问令牌失败: %v", err)
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// AuthenticateAPIToken 校验令牌明文，返回令牌所属的用户。令牌不存在、已撤销、已过期
+// 或用户已停用时返回 nil
+func AuthenticateAPIToken(token string) (*User, *APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil, nil
+	}
+	t, err := scanAPIToken(config.DB.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hashToken(token)))
+	if err != nil || t == nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if t.RevokedAt != nil || now.After(t.ExpiresAt) {
+		return nil, nil, nil
+	}
+	user, err := GetUser(t.UserID)
+	if err != nil || user == nil || user.Disabled {
+		return nil, nil, err
+	}
+
+	if _, err := config.DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, t.ID); err != nil {
+		log.Printf("更新令牌使用时间失败: %v", err)
+	}
+	t.LastUsedAt = &now
+	return user, t, nil
+}
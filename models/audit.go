@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// 审计日志的操作类型
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditLoginLocked    = "login_locked"
+	AuditLogout         = "logout"
+	AuditUpload         = "upload"     // 上传待处理的文件或登记数据集
+	AuditJobStart       = "job_start"  // 提交批处理、评分任务
+	AuditJobCancel      = "job_cancel" // 取消任务
+	AuditDownload       = "download"
+	AuditPasswordChange = "password_change"
+	AuditPasswordReset  = "password_reset"
+	AuditTokenCreate    = "token_create"
+	AuditTokenRevoke    = "token_revoke"
+	AuditConfigChange   = "config_change"  // 同义词词典、分词配置的修改
+	AuditPromptChange   = "prompt_change"  // 项目 prompt 的新建、修改和删除
+	AuditProjectChange  = "project_change" // 项目的创建、删除与成员变更
+
+	// 管理员操作
+	AuditUserCreate     = "user_create"
+	AuditUserUpdate     = "user_update"
+	AuditUserDelete     = "user_delete"
+	AuditResetLink      = "reset_link_issue"
+	AuditSessionsRevoke = "sessions_revoke"
+)
+
+// AuditEntry 一条审计日志
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	IP        string    `json:"ip"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RecordAudit 写入审计日志。写入失败只记录日志，不影响正在进行的操作
+func RecordAudit(entry AuditEntry) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	// 登录失败时用户名来自请求，截断到列宽
+	entry.Username = truncateRunes(entry.Username, 64)
+	entry.Target = truncateRunes(entry.Target, 255)
+	_, err := config.DB.Exec(`INSERT INTO audit_logs (username, action, target, ip, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, entry.Username, entry.Action, entry.Target, entry.IP, entry.Details, entry.CreatedAt)
+	if err != nil {
+		log.Printf("写入审计日志失败 (%s %s): %v", entry.Action, entry.Username, err)
+	}
+}
+
+// truncateRunes 按字符截断字符串
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// AuditFilter 审计日志的查询条件，零值表示不限
+type AuditFilter struct {
+	Username string
+	Action   string
+	Target   string // 按前缀匹配
+	IP       string
+	From     time.Time
+	To       time.Time
+}
+
+// where 生成查询条件与参数
+func (f AuditFilter) where() (string, []interface{}) {
+	clause := " WHERE 1 = 1"
+	args := make([]interface{}, 0)
+	if f.Username != "" {
+		clause += " AND username = ?"
+		args = append(args, f.Username)
+	}
+	if f.Action != "" {
+		clause += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	if f.Target != "" {
+		clause += " AND target LIKE ?"
+		args = append(args, escapeLike(f.Target)+"%")
+	}
+	if f.IP != "" {
+		clause += " AND ip = ?"
+		args = append(args, f.IP)
+	}
+	if !f.From.IsZero() {
+		clause += " AND created_at >= ?"
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		clause += " AND created_at < ?"
+		args = append(args, f.To)
+	}
+	return clause, args
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// CountAudit 统计符合条件的审计日志条数
+func CountAudit(filter AuditFilter) (int, error) {
+	clause, args := filter.where()
+	var total int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM audit_logs"+clause, args...).Scan(&total); err != nil {
+		log.Printf("统计审计日志失败: %v", err)
+		return 0, err
+	}
+	return total, nil
+}
+
+// EachAudit 按时间倒序逐条读取符合条件的审计日志，从第 offset 条开始最多 limit 条，导出时不必全部载入内存
+func EachAudit(filter AuditFilter, offset, limit int, fn func(entry AuditEntry) error) error {
+	clause, args := filter.where()
+	args = append(args, limit, offset)
+	rows, err := config.DB.Query(`SELECT id, username, action, target, ip, details, created_at
+		FROM audit_logs`+clause+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		log.Printf("查询审计日志失败: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		var details sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Username, &entry.Action, &entry.Target, &entry.IP, &details, &entry.CreatedAt); err != nil {
+			log.Printf("读取审计日志失败: %v", err)
+			return err
+		}
+		entry.Details = details.String
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListAudit 分页查询符合条件的审计日志
+func ListAudit(filter AuditFilter, offset, limit int) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0, limit)
+	err := EachAudit(filter, offset, limit, func(entry AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
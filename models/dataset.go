@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// Dataset 数据集库中的一个数据集版本。同名数据集每次上传不同内容时版本号加一，
+// 文件按内容哈希保存，评分与批处理任务通过 ID 引用，结果可追溯到确切的数据版本
+type Dataset struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	ContentHash string    `json:"contentHash"` // 文件内容的 SHA-256
+	Filename    string    `json:"filename"`    // 上传时的文件名
+	Format      string    `json:"format"`
+	Size        int64     `json:"size"`
+	Sheet       string    `json:"sheet,omitempty"` // xlsx 统计行数与列时使用的工作表
+	RowCount    int       `json:"rowCount"`        // 数据行数（不含表头）
+	Columns     []string  `json:"columns"`         // 表头
+	StoragePath string    `json:"-"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner"`
+	ProjectID   int       `json:"projectId,omitempty"` // 所属项目，0 为公共数据集库
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+const datasetColumns = `id, name, version, content_hash, filename, format, size_bytes, sheet, row_count,
+	columns_json, storage_path, description, owner, project_id, created_at`
+
+// Label 返回带版本号的名称，如 客服问答@v3
+func (d *Dataset) Label() string {
+	return fmt.Sprintf("%s@v%d", d.Name, d.Version)
+}
+
+// Create 保存数据集，版本号为同一项目（或公共数据集库）中同名数据集的最大版本号加一
+func (d *Dataset) Create() error {
+	columns, err := json.Marshal(d.Columns)
+	if err != nil {
+		return err
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("开启事务失败: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// 锁定项目中同名数据集的记录，避免并发上传得到相同的版本号
+	var version int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM datasets WHERE project_id = ? AND name = ? FOR UPDATE",
+		d.ProjectID, d.Name).Scan(&version)
+	if err != nil {
+		log.Printf("查询数据集版本失败: %v", err)
+		return err
+	}
+	d.Version = version + 1
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+
+	result, err := tx.Exec(`INSERT INTO datasets (name, version, content_hash, filename, format, size_bytes, sheet,
+		row_count, columns_json, storage_path, description, owner, project_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Name, d.Version, d.ContentHash, d.Filename, d.Format, d.Size, d.Sheet,
+		d.RowCount, string(columns), d.StoragePath, d.Description, d.Owner, d.ProjectID, d.CreatedAt)
+	if err != nil {
+		log.Printf("保存数据集失败: %v", err)
+		return err
+	}
+	id, _ := result.LastInsertId()
+	d.ID = int(id)
+	return tx.Commit()
+}
+
+// GetDataset 按 ID 查询数据集，不存在时返回 nil
+func GetDataset(id int) (*Dataset, error) {
+	row := config.DB.QueryRow("SELECT "+datasetColumns+" FROM datasets WHERE id = ?", id)
+	return scanDataset(row)
+}
+
+// FindDatasetByHash 查询同一项目中同名且内容相同的数据集，不存在时返回 nil
+func FindDatasetByHash(projectID int, name, hash string) (*Dataset, error) {
+	row := config.DB.QueryRow("SELECT "+datasetColumns+" FROM datasets WHERE project_id = ? AND name = ? AND content_hash = ? ORDER BY version DESC LIMIT 1",
+		projectID, name, hash)
+	return scanDataset(row)
+}
+
+// DatasetFileInUse 判断是否有数据集引用文件 path
+func DatasetFileInUse(path string) (bool, error) {
+	var exists bool
+	err := config.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM datasets WHERE storage_path = ?)", path).Scan(&exists)
+	if err != nil {
+		log.Printf("查询数据集文件引用失败: %v", err)
+	}
+	return exists, err
+}
+
+// ListDatasets 列出数据集的所有版本，name 为空时列出全部，按名称和版本倒序排列。
+// projectIDs 不为 nil 时只列出公共数据集库和这些项目中的数据集
+func ListDatasets(name string, projectIDs []int) ([]Dataset, error) {
+	query := "SELECT " + datasetColumns + " FROM datasets WHERE 1 = 1"
+	args := make([]interface{}, 0, 1+len(projectIDs))
+	if name != "" {
+		query += " AND name = ?"
+		args = append(args, name)
+	}
+	if projectIDs != nil {
+		query += " AND project_id IN (0"
+		for _, id := range projectIDs {
+			query += ", ?"
+			args = append(args, id)
+		}
+		query += ")"
+	}
+	query += " ORDER BY name, version DESC"
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("查询数据集失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	datasets := make([]Dataset, 0)
+	for rows.Next() {
+		d, err := scanDataset(rows)
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, *d)
+	}
+	return datasets, rows.Err()
+}
+
+// rowScanner sql.Row 与 sql.Rows 共有的 Scan 方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDataset(row rowScanner) (*Dataset, error) {
+	var d Dataset
+	var columns, description sql.NullString
+	err := row.Scan(&d.ID, &d.Name, &d.Version, &d.ContentHash, &d.Filename, &d.Format, &d.Size, &d.Sheet,
+		&d.RowCount, &columns, &d.StoragePath, &description, &d.Owner, &d.ProjectID, &d.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取数据集失败: %v", err)
+		return nil, err
+	}
+	d.Description = description.String
+	if columns.String != "" {
+		if err := json.Unmarshal([]byte(columns.String), &d.Columns); err != nil {
+			log.Printf("解析数据集 %d 的表头失败: %v", d.ID, err)
+		}
+	}
+	return &d, nil
+}
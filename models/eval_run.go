@@ -0,0 +1,286 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fuzhu_2/config"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvalRun 一次评分运行的记录，运行记录与其结果文件长期保存，不参与过期文件的清理
+type EvalRun struct {
+	ID            int                `json:"id"`
+	JobID         string             `json:"jobId"`
+	Owner         string             `json:"owner"`
+	ModelName     string             `json:"modelName"`
+	Dataset       string             `json:"dataset"`
+	DatasetID     int                `json:"datasetId,omitempty"` // 数据集库中的数据集，上传文件评分时为0
+	ProjectID     int                `json:"projectId,omitempty"` // 任务所属项目，个人任务为0
+	PromptVersion string             `json:"promptVersion"`
+	Metric        string             `json:"metric"`
+	MetricProfile string             `json:"metricProfile"` // 指标配置，如分词项目、词典、过滤规则
+	Rows          int                `json:"rows"`
+	Mean          float64            `json:"mean"`
+	Scores        map[string]float64 `json:"scores"`                 // 各分数列的平均分
+	Slices        json.RawMessage    `json:"slices,omitempty"`       // 分组统计，原样保存
+	ResultFileID  string             `json:"resultFileId,omitempty"` // 结果文件ID，保存在 result_file 列
+	ResultFile    string             `json:"resultFile"`             // 带签名的下载链接，查询时按需生成，不保存
+	CreatedAt     time.Time          `json:"createdAt"`
+}
+
+// EvalRunFilter 查询运行记录的过滤条件，空值表示不限
+type EvalRunFilter struct {
+	Dataset   string
+	Model     string
+	Metric    string
+	Profile   string // 指标配置
+	Owner     string
+	ProjectID int   // 只查该项目的运行
+	Visible   []int // 不为 nil 时只查个人任务（项目为0）和这些项目的运行
+	Limit     int
+}
+
+// LeaderboardPoint 某个模型在一次运行中的得分
+type LeaderboardPoint struct {
+	RunID         int       `json:"runId"`
+	Mean          float64   `json:"mean"`
+	PromptVersion string    `json:"promptVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// LeaderboardEntry 排行榜中的一个模型在一种指标配置下的成绩，按最近一次运行的得分排名
+type LeaderboardEntry struct {
+	Rank          int                `json:"rank"`
+	ModelName     string             `json:"modelName"`
+	LatestMean    float64            `json:"latestMean"`
+	BestMean      float64            `json:"bestMean"`
+	Runs          int                `json:"runs"`
+	PromptVersion string             `json:"promptVersion"` // 最近一次运行的提示词版本
+	MetricProfile string             `json:"metricProfile"`
+	LastRunAt     time.Time          `json:"lastRunAt"`
+	History       []LeaderboardPoint `json:"history"` // 按时间先后排列
+}
+
+// maxLeaderboardRuns 排行榜最多统计的运行次数
+const maxLeaderboardRuns = 5000
+
+const evalRunColumns = `id, job_id, owner, model_name, dataset, prompt_version, metric, metric_profile,
+	rows_scored, mean_score, scores_json, slices_json, result_file, dataset_id, project_id, created_at`
+
+// Create 保存运行记录
+func (e *EvalRun) Create() error {
+	scores, err := json.Marshal(e.Scores)
+	if err != nil {
+		return err
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	result, err := config.DB.Exec(`INSERT INTO eval_runs (job_id, owner, model_name, dataset, prompt_version,
+		metric, metric_profile, rows_scored, mean_score, scores_json, slices_json, result_file, dataset_id, project_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.JobID, e.Owner, e.ModelName, e.Dataset, e.PromptVersion, e.Metric, e.MetricProfile,
+		e.Rows, e.Mean, string(scores), string(e.Slices), e.ResultFileID, nullInt(e.DatasetID), e.ProjectID, e.CreatedAt)
+	if err != nil {
+		log.Printf("保存评分运行记录失败: %v", err)
+		return err
+	}
+	id, _ := result.LastInsertId()
+	e.ID = int(id)
+	return nil
+}
+
+// ListEvalRuns 按条件查询运行记录，最新的在前
+func ListEvalRuns(filter EvalRunFilter) ([]EvalRun, error) {
+	conds := make([]string, 0, 4)
+	args := make([]interface{}, 0, 5)
+	for _, f := range []struct {
+		column, value string
+	}{
+		{"dataset", filter.Dataset},
+		{"model_name", filter.Model},
+		{"metric", filter.Metric},
+		{"metric_profile", filter.Profile},
+		{"owner", filter.Owner},
+	} {
+		if f.value != "" {
+			conds = append(conds, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	if filter.ProjectID > 0 {
+		conds = append(conds, "project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	if filter.Visible != nil {
+		conds = append(conds, "project_id IN (0"+strings.Repeat(", ?", len(filter.Visible))+")")
+		for _, id := range filter.Visible {
+			args = append(args, id)
+		}
+	}
+
+	query := "SELECT " + evalRunColumns + " FROM eval_runs"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("查询评分运行记录失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]EvalRun, 0)
+	for rows.Next() {
+		run, err := scanEvalRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func scanEvalRun(rows *sql.Rows) (EvalRun, error) {
+	var run EvalRun
+	var scores, slices sql.NullString
+	var datasetID sql.NullInt64
+	err := rows.Scan(&run.ID, &run.JobID, &run.Owner, &run.ModelName, &run.Dataset, &run.PromptVersion,
+		&run.Metric, &run.MetricProfile, &run.Rows, &run.Mean, &scores, &slices, &run.ResultFileID, &datasetID, &run.ProjectID, &run.CreatedAt)
+	if err != nil {
+		log.Printf("读取评分运行记录失败: %v", err)
+		return run, err
+	}
+	run.DatasetID = int(datasetID.Int64)
+	if scores.String != "" {
+		if err := json.Unmarshal([]byte(scores.String), &run.Scores); err != nil {
+			log.Printf("解析运行记录 %d 的分数失败: %v", run.ID, err)
+		}
+	}
+	if slices.String != "" && slices.String != "null" {
+		run.Slices = json.RawMessage(slices.String)
+	}
+	return run, nil
+}
+
+// EvalRunResultPaths 返回运行记录引用的结果文件在服务器上的路径
+func EvalRunResultPaths() (map[string]bool, error) {
+	rows, err := config.DB.Query(`SELECT f.path FROM files f JOIN eval_runs e ON e.result_file = f.id`)
+	if err != nil {
+		log.Printf("查询运行记录的结果文件失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths[path] = true
+	}
+	return paths, rows.Err()
+}
+
+// ListEvalDatasets 返回有运行记录的数据集及其指标，供排行榜选择。visible 不为 nil 时
+// 只统计个人任务和这些项目的运行
+func ListEvalDatasets(visible []int) (map[string][]string, error) {
+	query := "SELECT DISTINCT dataset, metric FROM eval_runs"
+	args := make([]interface{}, 0, len(visible))
+	if visible != nil {
+		query += " WHERE project_id IN (0" + strings.Repeat(", ?", len(visible)) + ")"
+		for _, id := range visible {
+			args = append(args, id)
+		}
+	}
+	rows, err := config.DB.Query(query+" ORDER BY dataset, metric", args...)
+	if err != nil {
+		log.Printf("查询数据集列表失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	datasets := make(map[string][]string)
+	for rows.Next() {
+		var dataset, metric string
+		if err := rows.Scan(&dataset, &metric); err != nil {
+			return nil, err
+		}
+		datasets[dataset] = append(datasets[dataset], metric)
+	}
+	return datasets, rows.Err()
+}
+
+// Leaderboard 统计某个数据集在某个指标上各模型的成绩，按最近一次运行的平均分降序排名。
+// 指标配置（分词配置、词典、过滤规则）不同的运行分别统计；profile 不为空时只统计该配置的运行。
+// 只统计最近的 maxLeaderboardRuns 次运行，visible 不为 nil 时只统计个人任务和这些项目的运行
+func Leaderboard(dataset, metric, profile string, visible []int) ([]LeaderboardEntry, error) {
+	runs, err := ListEvalRuns(EvalRunFilter{Dataset: dataset, Metric: metric, Profile: profile, Visible: visible, Limit: maxLeaderboardRuns})
+	if err != nil {
+		return nil, err
+	}
+
+	type entryKey struct{ model, profile string }
+	entries := make([]LeaderboardEntry, 0)
+	index := make(map[entryKey]int)
+	// runs 按时间倒序，每个模型与配置遇到的第一条即最近一次运行
+	for _, run := range runs {
+		key := entryKey{run.ModelName, run.MetricProfile}
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, LeaderboardEntry{
+				ModelName:     run.ModelName,
+				LatestMean:    run.Mean,
+				BestMean:      run.Mean,
+				PromptVersion: run.PromptVersion,
+				MetricProfile: run.MetricProfile,
+				LastRunAt:     run.CreatedAt,
+			})
+		}
+		entry := &entries[i]
+		entry.Runs++
+		if run.Mean > entry.BestMean {
+			entry.BestMean = run.Mean
+		}
+		entry.History = append(entry.History, LeaderboardPoint{
+			RunID:         run.ID,
+			Mean:          run.Mean,
+			PromptVersion: run.PromptVersion,
+			CreatedAt:     run.CreatedAt,
+		})
+	}
+
+	for i := range entries {
+		history := entries[i].History
+		for l, r := 0, len(history)-1; l < r; l, r = l+1, r-1 {
+			history[l], history[r] = history[r], history[l]
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LatestMean > entries[j].LatestMean
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+// nullInt 将0保存为 NULL
+func nullInt(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
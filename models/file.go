@@ -0,0 +1,106 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// File 存储中的一个文件（上传的输入、结果、报告或打包的 zip），Path 为服务器上的路径，不对外返回
+type File struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	JobID     string    `json:"jobId,omitempty"`
+	ProjectID int       `json:"projectId,omitempty"` // 所属项目，项目成员可下载
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+	// URL 带签名的下载链接，查询时按需生成，不保存
+	URL string `json:"url,omitempty"`
+}
+
+// Create 登记文件
+func (f *File) Create() error {
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+	_, err := config.DB.Exec(`INSERT INTO files (id, owner, job_id, project_id, kind, name, path, size, sha256, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.ID, f.Owner, f.JobID, f.ProjectID, f.Kind, f.Name, f.Path, f.Size, f.SHA256, f.CreatedAt)
+	if err != nil {
+		log.Printf("登记文件失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+const fileColumns = "id, owner, job_id, project_id, kind, name, path, size, sha256, created_at"
+
+func scanFile(row rowScanner) (*File, error) {
+	var f File
+	err := row.Scan(&f.ID, &f.Owner, &f.JobID, &f.ProjectID, &f.Kind, &f.Name, &f.Path, &f.Size, &f.SHA256, &f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取文件记录失败: %v", err)
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetFile 按 ID 查询文件，不存在时返回 nil
+func GetFile(id string) (*File, error) {
+	return scanFile(config.DB.QueryRow("SELECT "+fileColumns+" FROM files WHERE id = ?", id))
+}
+
+// ListFiles 列出用户的文件，projectID 大于0时改为列出该项目中的文件；
+// jobID 不为空时只列出该任务的文件，新的在前
+func ListFiles(owner string, projectID int, jobID string, limit int) ([]File, error) {
+	query := "SELECT " + fileColumns + " FROM files WHERE owner = ?"
+	args := []interface{}{owner}
+	if projectID > 0 {
+		query = "SELECT " + fileColumns + " FROM files WHERE project_id = ?"
+		args = []interface{}{projectID}
+	}
+	if jobID != "" {
+		query += " AND job_id = ?"
+		args = append(args, jobID)
+	}
+	query += " ORDER BY created_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("查询文件列表失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]File, 0)
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *f)
+	}
+	return files, rows.Err()
+}
+
+// DeleteFilesBefore 删除创建时间早于 t 的文件记录（文件本身由存储清理），运行记录引用的结果文件除外，
+// 返回删除的条数
+func DeleteFilesBefore(t time.Time) (int, error) {
+	result, err := config.DB.Exec(`DELETE FROM files WHERE created_at < ?
+		AND id NOT IN (SELECT result_file FROM eval_runs)`, t)
+	if err != nil {
+		log.Printf("删除过期文件记录失败: %v", err)
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
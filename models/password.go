@@ -0,0 +1,264 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"fuzhu_2/config"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordPolicy 密码策略，通过环境变量配置：PASSWORD_MIN_LENGTH 最短长度（默认8），
+// PASSWORD_MIN_CLASSES 至少包含的字符类别数（小写、大写、数字、符号，默认2），
+// PASSWORD_HISTORY 不能与最近几次使用过的密码相同（默认5，0为不限制）
+type PasswordPolicy struct {
+	MinLength  int `json:"minLength"`
+	MinClasses int `json:"minClasses"`
+	History    int `json:"history"`
+}
+
+// ErrInvalidResetToken 重置令牌不存在、已使用或已过期
+var ErrInvalidResetToken = errors.New("重置链接无效或已过期")
+
+// CurrentPasswordPolicy 读取当前的密码策略
+func CurrentPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:  config.EnvInt("PASSWORD_MIN_LENGTH", 8),
+		MinClasses: config.EnvInt("PASSWORD_MIN_CLASSES", 2),
+		History:    config.EnvInt("PASSWORD_HISTORY", 5),
+	}
+}
+
+// Describe 返回策略的文字说明
+func (p PasswordPolicy) Describe() string {
+	desc := fmt.Sprintf("至少%d个字符，包含小写字母、大写字母、数字、符号中的至少%d类", p.MinLength, p.MinClasses)
+	if p.History > 0 {
+		desc += fmt.Sprintf("，不能与最近%d次使用过的密码相同", p.History)
+	}
+	return desc
+}
+
+// Validate 检查密码的长度与复杂度，密码不能包含用户名
+func (p PasswordPolicy) Validate(username, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("密码至少需要%d个字符", p.MinLength)
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("密码需包含小写字母、大写字母、数字、符号中的至少%d类", p.MinClasses)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("密码不能包含用户名")
+	}
+	return nil
+}
+
+// CheckPassword 校验用户当前的密码
+func (u *User) CheckPassword(password string) bool {
+	var hashedPassword string
+	if err := config.DB.QueryRow("SELECT password FROM users WHERE id = ?", u.ID).Scan(&hashedPassword); err != nil {
+		log.Printf("查询用户密码失败: %v", err)
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// SetPassword 按密码策略校验并修改密码，记录到密码历史。mustChange 为 true 时下次登录须再次修改
+// （管理员重置为初始密码的情况），否则清除强制改密标记
+func (u *User) SetPassword(password string, mustChange bool) error {
+	hashedPassword, err := u.hashNewPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := updatePassword(config.DB, u.ID, hashedPassword, mustChange); err != nil {
+		return err
+	}
+	u.MustChangePassword = mustChange
+	log.Printf("用户 %s 的密码已修改", u.Username)
+	return nil
+}
+
+// hashNewPassword 按密码策略校验新密码，返回 bcrypt 哈希
+func (u *User) hashNewPassword(password string) (string, error) {
+	policy := CurrentPasswordPolicy()
+	if err := policy.Validate(u.Username, password); err != nil {
+		return "", err
+	}
+	reused, err := passwordReused(u.ID, password, policy.History)
+	if err != nil {
+		return "", err
+	}
+	if reused {
+		return "", fmt.Errorf("不能使用最近%d次用过的密码", policy.History)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("密码加密失败: %v", err)
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// execer 可执行语句的数据库连接或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// updatePassword 保存新密码的哈希并记录到密码历史
+func updatePassword(db execer, userID int, hashedPassword string, mustChange bool) error {
+	_, err := db.Exec("UPDATE users SET password = ?, must_change_password = ?, password_changed_at = ? WHERE id = ?",
+		hashedPassword, mustChange, time.Now(), userID)
+	if err != nil {
+		log.Printf("修改密码失败: %v", err)
+		return err
+	}
+	recordPasswordHistory(db, userID, hashedPassword)
+	return nil
+}
+
+// recordPasswordHistory 记录密码哈希，失败只记录日志
+func recordPasswordHistory(db execer, userID int, hash string) {
+	if _, err := db.Exec("INSERT INTO password_history (user_id, password_hash, created_at) VALUES (?, ?, ?)",
+		userID, hash, time.Now()); err != nil {
+		log.Printf("记录密码历史失败: %v", err)
+	}
+}
+
+// passwordReused 判断密码是否与当前密码或最近 history 次的密码相同
+func passwordReused(userID int, password string, history int) (bool, error) {
+	if history <= 0 {
+		return false, nil
+	}
+	rows, err := config.DB.Query(`SELECT password_hash FROM password_history WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC LIMIT ?`, userID, history)
+	if err != nil {
+		log.Printf("查询密码历史失败: %v", err)
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// CreateResetToken 为用户签发一次性密码重置令牌，返回令牌明文（只在此时可见）。
+// 数据库只保存令牌的 SHA-256，签发新令牌后该用户之前未使用的令牌作废
+func CreateResetToken(userID int, createdBy string, ttl time.Duration) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	if _, err := config.DB.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		log.Printf("作废旧的重置令牌失败: %v", err)
+		return "", time.Time{}, err
+	}
+	_, err := config.DB.Exec(`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?)`, userID, hashToken(token), expiresAt, createdBy, now)
+	if err != nil {
+		log.Printf("保存重置令牌失败: %v", err)
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ResetPassword 使用重置令牌设置新密码，令牌使用后失效。令牌在事务中先被占用（仅当未使用且未过期），
+// 再写入新密码，同一令牌并发使用时只有一次成功
+func ResetPassword(token, password string) (*User, error) {
+	var tokenID, userID int
+	err := config.DB.QueryRow("SELECT id, user_id FROM password_reset_tokens WHERE token_hash = ?",
+		hashToken(token)).Scan(&tokenID, &userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		log.Printf("查询重置令牌失败: %v", err)
+		return nil, err
+	}
+
+	user, err := GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, ErrInvalidResetToken
+	}
+	// 先校验新密码，不符合策略时令牌仍可再用
+	hashedPassword, err := user.hashNewPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("开启事务失败: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND expires_at > ?",
+		now, tokenID, now)
+	if err != nil {
+		log.Printf("标记重置令牌失败: %v", err)
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return nil, ErrInvalidResetToken
+	}
+	if err := updatePassword(tx, user.ID, hashedPassword, false); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	user.MustChangePassword = false
+	log.Printf("用户 %s 使用重置令牌修改了密码", user.Username)
+	return user, nil
+}
+
+// hashToken 返回令牌的 SHA-256（十六进制）
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,262 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// 项目角色：owner 管理成员和项目，editor 可在项目中上传数据集、维护 prompt 和提交任务，
+// viewer 只能查看项目中的数据集、prompt、任务和结果
+const (
+	ProjectRoleOwner  = "owner"
+	ProjectRoleEditor = "editor"
+	ProjectRoleViewer = "viewer"
+)
+
+// projectRoleRank 项目角色的权限高低
+var projectRoleRank = map[string]int{
+	ProjectRoleViewer: 1,
+	ProjectRoleEditor: 2,
+	ProjectRoleOwner:  3,
+}
+
+// ValidProjectRole 是否为有效的项目角色
+func ValidProjectRole(role string) bool {
+	_, ok := projectRoleRank[role]
+	return ok
+}
+
+// ProjectRoleAtLeast 项目角色 role 是否不低于 min，role 为空（非成员）时返回 false
+func ProjectRoleAtLeast(role, min string) bool {
+	return projectRoleRank[role] > 0 && projectRoleRank[role] >= projectRoleRank[min]
+}
+
+// Project 项目（小组）。数据集、prompt、任务和结果可归属于项目，在项目成员之间共享
+type Project struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Role 当前用户在项目中的角色，列表查询时填写
+	Role string `json:"role,omitempty"`
+}
+
+// ProjectMember 项目成员
+type ProjectMember struct {
+	ProjectID int       `json:"projectId"`
+	UserID    int       `json:"userId"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateProject 创建项目，创建人成为项目的 owner
+func CreateProject(name, description string, creator *User) (*Project, error) {
+	p := &Project{Name: name, Description: description, CreatedBy: creator.Username, CreatedAt: time.Now()}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("开启事务失败: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO projects (name, description, created_by, created_at) VALUES (?, ?, ?, ?)",
+		p.Name, p.Description, p.CreatedBy, p.CreatedAt)
+	if err != nil {
+		log.Printf("创建项目失败: %v", err)
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	p.ID = int(id)
+	if _, err := tx.Exec("INSERT INTO project_members (project_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		p.ID, creator.ID, ProjectRoleOwner, p.CreatedAt); err != nil {
+		log.Printf("添加项目成员失败: %v", err)
+		return nil, err
+	}
+	p.Role = ProjectRoleOwner
+	return p, tx.Commit()
+}
+
+// GetProject 按 ID 查询项目，不存在时返回 nil
+func GetProject(id int) (*Project, error) {
+	var p Project
+	err := config.DB.QueryRow("SELECT id, name, description, created_by, created_at FROM projects WHERE id = ?", id).
+		Scan(&p.ID, &p.Name, &p.Description, &p.CreatedBy, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("查询项目失败: %v", err)
+		return nil, err
+	}
+	return &p, nil
+}
+
+// FindProjectByName 按名称查询项目，不存在时返回 nil
+func FindProjectByName(name string) (*Project, error) {
+	var id int
+	err := config.DB.QueryRow("SELECT id FROM projects WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("查询项目失败: %v", err)
+		return nil, err
+	}
+	return GetProject(id)
+}
+
+// ListProjects 列出用户所属的项目及其角色；all 为 true 时列出全部项目（管理员），不属于的项目角色为空
+func ListProjects(userID int, all bool) ([]Project, error) {
+	query := `SELECT p.id, p.name, p.description, p.created_by, p.created_at, COALESCE(m.role, '')
+		FROM projects p LEFT JOIN project_members m ON m.project_id = p.id AND m.user_id = ?`
+	if !all {
+		query += " WHERE m.user_id IS NOT NULL"
+	}
+	query += " ORDER BY p.name"
+
+	rows, err := config.DB.Query(query, userID)
+	if err != nil {
+		log.Printf("查询项目列表失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := make([]Project, 0)
+	for rows.Next() {
+		var p Project
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.CreatedBy, &p.CreatedAt, &p.Role); err != nil {
+			log.Printf("读取项目失败: %v", err)
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// ProjectIDs 返回用户所属项目的 ID
+func ProjectIDs(userID int) ([]int, error) {
+	rows, err := config.DB.Query("SELECT project_id FROM project_members WHERE user_id = ?", userID)
+	if err != nil {
+		log.Printf("查询用户项目失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteProject 删除项目，成员与 prompt 随之删除（外键级联），单独设置的配额一并删除；
+// 项目中的数据集、文件保留，之后只有其所有者和管理员可以访问
+func DeleteProject(id int) error {
+	if _, err := DeleteQuota(QuotaScopeProject, id); err != nil {
+		return err
+	}
+	if _, err := config.DB.Exec("DELETE FROM projects WHERE id = ?", id); err != nil {
+		log.Printf("删除项目失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ProjectRole 返回用户在项目中的角色，不是成员时返回空字符串
+func ProjectRole(projectID, userID int) (string, error) {
+	var role string
+	err := config.DB.QueryRow("SELECT role FROM project_members WHERE project_id = ? AND user_id = ?", projectID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("查询项目角色失败: %v", err)
+		return "", err
+	}
+	return role, nil
+}
+
+// ListProjectMembers 列出项目成员
+func ListProjectMembers(projectID int) ([]ProjectMember, error) {
+	rows, err := config.DB.Query(`SELECT m.project_id, m.user_id, u.username, m.role, m.created_at
+		FROM project_members m JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = ? ORDER BY u.username`, projectID)
+	if err != nil {
+		log.Printf("查询项目成员失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]ProjectMember, 0)
+	for rows.Next() {
+		var m ProjectMember
+		if err := rows.Scan(&m.ProjectID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			log.Printf("读取项目成员失败: %v", err)
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetProjectMember 添加项目成员或修改其角色
+func SetProjectMember(projectID, userID int, role string) error {
+	_, err := config.DB.Exec(`INSERT INTO project_members (project_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE role = VALUES(role)`, projectID, userID, role, time.Now())
+	if err != nil {
+		log.Printf("设置项目成员失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// RemoveProjectMember 移除项目成员，成员不存在时返回 false
+func RemoveProjectMember(projectID, userID int) (bool, error) {
+	result, err := config.DB.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ?", projectID, userID)
+	if err != nil {
+		log.Printf("移除项目成员失败: %v", err)
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// CountProjectOwners 统计项目的 owner 人数，用于避免移除或降级最后一个 owner
+func CountProjectOwners(projectID int) (int, error) {
+	var n int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = ? AND role = ?", projectID, ProjectRoleOwner).Scan(&n)
+	return n, err
+}
+
+// HasProjectAccess 用户能否以不低于 min 的角色访问项目：管理员可访问全部项目，其他用户需是项目成员
+func HasProjectAccess(user *User, projectID int, min string) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	if user.Role == RoleAdmin {
+		return true, nil
+	}
+	role, err := ProjectRole(projectID, user.ID)
+	if err != nil {
+		return false, err
+	}
+	return ProjectRoleAtLeast(role, min), nil
+}
+
+// CanAccessProject 按用户名判断能否以不低于 min 的角色访问项目，见 HasProjectAccess
+func CanAccessProject(username string, projectID int, min string) bool {
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		return false
+	}
+	ok, err := HasProjectAccess(user, projectID, min)
+	return err == nil && ok
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// Prompt 项目中保存的 prompt，批处理时通过 promptId 引用。每次修改内容版本号加一
+type Prompt struct {
+	ID        int       `json:"id"`
+	ProjectID int       `json:"projectId"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	Version   int       `json:"version"`
+	UpdatedBy string    `json:"updatedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const promptColumns = "id, project_id, name, content, version, updated_by, created_at, updated_at"
+
+func scanPrompt(row rowScanner) (*Prompt, error) {
+	var p Prompt
+	err := row.Scan(&p.ID, &p.ProjectID, &p.Name, &p.Content, &p.Version, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取 prompt 失败: %v", err)
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SavePrompt 保存项目中的 prompt：同名 prompt 不存在时新建，内容有变化时更新并将版本号加一
+func SavePrompt(projectID int, name, content, updatedBy string) (*Prompt, error) {
+	now := time.Now()
+	_, err := config.DB.Exec(`INSERT INTO prompts (project_id, name, content, version, updated_by, created_at, updated_at)
+		VALUES (?, ?, ?, 1, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			version = IF(content = VALUES(content), version, version + 1),
+			updated_by = IF(content = VALUES(content), updated_by, VALUES(updated_by)),
+			updated_at = IF(content = VALUES(content), updated_at, VALUES(updated_at)),
+			content = VALUES(content)`,
+		projectID, name, content, updatedBy, now, now)
+	if err != nil {
+		log.Printf("保存 prompt 失败: %v", err)
+		return nil, err
+	}
+	return scanPrompt(config.DB.QueryRow("SELECT "+promptColumns+" FROM prompts WHERE project_id = ? AND name = ?", projectID, name))
+}
+
+// GetPrompt 按 ID 查询 prompt，不存在时返回 nil
+func GetPrompt(id int) (*Prompt, error) {
+	return scanPrompt(config.DB.QueryRow("SELECT "+promptColumns+" FROM prompts WHERE id = ?", id))
+}
+
+// ListPrompts 列出项目中的 prompt，按名称排序
+func ListPrompts(projectID int) ([]Prompt, error) {
+	rows, err := config.DB.Query("SELECT "+promptColumns+" FROM prompts WHERE project_id = ? ORDER BY name", projectID)
+	if err != nil {
+		log.Printf("查询 prompt 失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	prompts := make([]Prompt, 0)
+	for rows.Next() {
+		p, err := scanPrompt(rows)
+		if err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, *p)
+	}
+	return prompts, rows.Err()
+}
+
+// DeletePrompt 删除 prompt
+func DeletePrompt(id int) error {
+	if _, err := config.DB.Exec("DELETE FROM prompts WHERE id = ?", id); err != nil {
+		log.Printf("删除 prompt 失败: %v", err)
+		return err
+	}
+	return nil
+}
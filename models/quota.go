@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// 配额的适用范围
+const (
+	QuotaScopeUser    = "user"
+	QuotaScopeProject = "project"
+)
+
+// QuotaLimits 配额上限，0 为不限制。行数与 token 数按自然月累计
+type QuotaLimits struct {
+	Rows         int64 `json:"rows"`         // 每月处理的数据行数
+	Tokens       int64 `json:"tokens"`       // 每月消耗的大模型 token 数
+	Jobs         int   `json:"jobs"`         // 同时运行的任务数
+	StorageBytes int64 `json:"storageBytes"` // 结果文件与数据集占用的存储空间
+}
+
+// QuotaUsage 当前用量
+type QuotaUsage struct {
+	Period       string `json:"period"` // 行数与 token 数的统计月份，如 2024-05
+	Rows         int64  `json:"rows"`
+	Tokens       int64  `json:"tokens"`
+	Jobs         int    `json:"jobs"`
+	StorageBytes int64  `json:"storageBytes"`
+}
+
+// Quota 管理员为某个用户或项目单独设置的配额，未设置时使用环境变量中的默认配额
+type Quota struct {
+	Scope     string      `json:"scope"`
+	SubjectID int         `json:"subjectId"`
+	Subject   string      `json:"subject,omitempty"` // 用户名或项目名称，列表查询时填写
+	Limits    QuotaLimits `json:"limits"`
+	UpdatedBy string      `json:"updatedBy"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// QuotaPeriod 返回时间所在的统计月份
+func QuotaPeriod(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// GetQuota 查询用户或项目单独设置的配额，未设置时返回 nil
+func GetQuota(scope string, subjectID int) (*Quota, error) {
+	q := Quota{Scope: scope, SubjectID: subjectID}
+	err := config.DB.QueryRow(`SELECT max_rows, max_tokens, max_jobs, max_storage_bytes, updated_by, updated_at
+		FROM quotas WHERE scope = ? AND subject_id = ?`, scope, subjectID).
+		Scan(&q.Limits.Rows, &q.Limits.Tokens, &q.Limits.Jobs, &q.Limits.StorageBytes, &q.UpdatedBy, &q.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("查询配额失败: %v", err)
+		return nil, err
+	}
+	return &q, nil
+}
+
+// ListQuotas 列出所有单独设置的配额，附带用户名或项目名称
+func ListQuotas() ([]Quota, error) {
+	rows, err := config.DB.Query(`SELECT q.scope, q.subject_id, COALESCE(u.username, p.name, ''),
+			q.max_rows, q.max_tokens, q.max_jobs, q.max_storage_bytes, q.updated_by, q.updated_at
+		FROM quotas q
+		LEFT JOIN users u ON q.scope = 'user' AND u.id = q.subject_id
+		LEFT JOIN projects p ON q.scope = 'project' AND p.id = q.subject_id
+		ORDER BY q.scope, q.subject_id`)
+	if err != nil {
+		log.Printf("查询配额列表失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := make([]Quota, 0)
+	for rows.Next() {
+		var q Quota
+		if err := rows.Scan(&q.Scope, &q.SubjectID, &q.Subject, &q.Limits.Rows, &q.Limits.Tokens,
+			&q.Limits.Jobs, &q.Limits.StorageBytes, &q.UpdatedBy, &q.UpdatedAt); err != nil {
+			log.Printf("读取配额失败: %v", err)
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetQuota 为用户或项目单独设置配额
+func SetQuota(q *Quota) error {
+	q.UpdatedAt = time.Now()
+	_, err := config.DB.Exec(`INSERT INTO quotas (scope, subject_id, max_rows, max_tokens, max_jobs, max_storage_bytes, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE max_rows = VALUES(max_rows), max_tokens = VALUES(max_tokens), max_jobs = VALUES(max_jobs),
+			max_storage_bytes = VALUES(max_storage_bytes), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		q.Scope, q.SubjectID, q.Limits.Rows, q.Limits.Tokens, q.Limits.Jobs, q.Limits.StorageBytes, q.UpdatedBy, q.UpdatedAt)
+	if err != nil {
+		log.Printf("保存配额失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteQuota 删除单独设置的配额，恢复使用默认配额。配额不存在时返回 false
+func DeleteQuota(scope string, subjectID int) (bool, error) {
+	result, err := config.DB.Exec("DELETE FROM quotas WHERE scope = ? AND subject_id = ?", scope, subjectID)
+	if err != nil {
+		log.Printf("删除配额失败: %v", err)
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetQuotaUsage 查询用户或项目在统计月份中累计的行数与 token 数
+func GetQuotaUsage(scope string, subjectID int, period string) (int64, int64, error) {
+	var rowsUsed, tokensUsed int64
+	err := config.DB.QueryRow("SELECT rows_used, tokens_used FROM quota_usage WHERE scope = ? AND subject_id = ? AND period = ?",
+		scope, subjectID, period).Scan(&rowsUsed, &tokensUsed)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		log.Printf("查询配额用量失败: %v", err)
+		return 0, 0, err
+	}
+	return rowsUsed, tokensUsed, nil
+}
+
+// AddQuotaUsage 累加用户或项目在统计月份中的行数与 token 数
+func AddQuotaUsage(scope string, subjectID int, period string, rowsUsed, tokensUsed int64) error {
+	_, err := config.DB.Exec(`INSERT INTO quota_usage (scope, subject_id, period, rows_used, tokens_used, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rows_used = rows_used + VALUES(rows_used), tokens_used = tokens_used + VALUES(tokens_used),
+			updated_at = VALUES(updated_at)`,
+		scope, subjectID, period, rowsUsed, tokensUsed, time.Now())
+	if err != nil {
+		log.Printf("记录配额用量失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// QuotaReservation 计入用量的用户或项目及其每月行数、token 数上限，上限为0时不限制
+type QuotaReservation struct {
+	Scope     string
+	SubjectID int
+	MaxRows   int64
+	MaxTokens int64
+}
+
+// ReserveQuotaUsage 在一个事务中为每个用户或项目计入 rows 行与 tokens 个 token。上限写在 UPDATE 的条件中，
+// 并发的任务不会越过上限；token 用量已达上限时不再计入。有一个超出上限时都不计入，
+// 返回超出的序号，全部计入时返回 -1
+func ReserveQuotaUsage(period string, rows, tokens int64, subjects []QuotaReservation) (int, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("开启事务失败: %v", err)
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for i, s := range subjects {
+		if _, err := tx.Exec(`INSERT IGNORE INTO quota_usage (scope, subject_id, period, rows_used, tokens_used, updated_at)
+			VALUES (?, ?, ?, 0, 0, ?)`, s.Scope, s.SubjectID, period, now); err != nil {
+			log.Printf("记录配额用量失败: %v", err)
+			return -1, err
+		}
+		result, err := tx.Exec(`UPDATE quota_usage SET rows_used = rows_used + ?, tokens_used = tokens_used + ?, updated_at = ?
+			WHERE scope = ? AND subject_id = ? AND period = ?
+				AND (? = 0 OR rows_used + ? <= ?)
+				AND (? = 0 OR (tokens_used + ? <= ? AND tokens_used < ?))`,
+			rows, tokens, now, s.Scope, s.SubjectID, period,
+			s.MaxRows, rows, s.MaxRows,
+			s.MaxTokens, tokens, s.MaxTokens, s.MaxTokens)
+		if err != nil {
+			log.Printf("记录配额用量失败: %v", err)
+			return -1, err
+		}
+		if n, _ := result.RowsAffected(); n != 1 {
+			return i, nil
+		}
+	}
+	return -1, tx.Commit()
+}
+
+// ReleaseQuotaUsage 退回为每个用户或项目计入而未使用的 rows 行，用量不会减到0以下
+func ReleaseQuotaUsage(period string, rows int64, subjects []QuotaReservation) error {
+	for _, s := range subjects {
+		if _, err := config.DB.Exec(`UPDATE quota_usage SET rows_used = GREATEST(rows_used, ?) - ?, updated_at = ?
+			WHERE scope = ? AND subject_id = ? AND period = ?`,
+			rows, rows, time.Now(), s.Scope, s.SubjectID, period); err != nil {
+			log.Printf("退回配额用量失败: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// StorageUsed 统计用户（projectID 为0时）或项目的结果文件与数据集占用的存储空间（字节）
+func StorageUsed(owner string, projectID int) (int64, error) {
+	column, subject := "owner", interface{}(owner)
+	if projectID > 0 {
+		column, subject = "project_id", projectID
+	}
+	var files, datasets int64
+	err := config.DB.QueryRow("SELECT COALESCE(SUM(size), 0) FROM files WHERE "+column+" = ?", subject).Scan(&files)
+	if err == nil {
+		err = config.DB.QueryRow("SELECT COALESCE(SUM(size_bytes), 0) FROM datasets WHERE "+column+" = ?", subject).Scan(&datasets)
+	}
+	if err != nil {
+		log.Printf("统计存储用量失败: %v", err)
+		return 0, err
+	}
+	return files + datasets, nil
+}
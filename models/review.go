@@ -0,0 +1,238 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fuzhu_2/config"
+	"log"
+	"time"
+)
+
+// ReviewTask 人工复核任务：从一个评分任务中抽取计分行，分配给复核人逐条打分
+type ReviewTask struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	JobID        string    `json:"jobId"`
+	Metric       string    `json:"metric"`
+	ScoreColumns []string  `json:"scoreColumns"` // 自动指标的分数列，与条目中的 Scores 对应
+	Reviewers    []string  `json:"reviewers"`
+	Labels       []string  `json:"labels,omitempty"` // 可选的标签，如 正确、部分正确、错误
+	Scale        float64   `json:"scale"`            // 人工评分的满分，计算一致性时归一化到 0-1
+	Threshold    float64   `json:"threshold"`        // 计算 kappa 时判定通过的分数线（0-1）
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Total        int       `json:"total"`    // 条目数
+	Reviewed     int       `json:"reviewed"` // 已复核条目数
+}
+
+// ReviewItem 复核任务中的一条数据及其复核结果
+type ReviewItem struct {
+	ID         int        `json:"id"`
+	TaskID     int        `json:"taskId"`
+	Seq        int        `json:"seq"`
+	Source     string     `json:"source"` // 批量任务中的文件或工作表
+	RowID      string     `json:"rowId"`
+	References []string   `json:"references"`
+	Prediction string     `json:"prediction"`
+	Scores     []float64  `json:"scores"`
+	Reviewer   string     `json:"reviewer"`
+	Label      string     `json:"label,omitempty"`
+	HumanScore *float64   `json:"humanScore,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+}
+
+const reviewTaskColumns = `t.id, t.name, t.job_id, t.metric, t.score_columns_json, t.reviewers_json, t.labels_json,
+	t.scale, t.threshold, t.created_by, t.created_at,
+	(SELECT COUNT(*) FROM review_items i WHERE i.task_id = t.id),
+	(SELECT COUNT(*) FROM review_items i WHERE i.task_id = t.id AND i.reviewed_at IS NOT NULL)`
+
+const reviewItemColumns = `id, task_id, seq, source, row_id, references_json, prediction, scores_json,
+	reviewer, label, human_score, comment, reviewed_at`
+
+// Create 保存复核任务及其条目
+func (t *ReviewTask) Create(items []ReviewItem) error {
+	scoreColumns, _ := json.Marshal(t.ScoreColumns)
+	reviewers, _ := json.Marshal(t.Reviewers)
+	labels, _ := json.Marshal(t.Labels)
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("开启事务失败: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO review_tasks (name, job_id, metric, score_columns_json, reviewers_json,
+		labels_json, scale, threshold, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Name, t.JobID, t.Metric, string(scoreColumns), string(reviewers), string(labels),
+		t.Scale, t.Threshold, t.CreatedBy, t.CreatedAt)
+	if err != nil {
+		log.Printf("保存复核任务失败: %v", err)
+		return err
+	}
+	id, _ := result.LastInsertId()
+	t.ID = int(id)
+
+	stmt, err := tx.Prepare(`INSERT INTO review_items (task_id, seq, source, row_id, references_json,
+		prediction, scores_json, reviewer) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i := range items {
+		refs, _ := json.Marshal(items[i].References)
+		scores, _ := json.Marshal(items[i].Scores)
+		if _, err := stmt.Exec(t.ID, items[i].Seq, items[i].Source, items[i].RowID, string(refs),
+			items[i].Prediction, string(scores), items[i].Reviewer); err != nil {
+			log.Printf("保存复核条目失败: %v", err)
+			return err
+		}
+	}
+	t.Total = len(items)
+	return tx.Commit()
+}
+
+// HasMember 判断用户是否为任务的创建人或复核人
+func (t *ReviewTask) HasMember(username string) bool {
+	if t.CreatedBy == username {
+		return true
+	}
+	for _, reviewer := range t.Reviewers {
+		if reviewer == username {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReviewTask 按 ID 查询复核任务，不存在时返回 nil
+func GetReviewTask(id int) (*ReviewTask, error) {
+	row := config.DB.QueryRow("SELECT "+reviewTaskColumns+" FROM review_tasks t WHERE t.id = ?", id)
+	return scanReviewTask(row)
+}
+
+// ListReviewTasks 列出用户创建的或分配给用户的复核任务，最新的在前
+func ListReviewTasks(username string) ([]ReviewTask, error) {
+	rows, err := config.DB.Query(`SELECT `+reviewTaskColumns+` FROM review_tasks t
+		WHERE t.created_by = ? OR EXISTS(SELECT 1 FROM review_items i WHERE i.task_id = t.id AND i.reviewer = ?)
+		ORDER BY t.created_at DESC, t.id DESC`, username, username)
+	if err != nil {
+		log.Printf("查询复核任务失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]ReviewTask, 0)
+	for rows.Next() {
+		task, err := scanReviewTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, rows.Err()
+}
+
+func scanReviewTask(row rowScanner) (*ReviewTask, error) {
+	var t ReviewTask
+	var scoreColumns, reviewers, labels sql.NullString
+	err := row.Scan(&t.ID, &t.Name, &t.JobID, &t.Metric, &scoreColumns, &reviewers, &labels,
+		&t.Scale, &t.Threshold, &t.CreatedBy, &t.CreatedAt, &t.Total, &t.Reviewed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取复核任务失败: %v", err)
+		return nil, err
+	}
+	unmarshalColumn(scoreColumns, &t.ScoreColumns)
+	unmarshalColumn(reviewers, &t.Reviewers)
+	unmarshalColumn(labels, &t.Labels)
+	return &t, nil
+}
+
+// NextReviewItem 返回分配给复核人的下一条未复核条目，全部完成时返回 nil
+func NextReviewItem(taskID int, reviewer string) (*ReviewItem, error) {
+	row := config.DB.QueryRow("SELECT "+reviewItemColumns+` FROM review_items
+		WHERE task_id = ? AND reviewer = ? AND reviewed_at IS NULL ORDER BY seq LIMIT 1`, taskID, reviewer)
+	return scanReviewItem(row)
+}
+
+// GetReviewItem 按 ID 查询复核条目，不存在时返回 nil
+func GetReviewItem(id int) (*ReviewItem, error) {
+	row := config.DB.QueryRow("SELECT "+reviewItemColumns+" FROM review_items WHERE id = ?", id)
+	return scanReviewItem(row)
+}
+
+// ListReviewedItems 列出任务中已复核的条目
+func ListReviewedItems(taskID int) ([]ReviewItem, error) {
+	rows, err := config.DB.Query("SELECT "+reviewItemColumns+` FROM review_items
+		WHERE task_id = ? AND reviewed_at IS NOT NULL ORDER BY seq`, taskID)
+	if err != nil {
+		log.Printf("查询复核条目失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]ReviewItem, 0)
+	for rows.Next() {
+		item, err := scanReviewItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// Submit 保存复核结果，已复核的条目可以重新提交
+func (item *ReviewItem) Submit(label string, score *float64, comment string) error {
+	now := time.Now()
+	_, err := config.DB.Exec(`UPDATE review_items SET label = ?, human_score = ?, comment = ?, reviewed_at = ?
+		WHERE id = ?`, label, score, comment, now, item.ID)
+	if err != nil {
+		log.Printf("保存复核结果失败: %v", err)
+		return err
+	}
+	item.Label, item.HumanScore, item.Comment, item.ReviewedAt = label, score, comment, &now
+	return nil
+}
+
+func scanReviewItem(row rowScanner) (*ReviewItem, error) {
+	var item ReviewItem
+	var refs, scores sql.NullString
+	var score sql.NullFloat64
+	var reviewedAt sql.NullTime
+	err := row.Scan(&item.ID, &item.TaskID, &item.Seq, &item.Source, &item.RowID, &refs, &item.Prediction,
+		&scores, &item.Reviewer, &item.Label, &score, &item.Comment, &reviewedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取复核条目失败: %v", err)
+		return nil, err
+	}
+	unmarshalColumn(refs, &item.References)
+	unmarshalColumn(scores, &item.Scores)
+	if score.Valid {
+		item.HumanScore = &score.Float64
+	}
+	if reviewedAt.Valid {
+		item.ReviewedAt = &reviewedAt.Time
+	}
+	return &item, nil
+}
+
+// unmarshalColumn 解析保存为 JSON 的列，空值忽略
+func unmarshalColumn(value sql.NullString, v interface{}) {
+	if value.String == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(value.String), v); err != nil {
+		log.Printf("解析JSON列失败: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"database/sql"
+	"fuzhu_2/config"
+	"log"
+)
+
+// schema 启动时执行的建表语句，均使用 CREATE TABLE IF NOT EXISTS，可重复执行
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL UNIQUE,
+		password VARCHAR(255) NOT NULL,
+		role VARCHAR(16) NOT NULL DEFAULT 'viewer',
+		disabled TINYINT(1) NOT NULL DEFAULT 0,
+		must_change_password TINYINT(1) NOT NULL DEFAULT 0,
+		password_changed_at DATETIME NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS password_history (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		password_hash VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_password_history_user (user_id, created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME NULL,
+		created_by VARCHAR(64) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_password_reset_tokens_user (user_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		name VARCHAR(128) NOT NULL,
+		token_prefix VARCHAR(16) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		scopes VARCHAR(64) NOT NULL,
+		expires_at DATETIME NOT NULL,
+		last_used_at DATETIME NULL,
+		revoked_at DATETIME NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_api_tokens_user (user_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS files (
+		id CHAR(32) PRIMARY KEY,
+		owner VARCHAR(64) NOT NULL,
+		job_id VARCHAR(32) NOT NULL DEFAULT '',
+		project_id INT NOT NULL DEFAULT 0,
+		kind VARCHAR(16) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		path VARCHAR(512) NOT NULL,
+		size BIGINT NOT NULL DEFAULT 0,
+		sha256 CHAR(64) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_files_owner (owner, created_at),
+		INDEX idx_files_job (job_id),
+		INDEX idx_files_project (project_id, created_at),
+		INDEX idx_files_created (created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS projects (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(128) NOT NULL UNIQUE,
+		description VARCHAR(1000) NOT NULL DEFAULT '',
+		created_by VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS project_members (
+		project_id INT NOT NULL,
+		user_id INT NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (project_id, user_id),
+		INDEX idx_project_members_user (user_id),
+		FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS prompts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		project_id INT NOT NULL,
+		name VARCHAR(128) NOT NULL,
+		content MEDIUMTEXT NOT NULL,
+		version INT NOT NULL DEFAULT 1,
+		updated_by VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_prompts_name (project_id, name),
+		FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS quotas (
+		scope VARCHAR(16) NOT NULL,
+		subject_id INT NOT NULL,
+		max_rows BIGINT NOT NULL DEFAULT 0,
+		max_tokens BIGINT NOT NULL DEFAULT 0,
+		max_jobs INT NOT NULL DEFAULT 0,
+		max_storage_bytes BIGINT NOT NULL DEFAULT 0,
+		updated_by VARCHAR(64) NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, subject_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS quota_usage (
+		scope VARCHAR(16) NOT NULL,
+		subject_id INT NOT NULL,
+		period CHAR(7) NOT NULL,
+		rows_used BIGINT NOT NULL DEFAULT 0,
+		tokens_used BIGINT NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, subject_id, period)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id CHAR(64) PRIMARY KEY,
+		username VARCHAR(64) NOT NULL DEFAULT '',
+		data BLOB,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		INDEX idx_sessions_username (username),
+		INDEX idx_sessions_last_seen (last_seen_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS audit_logs (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL DEFAULT '',
+		action VARCHAR(64) NOT NULL,
+		target VARCHAR(255) NOT NULL DEFAULT '',
+		ip VARCHAR(64) NOT NULL DEFAULT '',
+		details TEXT,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_audit_logs_created (created_at),
+		INDEX idx_audit_logs_user (username, created_at),
+		INDEX idx_audit_logs_action (action, created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS eval_runs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id VARCHAR(32) NOT NULL,
+		owner VARCHAR(64) NOT NULL DEFAULT '',
+		model_name VARCHAR(128) NOT NULL,
+		dataset VARCHAR(255) NOT NULL,
+		prompt_version VARCHAR(128) NOT NULL DEFAULT '',
+		metric VARCHAR(32) NOT NULL,
+		metric_profile VARCHAR(255) NOT NULL DEFAULT '',
+		rows_scored INT NOT NULL DEFAULT 0,
+		mean_score DOUBLE NOT NULL DEFAULT 0,
+		scores_json TEXT,
+		slices_json MEDIUMTEXT,
+		result_file VARCHAR(255) NOT NULL DEFAULT '',
+		dataset_id INT NULL,
+		project_id INT NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_eval_runs_dataset (dataset, metric, created_at),
+		INDEX idx_eval_runs_model (model_name, created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS datasets (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		version INT NOT NULL,
+		content_hash CHAR(64) NOT NULL,
+		filename VARCHAR(255) NOT NULL,
+		format VARCHAR(16) NOT NULL,
+		size_bytes BIGINT NOT NULL DEFAULT 0,
+		sheet VARCHAR(255) NOT NULL DEFAULT '',
+		row_count INT NOT NULL DEFAULT 0,
+		columns_json TEXT,
+		storage_path VARCHAR(512) NOT NULL,
+		description TEXT,
+		owner VARCHAR(64) NOT NULL DEFAULT '',
+		project_id INT NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_datasets_version (project_id, name, version),
+		INDEX idx_datasets_hash (content_hash)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS review_tasks (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		job_id VARCHAR(32) NOT NULL,
+		metric VARCHAR(32) NOT NULL,
+		score_columns_json TEXT,
+		reviewers_json TEXT,
+		labels_json TEXT,
+		scale DOUBLE NOT NULL DEFAULT 1,
+		threshold DOUBLE NOT NULL DEFAULT 0.5,
+		created_by VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS review_items (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		task_id INT NOT NULL,
+		seq INT NOT NULL,
+		source VARCHAR(255) NOT NULL DEFAULT '',
+		row_id VARCHAR(255) NOT NULL DEFAULT '',
+		references_json MEDIUMTEXT,
+		prediction MEDIUMTEXT,
+		scores_json TEXT,
+		reviewer VARCHAR(64) NOT NULL,
+		label VARCHAR(64) NOT NULL DEFAULT '',
+		human_score DOUBLE NULL,
+		comment VARCHAR(2000) NOT NULL DEFAULT '',
+		reviewed_at DATETIME NULL,
+		INDEX idx_review_items_task (task_id, reviewer, reviewed_at, seq),
+		FOREIGN KEY (task_id) REFERENCES review_tasks(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+}
+
+// columns 已有数据表后来新增的列，建表语句中也已包含，这里为旧库补齐
+var columns = []struct {
+	table, column, definition string
+}{
+	{"eval_runs", "dataset_id", "INT NULL"},
+	{"users", "role", "VARCHAR(16) NOT NULL DEFAULT 'viewer'"},
+	{"users", "disabled", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"users", "created_at", "DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	{"users", "must_change_password", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"users", "password_changed_at", "DATETIME NULL"},
+	{"datasets", "project_id", "INT NOT NULL DEFAULT 0"},
+	{"files", "project_id", "INT NOT NULL DEFAULT 0"},
+	{"eval_runs", "project_id", "INT NOT NULL DEFAULT 0"},
+}
+
+// uniqueKeys 需要调整列的唯一索引，索引不存在或列不一致时重建
+var uniqueKeys = []struct {
+	table, name, columns string
+}{
+	// 数据集版本号原先全局按名称编号，改为按项目编号
+	{"datasets", "uk_datasets_version", "project_id,name,version"},
+}
+
+// migrations 旧数据的一次性迁移，重复执行没有影响
+var migrations = []string{
+	// 运行记录原先保存24小时后过期的签名链接 /files/<ID>?expires=...，改为只保存文件ID
+	`UPDATE eval_runs SET result_file = SUBSTRING_INDEX(SUBSTRING(result_file, 8), '?', 1)
+		WHERE result_file LIKE '/files/%'`,
+}
+
+// InitSchema 创建缺失的数据表
+func InitSchema() error {
+	for _, stmt := range schema {
+		if _, err := config.DB.Exec(stmt); err != nil {
+			log.Printf("创建数据表失败: %v", err)
+			return err
+		}
+	}
+	for _, c := range columns {
+		if err := addColumn(c.table, c.column, c.definition); err != nil {
+			log.Printf("添加列 %s.%s 失败: %v", c.table, c.column, err)
+			return err
+		}
+	}
+	for _, k := range uniqueKeys {
+		if err := ensureUniqueKey(k.table, k.name, k.columns); err != nil {
+			log.Printf("调整索引 %s.%s 失败: %v", k.table, k.name, err)
+			return err
+		}
+	}
+	for _, stmt := range migrations {
+		if _, err := config.DB.Exec(stmt); err != nil {
+			log.Printf("迁移数据失败: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn 列不存在时添加
+func addColumn(table, column, definition string) error {
+	var exists bool
+	err := config.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?)`, table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = config.DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// ensureUniqueKey 唯一索引的列（逗号分隔）与 columns 不一致时重建
+func ensureUniqueKey(table, name, columns string) error {
+	var current sql.NullString
+	err := config.DB.QueryRow(`SELECT GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`, table, name).Scan(&current)
+	if err != nil || current.String == columns {
+		return err
+	}
+	stmt := "ALTER TABLE " + table + " "
+	if current.Valid {
+		stmt += "DROP INDEX " + name + ", "
+	}
+	_, err = config.DB.Exec(stmt + "ADD UNIQUE KEY " + name + " (" + columns + ")")
+	return err
+}
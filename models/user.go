@@ -1,16 +1,50 @@
 package models
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
 	"fuzhu_2/config"
 	"log"
+	"os"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// 用户角色：admin 可管理用户；analyst 可上传、评分和维护词典等；viewer 可查看结果并完成分配给自己的复核
+const (
+	RoleAdmin   = "admin"
+	RoleAnalyst = "analyst"
+	RoleViewer  = "viewer"
+)
+
+// roleRank 角色的权限高低，高的角色拥有低角色的全部权限
+var roleRank = map[string]int{
+	RoleViewer:  1,
+	RoleAnalyst: 2,
+	RoleAdmin:   3,
+}
+
+// ValidRole 判断角色名称是否有效
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// RoleAtLeast 判断 role 是否拥有 required 角色的权限
+func RoleAtLeast(role, required string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[required]
+}
+
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password,omitempty"`
+	Role      string    `json:"role"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	// MustChangePassword 使用初始密码（管理员创建或首次启动生成）时为 true，修改密码前只能访问改密页面
+	MustChangePassword bool `json:"mustChangePassword"`
 }
 
 func (u *User) Create() error {
@@ -29,62 +63,206 @@ func (u *User) Create() error {
 		return err
 	}
 
+	// 未指定角色时为只读用户
+	if u.Role == "" {
+		u.Role = RoleViewer
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+
 	// 插入用户数据
-	result, err := config.DB.Exec("INSERT INTO users (username, password) VALUES (?, ?)",
-		u.Username, string(hashedPassword))
+	result, err := config.DB.Exec(`INSERT INTO users (username, password, role, disabled, must_change_password,
+		password_changed_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		u.Username, string(hashedPassword), u.Role, u.Disabled, u.MustChangePassword, u.CreatedAt, u.CreatedAt)
 	if err != nil {
 		log.Printf("插入用户数据失败: %v", err)
 		return err
 	}
 
 	id, _ := result.LastInsertId()
+	u.ID = int(id)
+	recordPasswordHistory(config.DB, u.ID, string(hashedPassword))
 	log.Printf("用户创建成功，ID: %d", id)
 	return nil
 }
 
+// Authenticate 校验用户名和密码，已停用的用户无法登录。日志中不记录密码及其哈希
 func (u *User) Authenticate() bool {
 	var hashedPassword string
-	err := config.DB.QueryRow("SELECT password FROM users WHERE username = ?",
-		u.Username).Scan(&hashedPassword)
+	var disabled bool
+	err := config.DB.QueryRow("SELECT password, disabled FROM users WHERE username = ?",
+		u.Username).Scan(&hashedPassword, &disabled)
+	if err == sql.ErrNoRows {
+		// 用户不存在时也计算一次哈希，避免通过响应时间判断用户名是否存在
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(u.Password))
+		return false
+	}
 	if err != nil {
 		log.Printf("查询用户密码失败: %v", err)
 		return false
 	}
 
-	log.Printf("数据库中的密码哈希: %s", hashedPassword)
-	log.Printf("用户输入的密码: %s", u.Password)
 	//通过比较用户输入的密码和存储的哈希值来验证密码的正确性。
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(u.Password))
-	if err != nil {
-		log.Printf("密码验证失败: %v", err)
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(u.Password)) != nil {
+		return false
+	}
+	if disabled {
+		log.Printf("已停用的用户 %s 尝试登录", u.Username)
 		return false
 	}
 	return true
 }
 
-// 添加创建测试用户的函数
-func CreateTestUser() error {
-	// 检查用户是否已存在
-	var exists bool
-	err := config.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", "test").Scan(&exists)
+// dummyPasswordHash 用户不存在时用于比较的哈希
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+const userColumns = "id, username, role, disabled, created_at, must_change_password"
+
+func scanUser(row rowScanner) (*User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &u.CreatedAt, &u.MustChangePassword)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("读取用户失败: %v", err)
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByUsername 按用户名查询用户（不含密码），不存在时返回 nil
+func GetUserByUsername(username string) (*User, error) {
+	return scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+// GetUser 按 ID 查询用户（不含密码），不存在时返回 nil
+func GetUser(id int) (*User, error) {
+	return scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// ListUsers 列出所有用户（不含密码）
+func ListUsers() ([]User, error) {
+	rows, err := config.DB.Query("SELECT " + userColumns + " FROM users ORDER BY id")
 	if err != nil {
-		log.Printf("检查用户是否存在失败: %v", err)
+		log.Printf("查询用户列表失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// SetRole 修改用户角色
+func (u *User) SetRole(role string) error {
+	if _, err := config.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, u.ID); err != nil {
+		log.Printf("修改用户角色失败: %v", err)
+		return err
+	}
+	u.Role = role
+	return nil
+}
+
+// SetDisabled 停用或启用用户
+func (u *User) SetDisabled(disabled bool) error {
+	if _, err := config.DB.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, u.ID); err != nil {
+		log.Printf("修改用户状态失败: %v", err)
 		return err
 	}
+	u.Disabled = disabled
+	return nil
+}
 
-	if !exists {
-		log.Printf("创建测试用户 'test'")
-		user := &User{
-			Username: "test",
-			Password: "123456",
+// Delete 删除用户及其密码历史、重置令牌、访问令牌、项目成员关系和单独设置的配额
+func (u *User) Delete() error {
+	for _, table := range []string{"password_history", "password_reset_tokens", "api_tokens", "project_members"} {
+		if _, err := config.DB.Exec("DELETE FROM "+table+" WHERE user_id = ?", u.ID); err != nil {
+			log.Printf("删除用户关联数据失败 (%s): %v", table, err)
+			return err
 		}
-		if err := user.Create(); err != nil {
-			log.Printf("创建测试用户失败: %v", err)
+	}
+	if _, err := DeleteQuota(QuotaScopeUser, u.ID); err != nil {
+		return err
+	}
+	if _, err := config.DB.Exec("DELETE FROM users WHERE id = ?", u.ID); err != nil {
+		log.Printf("删除用户失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CountActiveAdmins 统计未停用的管理员人数，用于避免停用或删除最后一个管理员
+func CountActiveAdmins() (int, error) {
+	var n int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0", RoleAdmin).Scan(&n)
+	return n, err
+}
+
+// BootstrapAdmin 首次启动时（没有可用的管理员）创建管理员账号。用户名取环境变量 ADMIN_USERNAME
+// （默认 admin），密码取 ADMIN_PASSWORD，未设置时随机生成并在日志中输出一次；首次登录后须修改密码。
+// 旧版本内置的 test/123456 测试账号如仍使用默认密码，会被停用
+func BootstrapAdmin() error {
+	disableLegacyTestUser()
+
+	n, err := CountActiveAdmins()
+	if err != nil {
+		log.Printf("查询管理员失败: %v", err)
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
 			return err
 		}
-		log.Printf("测试用户创建成功")
+		password = base64.RawURLEncoding.EncodeToString(buf)
+	}
+
+	admin := &User{Username: username, Password: password, Role: RoleAdmin, MustChangePassword: true}
+	if err := admin.Create(); err != nil {
+		log.Printf("创建管理员 %s 失败: %v", username, err)
+		return err
+	}
+	if generated {
+		log.Printf("已创建管理员 %s，初始密码: %s（仅显示一次，请登录后修改）", username, password)
 	} else {
-		log.Printf("测试用户已存在")
+		log.Printf("已创建管理员 %s，密码来自环境变量 ADMIN_PASSWORD", username)
 	}
 	return nil
 }
+
+// disableLegacyTestUser 停用仍使用默认密码的 test 账号
+func disableLegacyTestUser() {
+	var hashedPassword string
+	var disabled bool
+	err := config.DB.QueryRow("SELECT password, disabled FROM users WHERE username = ?", "test").Scan(&hashedPassword, &disabled)
+	if err != nil || disabled {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte("123456")) != nil {
+		return
+	}
+	if _, err := config.DB.Exec("UPDATE users SET disabled = 1 WHERE username = ?", "test"); err != nil {
+		log.Printf("停用测试账号失败: %v", err)
+		return
+	}
+	log.Printf("测试账号 test 使用默认密码，已停用")
+}
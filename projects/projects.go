@@ -0,0 +1,257 @@
+package projects
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// ProjectResponse 项目接口的响应结构
+type ProjectResponse struct {
+	Status   string                 `json:"status"`
+	Message  string                 `json:"message,omitempty"`
+	Project  *models.Project        `json:"project,omitempty"`
+	Projects []models.Project       `json:"projects,omitempty"`
+	Members  []models.ProjectMember `json:"members,omitempty"`
+}
+
+func writeProjectResponse(w http.ResponseWriter, status int, response ProjectResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// currentUser 当前登录用户
+func currentUser(r *http.Request) (*models.User, error) {
+	return models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+}
+
+// Resolve 读取参数 projectId 并检查当前用户在项目中的角色不低于 minRole（管理员不受限制）。
+// 未指定项目时返回0，表示个人任务或公共数据集库。失败时返回状态码和提示
+func Resolve(r *http.Request, minRole string) (int, int, string) {
+	value := strings.TrimSpace(r.FormValue("projectId"))
+	if value == "" || value == "0" {
+		return 0, http.StatusOK, ""
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return 0, http.StatusBadRequest, "无效的项目ID"
+	}
+	if status, msg := Check(r, id, minRole); status != http.StatusOK {
+		return 0, status, msg
+	}
+	return id, http.StatusOK, ""
+}
+
+// Check 检查当前用户在项目 id 中的角色不低于 minRole（管理员不受限制），失败时返回状态码和提示
+func Check(r *http.Request, id int, minRole string) (int, string) {
+	project, err := models.GetProject(id)
+	if err != nil {
+		return http.StatusInternalServerError, "查询项目失败"
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		return http.StatusInternalServerError, "查询用户失败"
+	}
+	ok, err := models.HasProjectAccess(user, id, models.ProjectRoleViewer)
+	if err != nil {
+		return http.StatusInternalServerError, "查询项目角色失败"
+	}
+	// 非成员看不到项目是否存在
+	if project == nil || !ok {
+		return http.StatusNotFound, "项目不存在"
+	}
+	if ok, _ := models.HasProjectAccess(user, id, minRole); !ok {
+		return http.StatusForbidden, fmt.Sprintf("需要项目 %s 的 %s 及以上角色", project.Name, minRole)
+	}
+	return http.StatusOK, ""
+}
+
+// Require 与 Resolve 相同，但必须指定项目
+func Require(r *http.Request, minRole string) (int, int, string) {
+	id, status, msg := Resolve(r, minRole)
+	if id == 0 && status == http.StatusOK {
+		return 0, http.StatusBadRequest, "缺少参数 projectId"
+	}
+	return id, status, msg
+}
+
+// ListProjects 列出当前用户所属的项目及其角色，管理员列出全部项目
+func ListProjects(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	projects, err := models.ListProjects(user.ID, user.Role == models.RoleAdmin)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询项目失败"})
+		return
+	}
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Projects: projects})
+}
+
+// CreateProject 创建项目，参数 name、description。创建人成为项目的 owner
+func CreateProject(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len([]rune(name)) > 100 {
+		writeProjectResponse(w, http.StatusBadRequest, ProjectResponse{Status: "error", Message: "项目名称不能为空且不超过100个字符"})
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil || user == nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+	existing, err := models.FindProjectByName(name)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询项目失败"})
+		return
+	}
+	if existing != nil {
+		writeProjectResponse(w, http.StatusConflict, ProjectResponse{Status: "error", Message: "项目名称已存在"})
+		return
+	}
+	project, err := models.CreateProject(name, strings.TrimSpace(r.FormValue("description")), user)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "创建项目失败"})
+		return
+	}
+	audit.Record(r, user.Username, models.AuditProjectChange, projectTarget(project.ID), "创建项目 "+project.Name)
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Message: "项目已创建", Project: project})
+}
+
+// DeleteProject 删除项目，参数 projectId，需要项目 owner 角色。项目中的数据集和结果文件保留，
+// 之后只有其所有者和管理员可以访问
+func DeleteProject(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleOwner)
+	if id == 0 {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	if err := models.DeleteProject(id); err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "删除项目失败"})
+		return
+	}
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditProjectChange, projectTarget(id), "删除项目")
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Message: "项目已删除"})
+}
+
+// ListMembers 列出项目成员，参数 projectId
+func ListMembers(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleViewer)
+	if id == 0 {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	members, err := models.ListProjectMembers(id)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询项目成员失败"})
+		return
+	}
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Members: members})
+}
+
+// SetMember 添加项目成员或修改其角色，参数 projectId、username、role（owner、editor 或 viewer），
+// 需要项目 owner 角色。项目至少保留一个 owner
+func SetMember(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleOwner)
+	if id == 0 {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	role := strings.TrimSpace(r.FormValue("role"))
+	if !models.ValidProjectRole(role) {
+		writeProjectResponse(w, http.StatusBadRequest, ProjectResponse{Status: "error", Message: "角色应为 owner、editor 或 viewer"})
+		return
+	}
+	user, status, msg := memberUser(r)
+	if user == nil {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	current, err := models.ProjectRole(id, user.ID)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询项目角色失败"})
+		return
+	}
+	if current == models.ProjectRoleOwner && role != models.ProjectRoleOwner && !otherOwnerExists(id) {
+		writeProjectResponse(w, http.StatusBadRequest, ProjectResponse{Status: "error", Message: "项目至少需要保留一个 owner"})
+		return
+	}
+	if err := models.SetProjectMember(id, user.ID, role); err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "设置项目成员失败"})
+		return
+	}
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditProjectChange, projectTarget(id),
+		audit.Details(map[string]interface{}{"member": user.Username, "role": role}))
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Message: fmt.Sprintf("%s 的项目角色已设为 %s", user.Username, role)})
+}
+
+// RemoveMember 移除项目成员，参数 projectId、username，需要项目 owner 角色。项目至少保留一个 owner
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleOwner)
+	if id == 0 {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	user, status, msg := memberUser(r)
+	if user == nil {
+		writeProjectResponse(w, status, ProjectResponse{Status: "error", Message: msg})
+		return
+	}
+	current, err := models.ProjectRole(id, user.ID)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "查询项目角色失败"})
+		return
+	}
+	if current == models.ProjectRoleOwner && !otherOwnerExists(id) {
+		writeProjectResponse(w, http.StatusBadRequest, ProjectResponse{Status: "error", Message: "项目至少需要保留一个 owner"})
+		return
+	}
+	removed, err := models.RemoveProjectMember(id, user.ID)
+	if err != nil {
+		writeProjectResponse(w, http.StatusInternalServerError, ProjectResponse{Status: "error", Message: "移除项目成员失败"})
+		return
+	}
+	if !removed {
+		writeProjectResponse(w, http.StatusNotFound, ProjectResponse{Status: "error", Message: user.Username + " 不是项目成员"})
+		return
+	}
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditProjectChange, projectTarget(id),
+		audit.Details(map[string]interface{}{"member": user.Username, "removed": true}))
+	writeProjectResponse(w, http.StatusOK, ProjectResponse{Status: "success", Message: user.Username + " 已移出项目"})
+}
+
+// memberUser 按参数 username 查询要添加或移除的用户。失败时返回状态码和提示
+func memberUser(r *http.Request) (*models.User, int, string) {
+	username := strings.TrimSpace(r.FormValue("username"))
+	if username == "" {
+		return nil, http.StatusBadRequest, "缺少参数 username"
+	}
+	user, err := models.GetUserByUsername(username)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "查询用户失败"
+	}
+	if user == nil {
+		return nil, http.StatusNotFound, "用户不存在"
+	}
+	return user, http.StatusOK, ""
+}
+
+// otherOwnerExists 除要修改的成员外项目是否还有其他 owner
+func otherOwnerExists(projectID int) bool {
+	n, err := models.CountProjectOwners(projectID)
+	return err == nil && n > 1
+}
+
+// projectTarget 审计日志中项目的对象标识
+func projectTarget(id int) string {
+	return fmt.Sprintf("project:%d", id)
+}
@@ -0,0 +1,130 @@
+package projects
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// maxPromptBytes prompt 内容的最大长度
+const maxPromptBytes = 64 * 1024
+
+// PromptResponse prompt 接口的响应结构
+type PromptResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Prompt  *models.Prompt  `json:"prompt,omitempty"`
+	Prompts []models.Prompt `json:"prompts,omitempty"`
+}
+
+func writePromptResponse(w http.ResponseWriter, status int, response PromptResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListPrompts 列出项目中的 prompt，参数 projectId
+func ListPrompts(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleViewer)
+	if id == 0 {
+		writePromptResponse(w, status, PromptResponse{Status: "error", Message: msg})
+		return
+	}
+	prompts, err := models.ListPrompts(id)
+	if err != nil {
+		writePromptResponse(w, http.StatusInternalServerError, PromptResponse{Status: "error", Message: "查询 prompt 失败"})
+		return
+	}
+	writePromptResponse(w, http.StatusOK, PromptResponse{Status: "success", Prompts: prompts})
+}
+
+// SavePrompt 新建或修改项目中的 prompt，参数 projectId、name、content，需要项目 editor 角色。
+// 同名 prompt 内容变化时版本号加一
+func SavePrompt(w http.ResponseWriter, r *http.Request) {
+	id, status, msg := Require(r, models.ProjectRoleEditor)
+	if id == 0 {
+		writePromptResponse(w, status, PromptResponse{Status: "error", Message: msg})
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	content := r.FormValue("content")
+	if name == "" || len([]rune(name)) > 100 {
+		writePromptResponse(w, http.StatusBadRequest, PromptResponse{Status: "error", Message: "prompt 名称不能为空且不超过100个字符"})
+		return
+	}
+	if strings.TrimSpace(content) == "" || len(content) > maxPromptBytes {
+		writePromptResponse(w, http.StatusBadRequest, PromptResponse{Status: "error", Message: "prompt 内容不能为空且不超过64KB"})
+		return
+	}
+	owner := jobs.OwnerFrom(r.Context())
+	prompt, err := models.SavePrompt(id, name, content, owner)
+	if err != nil || prompt == nil {
+		writePromptResponse(w, http.StatusInternalServerError, PromptResponse{Status: "error", Message: "保存 prompt 失败"})
+		return
+	}
+	audit.Record(r, owner, models.AuditPromptChange, promptTarget(prompt.ID),
+		audit.Details(map[string]interface{}{"projectId": id, "name": name, "version": prompt.Version}))
+	writePromptResponse(w, http.StatusOK, PromptResponse{Status: "success", Message: fmt.Sprintf("prompt %s 已保存（v%d）", name, prompt.Version), Prompt: prompt})
+}
+
+// DeletePrompt 删除项目中的 prompt，参数 projectId、id，需要项目 editor 角色
+func DeletePrompt(w http.ResponseWriter, r *http.Request) {
+	projectID, status, msg := Require(r, models.ProjectRoleEditor)
+	if projectID == 0 {
+		writePromptResponse(w, status, PromptResponse{Status: "error", Message: msg})
+		return
+	}
+	prompt, status, msg := lookupPrompt(r.FormValue("id"), projectID)
+	if prompt == nil {
+		writePromptResponse(w, status, PromptResponse{Status: "error", Message: msg})
+		return
+	}
+	if err := models.DeletePrompt(prompt.ID); err != nil {
+		writePromptResponse(w, http.StatusInternalServerError, PromptResponse{Status: "error", Message: "删除 prompt 失败"})
+		return
+	}
+	audit.Record(r, jobs.OwnerFrom(r.Context()), models.AuditPromptChange, promptTarget(prompt.ID),
+		audit.Details(map[string]interface{}{"projectId": projectID, "name": prompt.Name, "deleted": true}))
+	writePromptResponse(w, http.StatusOK, PromptResponse{Status: "success", Message: "prompt 已删除"})
+}
+
+// ResolvePrompt 读取参数 promptId 对应的 prompt，prompt 须属于 projectId 指定的项目且当前用户有 minRole 角色。
+// 未指定 promptId 时返回 nil。失败时返回状态码和提示
+func ResolvePrompt(r *http.Request, minRole string) (*models.Prompt, int, string) {
+	value := strings.TrimSpace(r.FormValue("promptId"))
+	if value == "" {
+		return nil, http.StatusOK, ""
+	}
+	projectID, status, msg := Require(r, minRole)
+	if projectID == 0 {
+		return nil, status, msg
+	}
+	return lookupPrompt(value, projectID)
+}
+
+// lookupPrompt 查询项目中的 prompt。失败时返回状态码和提示
+func lookupPrompt(value string, projectID int) (*models.Prompt, int, string) {
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return nil, http.StatusBadRequest, "无效的 prompt ID"
+	}
+	prompt, err := models.GetPrompt(id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "查询 prompt 失败"
+	}
+	if prompt == nil || prompt.ProjectID != projectID {
+		return nil, http.StatusNotFound, "prompt 不存在"
+	}
+	return prompt, http.StatusOK, ""
+}
+
+// promptTarget 审计日志中 prompt 的对象标识
+func promptTarget(id int) string {
+	return fmt.Sprintf("prompt:%d", id)
+}
@@ -5,6 +5,7 @@ import time
 from flask import Flask, request, jsonify, send_file
 from flask_cors import CORS
 from openpyxl import Workbook
+from openpyxl.utils import column_index_from_string, get_column_letter
 import os
 from datetime import datetime
 import logging
@@ -130,6 +131,22 @@ def assess(file_path, ref, ans, data):
             ws.cell(row=row_idx, column=similarity_column_index, value=value)
         wb.save(file_path)
 
+def resolve_column(headers, name):
+    """按表头名称或列字母（如 B、AA）查找列号（从1开始），表头名称优先"""
+    for idx, header in enumerate(headers, start=1):
+        if header is not None and str(header).strip() == name:
+            return idx
+    if name.isalpha():
+        try:
+            idx = column_index_from_string(name.upper())
+            if idx <= len(headers):
+                return idx
+        except ValueError:
+            pass
+    available = ', '.join(f"{get_column_letter(i)}={'' if h is None else str(h).strip()!r}"
+                          for i, h in enumerate(headers, start=1))
+    raise ValueError(f"列 '{name}' 不存在，可用的表头: {available}")
+
 @app.route('/api/calculate-ass', methods=['POST'])
 def process_excel():
     logger.info("收到文件上传请求")
@@ -148,58 +165,55 @@ def process_excel():
         # 读取Excel文件
         logger.info("开始读取Excel文件")
         wb = load_workbook(file)
-        ws = wb.active
+
+        # 选择工作表，未指定时使用第一个工作表
+        sheet_name = (request.form.get('sheet') or '').strip()
+        if sheet_name:
+            if sheet_name not in wb.sheetnames:
+                return jsonify({'error': f"工作表 '{sheet_name}' 不存在，可用的工作表: {', '.join(wb.sheetnames)}"}), 400
+            ws = wb[sheet_name]
+        else:
+            ws = wb.worksheets[0]
         
         # 检查工作表是否为空
         if ws.max_row < 2:  # 如果只有表头或完全为空
             logger.error("Excel文件为空或只有表头")
             return jsonify({'error': 'Excel文件为空或只有表头'}), 400
-            
-        # 检查工作表的列数
-        if ws.max_column < 2:
-            logger.error("Excel文件格式不正确：至少需要2列数据")
-            return jsonify({'error': 'Excel文件格式不正确：至少需要2列数据'}), 400
-        
-        # 创建结果工作簿
-        logger.info("创建结果工作簿")
-        result_wb = Workbook()
-        result_ws = result_wb.active
-        
-        # 设置表头
-        headers = ['标准答案', '预测文本', 'ASS分数']
-        for col, header in enumerate(headers, 1):
-            result_ws.cell(row=1, column=col, value=header)
+
+        # 按表头名称或列字母解析标准答案列和预测文本列，计算前先校验
+        headers = [cell.value for cell in ws[1]]
+        try:
+            ref_col = resolve_column(headers, (request.form.get('refColumns') or 'A').split(',')[0].strip())
+            pred_col = resolve_column(headers, (request.form.get('predColumn') or 'B').strip())
+        except ValueError as e:
+            logger.error(str(e))
+            return jsonify({'error': str(e)}), 400
+
+        # 在原工作表末尾追加分数列，保留原有的所有列
+        score_col = ws.max_column + 1
+        ws.cell(row=1, column=score_col, value='ASS分数')
             
         # 处理每一行数据
         row_count = 0
         logger.info("开始处理数据")
-        for row_idx, row in enumerate(ws.iter_rows(min_row=2), 2):
+        for row_idx in range(2, ws.max_row + 1):
             try:
-                # 检查行是否有足够的列
-                if len(row) < 2:
-                    logger.warning(f"第{row_idx}行数据列数不足，跳过")
-                    continue
-                    
+                ref_value = ws.cell(row=row_idx, column=ref_col).value
+                pred_value = ws.cell(row=row_idx, column=pred_col).value
+
                 # 检查单元格是否为空
-                if not row[0].value or not row[1].value:
-                    logger.warning(f"第{row_idx}行数据存在空值，跳过")
+                if ref_value is None or str(ref_value).strip() == '':
+                    logger.warning(f"第{row_idx}行标准答案为空，跳过")
                     continue
                     
-                text1 = str(row[0].value).strip()
-                text2 = str(row[1].value).strip()
-                
-                # 检查文本是否为空字符串
-                if not text1 or not text2:
-                    logger.warning(f"第{row_idx}行数据存在空字符串，跳过")
-                    continue
+                text1 = str(ref_value).strip()
+                text2 = '' if pred_value is None else str(pred_value).strip()
                 
                 # 计算相似度
                 similarity = ragas(text1, text2)
                 
                 # 写入结果
-                result_ws.cell(row=row_idx, column=1, value=text1)
-                result_ws.cell(row=row_idx, column=2, value=text2)
-                result_ws.cell(row=row_idx, column=3, value=similarity)
+                ws.cell(row=row_idx, column=score_col, value=similarity)
                 
                 row_count += 1
                 
@@ -222,7 +236,7 @@ def process_excel():
         os.makedirs(app.config['UPLOAD_FOLDER'], exist_ok=True)
         
         # 保存文件
-        result_wb.save(result_filepath)
+        wb.save(result_filepath)
         
         # 返回文件下载链接
         file_url = f'/uploads/{result_filename}'
@@ -239,6 +253,29 @@ def process_excel():
         logger.error(f"处理文件时出错: {str(e)}", exc_info=True)
         return jsonify({'error': f'处理文件时出错: {str(e)}'}), 500
 
+@app.route('/api/similarity', methods=['POST'])
+def batch_similarity():
+    """批量计算文本对的余弦相似度，供Go主服务的评分指标调用"""
+    data = request.get_json(silent=True) or {}
+    references = data.get('references') or []
+    predictions = data.get('predictions') or []
+    if len(references) != len(predictions):
+        return jsonify({'error': 'references 与 predictions 数量不一致'}), 400
+    if not references:
+        return jsonify({'scores': []})
+
+    try:
+        # 批量编码并归一化，点积即余弦相似度
+        ref_embeddings = np.array(model.encode([str(t) for t in references]))
+        pred_embeddings = np.array(model.encode([str(t) for t in predictions]))
+        ref_embeddings = ref_embeddings / np.linalg.norm(ref_embeddings, axis=1, keepdims=True)
+        pred_embeddings = pred_embeddings / np.linalg.norm(pred_embeddings, axis=1, keepdims=True)
+        scores = np.sum(ref_embeddings * pred_embeddings, axis=1)
+        return jsonify({'scores': [float(s) for s in scores]})
+    except Exception as e:
+        logger.error(f"批量计算相似度时出错: {str(e)}", exc_info=True)
+        return jsonify({'error': f'计算相似度时出错: {str(e)}'}), 500
+
 # 添加静态文件路由
 @app.route('/uploads/<path:filename>')
 def download_file(filename):
@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// Status 用户或项目的配额与当前用量
+type Status struct {
+	Scope     string             `json:"scope"`
+	SubjectID int                `json:"subjectId"`
+	Subject   string             `json:"subject"` // 用户名或项目名称
+	Limits    models.QuotaLimits `json:"limits"`
+	Usage     models.QuotaUsage  `json:"usage"`
+}
+
+// QuotaResponse 配额接口的响应结构
+type QuotaResponse struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message,omitempty"`
+	Quotas  []Status `json:"quotas,omitempty"`
+}
+
+func writeQuotaResponse(w http.ResponseWriter, status int, response QuotaResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// StatusFor 查询用户（owner 为用户名）或项目的配额与当前用量
+func StatusFor(scope string, subjectID int, subject, owner string) (Status, error) {
+	status := Status{Scope: scope, SubjectID: subjectID, Subject: subject}
+	var err error
+	if status.Limits, err = LimitsFor(scope, subjectID); err != nil {
+		return status, err
+	}
+	status.Usage, err = UsageFor(scope, subjectID, owner)
+	return status, err
+}
+
+// GetUsage 查询当前用户及其所属项目的配额与用量；带 projectId 参数时只返回该项目，
+// 管理员可用 username 参数查询其他用户
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	user, err := models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+	if err != nil || user == nil {
+		writeQuotaResponse(w, http.StatusInternalServerError, QuotaResponse{Status: "error", Message: "查询用户失败"})
+		return
+	}
+
+	if value := strings.TrimSpace(r.FormValue("projectId")); value != "" {
+		projectID, err := strconv.Atoi(value)
+		var project *models.Project
+		if err == nil && models.CanAccessProject(user.Username, projectID, models.ProjectRoleViewer) {
+			project, err = models.GetProject(projectID)
+		}
+		if err != nil || project == nil {
+			writeQuotaResponse(w, http.StatusNotFound, QuotaResponse{Status: "error", Message: "项目不存在"})
+			return
+		}
+		status, err := StatusFor(models.QuotaScopeProject, project.ID, project.Name, "")
+		if err != nil {
+			writeQuotaResponse(w, http.StatusInternalServerError, QuotaResponse{Status: "error", Message: "查询配额失败"})
+			return
+		}
+		writeQuotaResponse(w, http.StatusOK, QuotaResponse{Status: "success", Quotas: []Status{status}})
+		return
+	}
+
+	target := user
+	if username := strings.TrimSpace(r.FormValue("username")); username != "" && username != user.Username {
+		if user.Role != models.RoleAdmin {
+			writeQuotaResponse(w, http.StatusForbidden, QuotaResponse{Status: "error", Message: "只能查询自己的配额"})
+			return
+		}
+		if target, err = models.GetUserByUsername(username); err != nil || target == nil {
+			writeQuotaResponse(w, http.StatusNotFound, QuotaResponse{Status: "error", Message: "用户不存在"})
+			return
+		}
+	}
+
+	userStatus, err := StatusFor(models.QuotaScopeUser, target.ID, target.Username, target.Username)
+	if err != nil {
+		writeQuotaResponse(w, http.StatusInternalServerError, QuotaResponse{Status: "error", Message: "查询配额失败"})
+		return
+	}
+	statuses := []Status{userStatus}
+	projects, err := models.ListProjects(target.ID, false)
+	if err != nil {
+		writeQuotaResponse(w, http.StatusInternalServerError, QuotaResponse{Status: "error", Message: "查询项目失败"})
+		return
+	}
+	for _, project := range projects {
+		status, err := StatusFor(models.QuotaScopeProject, project.ID, project.Name, "")
+		if err != nil {
+			writeQuotaResponse(w, http.StatusInternalServerError, QuotaResponse{Status: "error", Message: "查询配额失败"})
+			return
+		}
+		statuses = append(statuses, status)
+	}
+	writeQuotaResponse(w, http.StatusOK, QuotaResponse{Status: "success", Quotas: statuses})
+}
@@ -0,0 +1,341 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"fuzhu_2/config"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// ErrExceeded 配额已用完，处理函数据此返回 429
+var ErrExceeded = errors.New("配额已用完")
+
+// HTTPStatus 超出配额时返回 429，其他错误返回 500
+func HTTPStatus(err error) int {
+	if errors.Is(err, ErrExceeded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Defaults 读取默认配额，0 为不限制。用户配额的环境变量为 QUOTA_USER_ROWS（每月行数）、
+// QUOTA_USER_TOKENS（每月 token 数）、QUOTA_USER_JOBS（同时运行的任务数）、QUOTA_USER_STORAGE_MB（存储空间），
+// 项目配额为对应的 QUOTA_PROJECT_*
+func Defaults(scope string) models.QuotaLimits {
+	prefix := "QUOTA_USER_"
+	if scope == models.QuotaScopeProject {
+		prefix = "QUOTA_PROJECT_"
+	}
+	return models.QuotaLimits{
+		Rows:         int64(config.EnvInt(prefix+"ROWS", 0)),
+		Tokens:       int64(config.EnvInt(prefix+"TOKENS", 0)),
+		Jobs:         config.EnvInt(prefix+"JOBS", 0),
+		StorageBytes: int64(config.EnvInt(prefix+"STORAGE_MB", 0)) << 20,
+	}
+}
+
+// LimitsFor 返回用户或项目的配额：管理员单独设置过时使用设置的配额，否则使用默认配额
+func LimitsFor(scope string, subjectID int) (models.QuotaLimits, error) {
+	q, err := models.GetQuota(scope, subjectID)
+	if err != nil {
+		return models.QuotaLimits{}, err
+	}
+	if q != nil {
+		return q.Limits, nil
+	}
+	return Defaults(scope), nil
+}
+
+// UsageFor 统计用户（owner 为用户名）或项目的当前用量
+func UsageFor(scope string, subjectID int, owner string) (models.QuotaUsage, error) {
+	usage := models.QuotaUsage{Period: models.QuotaPeriod(time.Now())}
+	var err error
+	if usage.Rows, usage.Tokens, err = models.GetQuotaUsage(scope, subjectID, usage.Period); err != nil {
+		return usage, err
+	}
+	if scope == models.QuotaScopeProject {
+		usage.Jobs = jobs.ActiveInProject(subjectID)
+		usage.StorageBytes, err = models.StorageUsed("", subjectID)
+	} else {
+		usage.Jobs = jobs.Active(owner)
+		usage.StorageBytes, err = models.StorageUsed(owner, 0)
+	}
+	return usage, err
+}
+
+// subject 一次提交需要检查配额的用户或项目
+type subject struct {
+	scope  string
+	id     int
+	label  string // 提示信息中的名称，如 用户 alice、项目 标注组
+	limits models.QuotaLimits
+	usage  models.QuotaUsage // 提交时的用量
+}
+
+// subjects 返回提交人以及任务所属项目（projectID 大于0时）的配额与用量
+func subjects(owner string, projectID int) ([]*subject, error) {
+	user, err := models.GetUserByUsername(owner)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("用户 %s 不存在", owner)
+	}
+	list := []*subject{{scope: models.QuotaScopeUser, id: user.ID, label: "用户 " + owner}}
+	if projectID > 0 {
+		project, err := models.GetProject(projectID)
+		if err != nil {
+			return nil, err
+		}
+		if project == nil {
+			return nil, fmt.Errorf("项目 %d 不存在", projectID)
+		}
+		list = append(list, &subject{scope: models.QuotaScopeProject, id: projectID, label: "项目 " + project.Name})
+	}
+	for _, s := range list {
+		if s.limits, err = LimitsFor(s.scope, s.id); err != nil {
+			return nil, err
+		}
+		if s.usage, err = UsageFor(s.scope, s.id, owner); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// storageExceeded 检查存储用量加上 size 字节后是否超出配额
+func (s *subject) storageExceeded(size int64) error {
+	if s.limits.StorageBytes > 0 && s.usage.StorageBytes+size > s.limits.StorageBytes {
+		return fmt.Errorf("%w：%s的存储空间已达上限 %d MB，请删除不需要的文件", ErrExceeded, s.label, s.limits.StorageBytes>>20)
+	}
+	return nil
+}
+
+// jobsExceeded 检查未结束的任务数是否已达上限
+func (s *subject) jobsExceeded(active int) error {
+	if s.limits.Jobs > 0 && active >= s.limits.Jobs {
+		return fmt.Errorf("%w：%s同时运行的任务数已达上限 %d，请等待任务结束后再提交", ErrExceeded, s.label, s.limits.Jobs)
+	}
+	return nil
+}
+
+// usageExceeded 检查本月用量加上 rows 行后是否超出配额
+func (s *subject) usageExceeded(rows int64) error {
+	switch {
+	case s.limits.Rows > 0 && s.usage.Rows+rows > s.limits.Rows:
+		return fmt.Errorf("%w：%s本月处理行数将超过上限 %d（已用 %d）", ErrExceeded, s.label, s.limits.Rows, s.usage.Rows)
+	case s.limits.Tokens > 0 && s.usage.Tokens >= s.limits.Tokens:
+		return fmt.Errorf("%w：%s本月大模型 token 已达上限 %d", ErrExceeded, s.label, s.limits.Tokens)
+	}
+	return nil
+}
+
+// reservation 返回计入用量时使用的上限
+func (s *subject) reservation() models.QuotaReservation {
+	return models.QuotaReservation{Scope: s.scope, SubjectID: s.id, MaxRows: s.limits.Rows, MaxTokens: s.limits.Tokens}
+}
+
+// CheckStorage 上传文件或登记数据集前检查存储空间，size 为新文件的字节数
+func CheckStorage(owner string, projectID int, size int64) error {
+	list, err := subjects(owner, projectID)
+	if err != nil {
+		return err
+	}
+	for _, s := range list {
+		if err := s.storageExceeded(size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowReserveChunk 逐行计量时每次在数据库中预留的行数，未用完的部分在 Meter.Finish 时退回
+const rowReserveChunk = 100
+
+// Begin 提交任务前检查提交人和所属项目的配额：存储空间、本月行数与 token 数。通过后返回在任务运行时
+// 计入用量的 Meter，须用 Meter.CreateJob 创建顶层任务，同时运行的任务数在创建时检查；
+// 处理结束后须调用 Meter.Finish
+func Begin(owner string, projectID int) (*Meter, error) {
+	list, err := subjects(owner, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range list {
+		if err := s.storageExceeded(0); err != nil {
+			return nil, err
+		}
+		if err := s.usageExceeded(0); err != nil {
+			return nil, err
+		}
+	}
+	reservations := make([]models.QuotaReservation, len(list))
+	for i, s := range list {
+		reservations[i] = s.reservation()
+	}
+	return &Meter{owner: owner, projectID: projectID, period: models.QuotaPeriod(time.Now()),
+		subjects: list, reservations: reservations}, nil
+}
+
+// Meter 计入一次提交的行数与 token 数。用量直接在数据库中按上限条件累加，并发的任务共用同一份用量；
+// 行数按块预留，减少数据库事务。超出配额或记录用量失败时终止任务（含子任务），正在执行的处理
+// 在下一行前检查到任务已取消后停止。方法可在多个协程中调用，nil 时不计量
+type Meter struct {
+	owner        string
+	projectID    int
+	period       string
+	subjects     []*subject
+	reservations []models.QuotaReservation
+
+	creditMu  sync.Mutex
+	rowCredit int64 // 已预留而尚未使用的行数
+
+	mu    sync.Mutex
+	jobID string
+	err   error
+}
+
+// CreateJob 创建计量的顶层任务，任务属于 Begin 时指定的项目。同时运行的任务数在任务表的同一次加锁中
+// 检查并登记，超出配额时返回错误、不创建任务。超出行数或 token 配额时终止该任务
+func (m *Meter) CreateJob(kind, name string) (*jobs.Job, error) {
+	job, err := jobs.CreateIfAllowed(kind, name, m.owner, m.projectID, func(ownerActive, projectActive int) error {
+		for _, s := range m.subjects {
+			active := ownerActive
+			if s.scope == models.QuotaScopeProject {
+				active = projectActive
+			}
+			if err := s.jobsExceeded(active); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobID = job.ID
+	return job, nil
+}
+
+// AddRows 处理数据行之前计入行数，优先使用已预留的行数，不足时再预留一块。
+// 超出配额或记录用量失败时不计入、终止任务并返回错误
+func (m *Meter) AddRows(n int) error {
+	if m == nil {
+		return nil
+	}
+	if err := m.Err(); err != nil || n <= 0 {
+		return err
+	}
+
+	m.creditMu.Lock()
+	defer m.creditMu.Unlock()
+	need := int64(n) - m.rowCredit
+	if need <= 0 {
+		m.rowCredit -= int64(n)
+		return nil
+	}
+	// 预留整块超出配额时，只预留需要的行数
+	if need < rowReserveChunk {
+		exceeded, err := models.ReserveQuotaUsage(m.period, rowReserveChunk, 0, m.reservations)
+		if err == nil && exceeded < 0 {
+			m.rowCredit += rowReserveChunk - int64(n)
+			return nil
+		}
+	}
+	if err := m.reserve(need, 0); err != nil {
+		return err
+	}
+	m.rowCredit = 0
+	return nil
+}
+
+// Finish 处理结束后退回已预留而未使用的行数
+func (m *Meter) Finish() {
+	if m == nil {
+		return
+	}
+	m.creditMu.Lock()
+	credit := m.rowCredit
+	m.rowCredit = 0
+	m.creditMu.Unlock()
+	if credit > 0 {
+		models.ReleaseQuotaUsage(m.period, credit, m.reservations)
+	}
+}
+
+// AddTokens 大模型调用返回后计入消耗的 token 数。超出配额时终止任务，已处理的行不受影响；
+// 这些 token 已经消耗，仍然计入用量
+func (m *Meter) AddTokens(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	if m.reserve(0, int64(n)) == nil {
+		return
+	}
+	for _, s := range m.subjects {
+		models.AddQuotaUsage(s.scope, s.id, m.period, 0, int64(n))
+	}
+}
+
+// reserve 按上限条件计入用量，超出配额或记录用量失败时终止任务并返回错误
+func (m *Meter) reserve(rows, tokens int64) error {
+	exceeded, err := models.ReserveQuotaUsage(m.period, rows, tokens, m.reservations)
+	if err == nil && exceeded < 0 {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("记录配额用量失败: %v", err)
+	} else {
+		err = m.exceededError(exceeded, rows)
+	}
+	return m.fail(err)
+}
+
+// exceededError 按最新用量生成第 i 个用户或项目超出配额的提示，复制一份避免并发修改
+func (m *Meter) exceededError(i int, rows int64) error {
+	s := *m.subjects[i]
+	if used, usedTokens, err := models.GetQuotaUsage(s.scope, s.id, m.period); err == nil {
+		s.usage.Rows, s.usage.Tokens = used, usedTokens
+	}
+	if err := s.usageExceeded(rows); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w：%s本月大模型 token 已达上限 %d", ErrExceeded, s.label, s.limits.Tokens)
+}
+
+// fail 记录第一个错误并终止任务，返回最先记录的错误
+func (m *Meter) fail(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+		if m.jobID != "" {
+			jobs.Abort(m.jobID, err)
+		}
+	}
+	return m.err
+}
+
+// ProjectID 返回计量的任务所属的项目，个人任务为0
+func (m *Meter) ProjectID() int {
+	if m == nil {
+		return 0
+	}
+	return m.projectID
+}
+
+// Err 返回超出配额的错误，未超出时返回 nil
+func (m *Meter) Err() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
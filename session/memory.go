@@ -0,0 +1,77 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryBackend 内存中的会话存储，重启后会话全部失效，用于测试和单机调试
+type MemoryBackend struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryBackend 创建内存会话存储
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{records: make(map[string]Record)}
+}
+
+func (m *MemoryBackend) Load(id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (m *MemoryBackend) Save(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[rec.ID] = *rec
+	return nil
+}
+
+func (m *MemoryBackend) Touch(id string, lastSeen time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.records[id]; ok {
+		rec.LastSeen = lastSeen
+		m.records[id] = rec
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryBackend) DeleteUser(username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, rec := range m.records {
+		if rec.Username == username {
+			delete(m.records, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryBackend) Cleanup(idleBefore, createdBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, rec := range m.records {
+		if rec.LastSeen.Before(idleBefore) || rec.CreatedAt.Before(createdBefore) {
+			delete(m.records, id)
+			n++
+		}
+	}
+	return n, nil
+}
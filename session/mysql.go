@@ -0,0 +1,64 @@
+package session
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MySQLBackend 保存在 sessions 表中的会话，多个实例可共享，重启后仍然有效
+type MySQLBackend struct {
+	db *sql.DB
+}
+
+// NewMySQLBackend 创建 MySQL 会话存储，sessions 表由 models.InitSchema 创建
+func NewMySQLBackend(db *sql.DB) *MySQLBackend {
+	return &MySQLBackend{db: db}
+}
+
+func (m *MySQLBackend) Load(id string) (*Record, error) {
+	var rec Record
+	err := m.db.QueryRow("SELECT id, username, data, created_at, last_seen_at FROM sessions WHERE id = ?", id).
+		Scan(&rec.ID, &rec.Username, &rec.Data, &rec.CreatedAt, &rec.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (m *MySQLBackend) Save(rec *Record) error {
+	_, err := m.db.Exec(`INSERT INTO sessions (id, username, data, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE username = VALUES(username), data = VALUES(data), last_seen_at = VALUES(last_seen_at)`,
+		rec.ID, rec.Username, rec.Data, rec.CreatedAt, rec.LastSeen)
+	return err
+}
+
+func (m *MySQLBackend) Touch(id string, lastSeen time.Time) error {
+	_, err := m.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", lastSeen, id)
+	return err
+}
+
+func (m *MySQLBackend) Delete(id string) error {
+	_, err := m.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (m *MySQLBackend) DeleteUser(username string) (int, error) {
+	result, err := m.db.Exec("DELETE FROM sessions WHERE username = ?", username)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+func (m *MySQLBackend) Cleanup(idleBefore, createdBefore time.Time) (int, error) {
+	result, err := m.db.Exec("DELETE FROM sessions WHERE last_seen_at < ? OR created_at < ?", idleBefore, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
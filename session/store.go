@@ -0,0 +1,293 @@
+// Package session 服务端会话存储：Cookie 中只保存签名后的随机会话ID，会话内容保存在
+// MySQL（或内存）中，支持空闲超时、绝对超时和强制下线
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"fuzhu_2/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// UserKey 会话中保存登录用户名的键
+const UserKey = "user"
+
+// touchInterval 最近访问时间的更新间隔，避免每个请求都写一次数据库
+const touchInterval = time.Minute
+
+// Record 一条会话记录，ID 为会话ID的 SHA-256
+type Record struct {
+	ID        string
+	Username  string
+	Data      []byte
+	CreatedAt time.Time
+	LastSeen  time.Time
+}
+
+// Backend 会话记录的存储
+type Backend interface {
+	// Load 读取会话，不存在时返回 nil
+	Load(id string) (*Record, error)
+	// Save 新增或覆盖会话
+	Save(rec *Record) error
+	// Touch 更新最近访问时间
+	Touch(id string, lastSeen time.Time) error
+	Delete(id string) error
+	// DeleteUser 删除用户的全部会话，返回删除的个数
+	DeleteUser(username string) (int, error)
+	// Cleanup 删除最近访问早于 idleBefore 或创建早于 createdBefore 的会话
+	Cleanup(idleBefore, createdBefore time.Time) (int, error)
+}
+
+// Store 实现 gin-contrib/sessions 的 Store 接口
+type Store struct {
+	backend     Backend
+	codec       *securecookie.SecureCookie
+	options     sessions.Options
+	idleTimeout time.Duration
+	maxAge      time.Duration
+}
+
+var defaultStore *Store
+
+// Init 按环境变量创建会话存储并设为默认：
+// SESSION_STORE 存储方式 mysql（默认）或 memory；SESSION_SECRET 签名密钥（未设置时随机生成，重启后所有会话失效）；
+// SESSION_IDLE_TIMEOUT 空闲超时（默认2h）；SESSION_MAX_AGE 绝对超时（默认24h）；
+// SESSION_COOKIE_SECURE 仅通过 HTTPS 发送 Cookie（默认 false）；SESSION_COOKIE_SAMESITE 为 lax（默认）、strict 或 none
+func Init() *Store {
+	var backend Backend
+	switch kind := config.EnvString("SESSION_STORE", "mysql"); kind {
+	case "memory":
+		backend = NewMemoryBackend()
+	default:
+		if kind != "mysql" {
+			log.Printf("未知的 SESSION_STORE=%q，使用 mysql", kind)
+		}
+		backend = NewMySQLBackend(config.DB)
+	}
+
+	secret := []byte(config.EnvString("SESSION_SECRET", ""))
+	if len(secret) == 0 {
+		log.Printf("未设置 SESSION_SECRET，使用随机密钥，重启后所有会话将失效")
+		secret = securecookie.GenerateRandomKey(32)
+	} else if len(secret) < 32 {
+		log.Printf("SESSION_SECRET 少于32个字符，建议使用更长的随机密钥")
+	}
+
+	sameSite := http.SameSiteLaxMode
+	switch config.EnvString("SESSION_COOKIE_SAMESITE", "lax") {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	store := NewStore(backend, secret,
+		config.EnvDuration("SESSION_IDLE_TIMEOUT", 2*time.Hour),
+		config.EnvDuration("SESSION_MAX_AGE", 24*time.Hour),
+		sessions.Options{
+			Path:     "/",
+			Secure:   config.EnvBool("SESSION_COOKIE_SECURE", false),
+			HttpOnly: true,
+			SameSite: sameSite,
+		})
+	store.StartCleanup(10 * time.Minute)
+	defaultStore = store
+	return store
+}
+
+// NewStore 创建会话存储，options.MaxAge 由 maxAge 决定
+func NewStore(backend Backend, secret []byte, idleTimeout, maxAge time.Duration, options sessions.Options) *Store {
+	codec := securecookie.New(secret, nil)
+	codec.MaxAge(int(maxAge.Seconds()))
+	options.MaxAge = int(maxAge.Seconds())
+	return &Store{
+		backend:     backend,
+		codec:       codec,
+		options:     options,
+		idleTimeout: idleTimeout,
+		maxAge:      maxAge,
+	}
+}
+
+// Options 设置 Cookie 选项
+func (s *Store) Options(options sessions.Options) {
+	s.options = options
+}
+
+// Get 返回本次请求中已加载的会话，没有时调用 New
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New 按 Cookie 中的会话ID加载会话，Cookie 无效、会话不存在或已超时时返回新会话
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	session.Options = s.options.ToGorillaOptions()
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := s.codec.Decode(name, cookie.Value, &id); err != nil {
+		return session, nil
+	}
+	rec, err := s.backend.Load(hashID(id))
+	if err != nil {
+		log.Printf("读取会话失败: %v", err)
+		return session, err
+	}
+	if rec == nil {
+		return session, nil
+	}
+
+	now := time.Now()
+	if s.expired(rec, now) {
+		s.backend.Delete(rec.ID)
+		return session, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(rec.Data)).Decode(&session.Values); err != nil {
+		log.Printf("解析会话失败: %v", err)
+		return session, nil
+	}
+	if now.Sub(rec.LastSeen) > touchInterval {
+		if err := s.backend.Touch(rec.ID, now); err != nil {
+			log.Printf("更新会话访问时间失败: %v", err)
+		}
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save 保存会话并写入 Cookie。会话为空或 MaxAge < 0 时删除会话；
+// 登录用户变化时（登录、切换账号）更换会话ID，防止会话固定攻击
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(hashID(session.ID)); err != nil {
+				return err
+			}
+		}
+		options := *session.Options
+		options.MaxAge = -1
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &options))
+		session.ID = ""
+		return nil
+	}
+
+	now := time.Now()
+	username, _ := session.Values[UserKey].(string)
+	createdAt := now
+	if session.ID != "" {
+		rec, err := s.backend.Load(hashID(session.ID))
+		if err != nil {
+			return err
+		}
+		if rec != nil && rec.Username == username {
+			createdAt = rec.CreatedAt
+		} else {
+			if rec != nil {
+				s.backend.Delete(rec.ID)
+			}
+			session.ID = ""
+		}
+	}
+	if session.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	err := s.backend.Save(&Record{
+		ID:        hashID(session.ID),
+		Username:  username,
+		Data:      data.Bytes(),
+		CreatedAt: createdAt,
+		LastSeen:  now,
+	})
+	if err != nil {
+		log.Printf("保存会话失败: %v", err)
+		return err
+	}
+
+	encoded, err := s.codec.Encode(session.Name(), session.ID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// RevokeUser 删除用户的全部会话（强制下线）
+func (s *Store) RevokeUser(username string) (int, error) {
+	n, err := s.backend.DeleteUser(username)
+	if err != nil {
+		log.Printf("删除用户 %s 的会话失败: %v", username, err)
+		return 0, err
+	}
+	log.Printf("已删除用户 %s 的 %d 个会话", username, n)
+	return n, nil
+}
+
+// StartCleanup 定期删除已超时的会话
+func (s *Store) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			if n, err := s.backend.Cleanup(now.Add(-s.idleTimeout), now.Add(-s.maxAge)); err != nil {
+				log.Printf("清理过期会话失败: %v", err)
+			} else if n > 0 {
+				log.Printf("已清理 %d 个过期会话", n)
+			}
+		}
+	}()
+}
+
+func (s *Store) expired(rec *Record, now time.Time) bool {
+	return now.Sub(rec.LastSeen) > s.idleTimeout || now.Sub(rec.CreatedAt) > s.maxAge
+}
+
+// RevokeUser 使用默认会话存储删除用户的全部会话，未初始化时不做任何事
+func RevokeUser(username string) (int, error) {
+	if defaultStore == nil {
+		return 0, nil
+	}
+	return defaultStore.RevokeUser(username)
+}
+
+// newID 生成随机会话ID
+func newID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashID 存储中只保存会话ID的 SHA-256，数据库泄露时无法直接冒用会话
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
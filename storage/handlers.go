@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fuzhu_2/audit"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+)
+
+// 文件列表默认和最多返回的条数
+const (
+	defaultListLimit = 100
+	maxListLimit     = 500
+)
+
+// FileResponse 文件接口的响应结构
+type FileResponse struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message,omitempty"`
+	Files   []models.File `json:"files,omitempty"`
+}
+
+func writeFileResponse(w http.ResponseWriter, status int, response FileResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Download 通过签名链接（/files/<ID>?expires=&sig=）下载文件。除签名有效外，
+// 还要求当前用户是文件所有者、管理员或文件所属项目的成员。HTML 报告在浏览器中以沙箱方式打开，其他文件作为附件下载
+func Download(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	query := r.URL.Query()
+	if err := Verify(id, query.Get("expires"), query.Get("sig")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	f, err := models.GetFile(id)
+	if err != nil {
+		http.Error(w, "查询文件失败", http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user, err := models.GetUserByUsername(jobs.OwnerFrom(r.Context()))
+	if err != nil || user == nil || !canDownload(user, f) {
+		http.Error(w, "无权下载该文件", http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "读取文件失败", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, user.Username, models.AuditDownload, f.ID, audit.Details(map[string]interface{}{
+		"name": f.Name, "owner": f.Owner, "jobId": f.JobID,
+	}))
+
+	disposition := "attachment"
+	if strings.EqualFold(filepath.Ext(f.Name), ".html") {
+		disposition = "inline"
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, f.Name, info.ModTime(), file)
+}
+
+// canDownload 文件所有者、管理员和文件所属项目的成员可以下载
+func canDownload(user *models.User, f *models.File) bool {
+	if user.Role == models.RoleAdmin || user.Username == f.Owner {
+		return true
+	}
+	if f.ProjectID == 0 {
+		return false
+	}
+	ok, err := models.HasProjectAccess(user, f.ProjectID, models.ProjectRoleViewer)
+	return err == nil && ok
+}
+
+// ListFiles 列出当前用户的文件并附上新的签名下载链接，参数 projectId 改为列出该项目中的文件，
+// jobId 只列出该任务的文件，limit 最多返回的条数（默认100，最多500）
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	owner := jobs.OwnerFrom(r.Context())
+	projectID := 0
+	if value := strings.TrimSpace(r.URL.Query().Get("projectId")); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || !models.CanAccessProject(owner, id, models.ProjectRoleViewer) {
+			writeFileResponse(w, http.StatusNotFound, FileResponse{Status: "error", Message: "项目不存在"})
+			return
+		}
+		projectID = id
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	files, err := models.ListFiles(owner, projectID, r.URL.Query().Get("jobId"), limit)
+	if err != nil {
+		writeFileResponse(w, http.StatusInternalServerError, FileResponse{Status: "error", Message: "查询文件失败"})
+		return
+	}
+	for i := range files {
+		files[i].URL = URL(&files[i])
+	}
+	writeFileResponse(w, http.StatusOK, FileResponse{Status: "success", Files: files})
+}
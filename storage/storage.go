@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzhu_2/config"
+	"fuzhu_2/jobs"
+	"fuzhu_2/models"
+	"fuzhu_2/utils"
+)
+
+// 文件类型
+const (
+	KindInput   = "input"   // 上传的待处理文件
+	KindResult  = "result"  // 处理或评分的结果
+	KindReport  = "report"  // 评测报告
+	KindArchive = "archive" // 多个结果打包的 zip
+)
+
+// urlPrefix 下载链接的路径前缀，后接文件ID
+const urlPrefix = "/files/"
+
+var (
+	// ErrInvalidLink 下载链接签名错误或已过期
+	ErrInvalidLink = errors.New("下载链接无效或已过期")
+	// ErrNotFound 文件不存在或已被清理
+	ErrNotFound = errors.New("文件不存在")
+)
+
+var (
+	root   = "./uploads"
+	secret []byte
+	urlTTL = 24 * time.Hour
+)
+
+// Init 按环境变量初始化文件存储：STORAGE_DIR 存储目录（默认 ./uploads）；
+// FILE_URL_SECRET 下载链接的签名密钥（未设置时使用 SESSION_SECRET，都未设置时随机生成，重启后链接失效）；
+// FILE_URL_TTL 下载链接有效期（默认24h）
+func Init() error {
+	root = config.EnvString("STORAGE_DIR", "./uploads")
+	urlTTL = config.EnvDuration("FILE_URL_TTL", 24*time.Hour)
+	secret = []byte(config.EnvString("FILE_URL_SECRET", config.EnvString("SESSION_SECRET", "")))
+	if len(secret) == 0 {
+		log.Printf("未设置 FILE_URL_SECRET，使用随机密钥，重启后已发出的下载链接将失效")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(root, 0755)
+}
+
+// newID 生成随机文件ID（32位十六进制）
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// dirName 将用户名、任务ID转换为目录名，空值和 "."、".." 替换为 "_"
+func dirName(name string) string {
+	name = utils.SafeFileName(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// New 为 owner 的任务 jobID 分配一个新文件，name 为下载时使用的文件名。
+// 文件保存在 <存储目录>/<用户>/<任务ID>/<随机ID><扩展名>，不属于任务时任务目录为 "_"。
+// 文件归属于任务所在的项目。写入 f.Path 后须调用 Commit 登记
+func New(owner, jobID, kind, name string) (*models.File, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, dirName(owner), dirName(jobID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f := &models.File{
+		ID:    id,
+		Owner: owner,
+		JobID: jobID,
+		Kind:  kind,
+		Name:  filepath.Base(name),
+		Path:  filepath.Join(dir, id+strings.ToLower(filepath.Ext(name))),
+	}
+	if job, _, ok := jobs.Get(jobID); ok {
+		f.ProjectID = job.ProjectID
+	}
+	return f, nil
+}
+
+// Commit 计算文件大小和哈希并登记到 files 表
+func Commit(f *models.File) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	f.Size = size
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return f.Create()
+}
+
+// SaveUpload 保存上传的文件并登记
+func SaveUpload(owner, jobID, kind string, header *multipart.FileHeader) (*models.File, error) {
+	f, err := New(owner, jobID, kind, header.Filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dst, err := os.Create(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(f.Path)
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+	if err := Commit(f); err != nil {
+		os.Remove(f.Path)
+		return nil, err
+	}
+	return f, nil
+}
+
+// sign 计算文件ID与过期时间的签名
+func sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL 生成文件的签名下载链接，有效期由 FILE_URL_TTL 决定
+func URL(f *models.File) string {
+	return URLForID(f.ID)
+}
+
+// URLForID 按文件ID生成签名下载链接，用于只保存了文件ID的记录
+func URLForID(id string) string {
+	expires := time.Now().Add(urlTTL).Unix()
+	return fmt.Sprintf("%s%s?expires=%d&sig=%s", urlPrefix, id, expires, sign(id, expires))
+}
+
+// Verify 校验下载链接的签名和有效期
+func Verify(id, expires, sig string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return ErrInvalidLink
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(id, exp))) {
+		return ErrInvalidLink
+	}
+	return nil
+}
+
+// StartCleanup 定期删除超过 maxAge 的文件及其记录，运行记录引用的结果文件长期保留
+func StartCleanup(maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			keep, err := models.EvalRunResultPaths()
+			if err != nil {
+				// 不知道哪些文件被引用时不清理，避免删除运行记录的结果文件
+				continue
+			}
+			utils.CleanupUploads(root, maxAge, keep)
+			if n, err := models.DeleteFilesBefore(time.Now().Add(-maxAge)); err == nil && n > 0 {
+				log.Printf("已删除 %d 条过期文件记录", n)
+			}
+		}
+	}()
+	log.Printf("已启动文件清理任务，清理间隔: %v, 文件最大保存时间: %v", interval, maxAge)
+}
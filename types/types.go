@@ -7,6 +7,9 @@ type ChatCompletion struct {
 			Content string `json:"content"` // API返回的文本内容
 		} `json:"message"`
 	} `json:"choices"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"` // 本次调用消耗的 token 数（输入加输出）
+	} `json:"usage"`
 }
 
 // Message 定义聊天消息的数据结构
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// zip 解压的限制：单个文件与所有文件解压后的最大字节数、最多包含的文件数。
+// 解压的文件存放在临时文件中，不占用内存
+const (
+	maxZipEntrySize = 512 << 20
+	maxZipTotalSize = 1 << 30
+	maxZipEntries   = 1000
+)
+
+// BatchPart 批量提交中的一个文件或工作表
+type BatchPart struct {
+	Name     string      // 显示名称：文件名，多工作表时为 文件名/工作表名
+	Filename string      // 原文件名，用于识别格式
+	Sheet    string      // xlsx 的工作表，为空时使用第一个工作表
+	Data     BatchSource // 文件内容，多个工作表共用同一份数据
+	spool    *spoolRef   // zip 中的文件解压到的临时文件
+}
+
+// spooledFile zip 中解压到临时文件的一个文件，由展开出的各部分共用，全部释放后删除
+type spooledFile struct {
+	*os.File
+	refs int32
+}
+
+// spoolRef 一个部分对临时文件的引用，重复释放只计一次
+type spoolRef struct {
+	file *spooledFile
+	once sync.Once
+}
+
+// Release 释放该部分占用的临时文件，zip 中的文件在展开出的所有部分都释放后删除。
+// 可重复调用；不是从 zip 解压的部分无需释放
+func (p BatchPart) Release() {
+	if p.spool == nil {
+		return
+	}
+	p.spool.once.Do(func() {
+		file := p.spool.file
+		if atomic.AddInt32(&file.refs, -1) > 0 {
+			return
+		}
+		file.Close()
+		if err := os.Remove(file.Name()); err != nil {
+			log.Printf("删除临时文件失败: %v", err)
+		}
+	})
+}
+
+// ReleaseBatch 释放所有部分占用的临时文件，处理完一次提交后调用
+func ReleaseBatch(parts []BatchPart) {
+	for _, part := range parts {
+		part.Release()
+	}
+}
+
+// OutputName 返回该部分结果文件的名称（不含扩展名），如 dir_a 或 dir_a_Sheet2
+func (p BatchPart) OutputName() string {
+	name, sheet := p.Name, ""
+	if p.Sheet != "" && strings.HasSuffix(name, "/"+p.Sheet) {
+		name, sheet = strings.TrimSuffix(name, "/"+p.Sheet), "_"+p.Sheet
+	}
+	return SafeFileName(strings.TrimSuffix(name, path.Ext(name)) + sheet)
+}
+
+// BatchSource 上传的文件需要同时支持顺序读取和随机读取（zip 目录位于文件末尾）
+type BatchSource interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// IsZip 判断文件名是否为 zip 压缩包
+func IsZip(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".zip")
+}
+
+// ExpandBatch 将一次提交展开为若干待处理的部分：zip 中每个支持的数据集文件为一部分；
+// allSheets 为 true 时，xlsx 的每个工作表各为一部分。sheet 不为空时只处理该工作表
+func ExpandBatch(src BatchSource, size int64, filename, sheet string, allSheets bool) ([]BatchPart, error) {
+	if !IsZip(filename) {
+		if _, err := DetectFormat(filename); err != nil {
+			return nil, err
+		}
+		return expandSheets(BatchPart{Name: filename, Filename: filename, Sheet: sheet, Data: src}, allSheets)
+	}
+
+	archive, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("读取zip文件失败: %v", err)
+	}
+	if len(archive.File) > maxZipEntries {
+		return nil, fmt.Errorf("zip中的文件数 %d 超过上限 %d", len(archive.File), maxZipEntries)
+	}
+
+	// 解压后的总大小以实际读出的字节计，不信任 zip 目录中声明的大小
+	remaining := int64(maxZipTotalSize)
+	parts := make([]BatchPart, 0, len(archive.File))
+	for _, entry := range archive.File {
+		name := entry.Name
+		base := path.Base(name)
+		// 跳过目录、隐藏文件（如 __MACOSX）和不支持的格式
+		if entry.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+		if _, err := DetectFormat(base); err != nil {
+			continue
+		}
+		if entry.UncompressedSize64 > maxZipEntrySize {
+			return nil, fmt.Errorf("zip中的文件 %s 过大", name)
+		}
+
+		file, n, err := spoolZipEntry(entry, remaining)
+		if err != nil {
+			ReleaseBatch(parts)
+			return nil, err
+		}
+		remaining -= n
+		spool := &spooledFile{File: file}
+		expanded, err := expandSheets(BatchPart{Name: name, Filename: base, Sheet: sheet, Data: file}, allSheets)
+		if err != nil {
+			spool.Close()
+			os.Remove(file.Name())
+			ReleaseBatch(parts)
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		spool.refs = int32(len(expanded))
+		for i := range expanded {
+			expanded[i].spool = &spoolRef{file: spool}
+		}
+		parts = append(parts, expanded...)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("zip中没有可处理的文件，支持: %s", strings.Join(SupportedFormats, ", "))
+	}
+	return parts, nil
+}
+
+// spoolZipEntry 将 zip 中的一个文件解压到临时文件，限制解压后的大小；remaining 为所有文件还可解压的字节数。
+// 返回读取位置在开头的临时文件及其大小，调用方负责删除
+func spoolZipEntry(entry *zip.File, remaining int64) (*os.File, int64, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, 0, fmt.Errorf("解压 %s 失败: %v", entry.Name, err)
+	}
+	defer rc.Close()
+
+	file, err := os.CreateTemp("", "batch-*"+path.Ext(entry.Name))
+	if err != nil {
+		return nil, 0, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	limit := int64(maxZipEntrySize)
+	if remaining < limit {
+		limit = remaining
+	}
+	n, err := io.Copy(file, io.LimitReader(rc, limit+1))
+	if err == nil && n > limit {
+		if limit < maxZipEntrySize {
+			err = fmt.Errorf("zip中的文件解压后总大小超过上限 %d MB", maxZipTotalSize>>20)
+		} else {
+			err = fmt.Errorf("zip中的文件 %s 过大", entry.Name)
+		}
+	} else if err != nil {
+		err = fmt.Errorf("解压 %s 失败: %v", entry.Name, err)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return file, n, nil
+}
+
+// expandSheets 需要时将 xlsx 按工作表展开
+func expandSheets(part BatchPart, allSheets bool) ([]BatchPart, error) {
+	format, _ := DetectFormat(part.Filename)
+	if format != FormatXLSX || !allSheets || part.Sheet != "" {
+		return []BatchPart{part}, nil
+	}
+
+	// 只读取工作簿目录，不解析工作表
+	size, err := part.Data.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(part.Data, size)
+	if err != nil {
+		return nil, fmt.Errorf("读取Excel文件失败: %v", err)
+	}
+	sheets, err := workbookSheets(archive)
+	if err != nil {
+		return nil, fmt.Errorf("读取Excel文件失败: %v", err)
+	}
+
+	if len(sheets) <= 1 {
+		return []BatchPart{part}, nil
+	}
+	parts := make([]BatchPart, len(sheets))
+	for i, sheet := range sheets {
+		parts[i] = part
+		parts[i].Name = part.Name + "/" + sheet.name
+		parts[i].Sheet = sheet.name
+	}
+	return parts, nil
+}
+
+// WriteZip 将若干结果文件打包为 zip，names 为各文件在压缩包中的路径
+func WriteZip(zipPath string, files, names []string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return fmt.Errorf("创建zip文件失败: %v", err)
+	}
+	archive := zip.NewWriter(out)
+
+	used := make(map[string]int)
+	for i, file := range files {
+		name := names[i]
+		// 同名文件加序号区分
+		if n := used[name]; n > 0 {
+			name = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, path.Ext(name)), n, path.Ext(name))
+		}
+		used[names[i]]++
+
+		if err := addZipFile(archive, file, name); err != nil {
+			archive.Close()
+			out.Close()
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("保存zip文件失败: %v", err)
+	}
+	return out.Close()
+}
+
+func addZipFile(archive *zip.Writer, file, name string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("打开结果文件失败: %v", err)
+	}
+	defer in.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("写入zip文件失败: %v", err)
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// SafeFileName 将任意名称转换为可用作文件名的字符串
+func SafeFileName(name string) string {
+	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+	return replacer.Replace(strings.TrimSpace(name))
+}
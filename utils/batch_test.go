@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// zipOf 生成包含 n 个 csv 文件的 zip
+func zipOf(t *testing.T, n int) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		w, err := archive.Create(fmt.Sprintf("data_%d.csv", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "问题,参考答案\n问题%d,答案\n", i)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// TestExpandBatchLimits zip 中的文件数超过上限时拒绝，上限以内正常展开
+func TestExpandBatchLimits(t *testing.T) {
+	src := zipOf(t, 3)
+	parts, err := ExpandBatch(src, src.Size(), "batch.zip", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("展开为 %d 个部分，应为 3", len(parts))
+	}
+
+	src = zipOf(t, maxZipEntries+1)
+	if _, err := ExpandBatch(src, src.Size(), "batch.zip", "", false); err == nil || !strings.Contains(err.Error(), "文件数") {
+		t.Fatalf("文件数超过上限时错误为 %v", err)
+	}
+}
+
+// TestSpoolZipEntryTotalLimit 剩余可解压字节数不足时按总大小超限报错，不留下临时文件
+func TestSpoolZipEntryTotalLimit(t *testing.T) {
+	src := zipOf(t, 1)
+	archive, err := zip.NewReader(src, src.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := spoolZipEntry(archive.File[0], 4); err == nil || !strings.Contains(err.Error(), "总大小") {
+		t.Fatalf("超过总大小时错误为 %v", err)
+	}
+	file, n, err := spoolZipEntry(archive.File[0], maxZipTotalSize)
+	if err != nil || n == 0 {
+		t.Fatalf("解压结果为 %d, %v", n, err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// TestExpandBatchRelease zip 中的文件解压到临时文件，同一文件的各工作表都释放后才删除
+func TestExpandBatchRelease(t *testing.T) {
+	workbook := excelize.NewFile()
+	workbook.SetCellValue("Sheet1", "A1", "问题")
+	workbook.NewSheet("Sheet2")
+	workbook.SetCellValue("Sheet2", "A1", "问题")
+	var xlsx bytes.Buffer
+	if err := workbook.Write(&xlsx); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, _ := archive.Create("book.xlsx")
+	w.Write(xlsx.Bytes())
+	w, _ = archive.Create("data.csv")
+	fmt.Fprint(w, "问题,参考答案\n问题,答案\n")
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := bytes.NewReader(buf.Bytes())
+	parts, err := ExpandBatch(src, src.Size(), "batch.zip", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 || parts[0].Sheet != "Sheet1" || parts[1].Sheet != "Sheet2" {
+		t.Fatalf("展开结果为 %+v", parts)
+	}
+	book := parts[0].Data.(*os.File).Name()
+
+	parts[0].Release()
+	parts[0].Release()
+	if _, err := os.Stat(book); err != nil {
+		t.Fatalf("还有工作表未处理时临时文件已删除: %v", err)
+	}
+	ReleaseBatch(parts)
+	for _, part := range parts {
+		if _, err := os.Stat(part.Data.(*os.File).Name()); !os.IsNotExist(err) {
+			t.Fatalf("%s 的临时文件没有删除", part.Name)
+		}
+	}
+}
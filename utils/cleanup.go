@@ -7,10 +7,12 @@ import (
 	"time"
 )
 
-// CleanupUploads 清理uploads目录中的旧文件
-func CleanupUploads(uploadsDir string, maxAge time.Duration) {
+// CleanupUploads 清理uploads目录（含子目录）中的旧文件，并删除清理后为空的子目录。
+// keep 中的文件（按路径）不论新旧都保留
+func CleanupUploads(uploadsDir string, maxAge time.Duration, keep map[string]bool) {
 	// 获取当前时间
 	now := time.Now()
+	var dirs []string
 
 	// 遍历uploads目录
 	err := filepath.Walk(uploadsDir, func(path string, info os.FileInfo, err error) error {
@@ -18,13 +20,17 @@ func CleanupUploads(uploadsDir string, maxAge time.Duration) {
 			return err
 		}
 
-		// 跳过目录本身
+		// 跳过目录本身，子目录在文件清理后再处理
 		if path == uploadsDir {
 			return nil
 		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
 
 		// 检查文件年龄
-		if now.Sub(info.ModTime()) > maxAge {
+		if now.Sub(info.ModTime()) > maxAge && !keep[path] {
 			// 删除超过指定时间的文件
 			err := os.Remove(path)
 			if err != nil {
@@ -40,23 +46,11 @@ func CleanupUploads(uploadsDir string, maxAge time.Duration) {
 	if err != nil {
 		log.Printf("清理uploads目录时出错: %v", err)
 	}
-}
 
-// StartCleanupScheduler 启动定时清理任务
-func StartCleanupScheduler(uploadsDir string, maxAge time.Duration, interval time.Duration) {
-	// 确保uploads目录存在
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		log.Printf("创建uploads目录失败: %v", err)
-		return
-	}
-
-	// 启动定时器
-	ticker := time.NewTicker(interval)
-	go func() {
-		for range ticker.C {
-			CleanupUploads(uploadsDir, maxAge)
+	// 从最深的目录开始删除空目录
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := os.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			os.Remove(dirs[i])
 		}
-	}()
-
-	log.Printf("已启动文件清理任务，清理间隔: %v, 文件最大保存时间: %v", interval, maxAge)
+	}
 }
@@ -0,0 +1,714 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// 支持的数据集格式
+const (
+	FormatXLSX  = "xlsx"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+)
+
+// SupportedFormats 所有支持的数据集格式
+var SupportedFormats = []string{FormatXLSX, FormatCSV, FormatTSV, FormatJSON, FormatJSONL}
+
+// utf8BOM UTF-8 字节顺序标记，写入CSV/TSV时加上，便于 Excel 正确识别中文
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// xlsxOpenOptions 打开 xlsx 的选项：解压后超过 UnzipXMLSizeLimit 的工作表存到临时文件，
+// 逐行读取时不会整张加载到内存
+var xlsxOpenOptions = excelize.Options{UnzipXMLSizeLimit: excelize.StreamChunkSize}
+
+// DatasetOptions 打开数据集的选项
+type DatasetOptions struct {
+	Sheet    string // xlsx 的工作表名，默认第一个工作表
+	Encoding string // 文本格式的编码：auto（默认，自动识别 UTF-8/GBK）、utf-8、gbk
+}
+
+// DatasetReader 按行读取数据集。与 excelize 的 GetRows 一致，第一行为表头；
+// JSON/JSONL 的第一行为字段名
+type DatasetReader interface {
+	// Format 返回数据集格式
+	Format() string
+	// Sheet 返回正在读取的工作表名，非 xlsx 格式返回空字符串
+	Sheet() string
+	// Next 返回下一行，读完时返回 io.EOF
+	Next() ([]string, error)
+	// Close 释放资源
+	Close() error
+}
+
+// DatasetWriter 按行写入数据集，写入的第一行为表头（JSON/JSONL 中作为字段名）
+type DatasetWriter interface {
+	// WriteRow 写入一行
+	WriteRow(cells []interface{}) error
+	// Close 刷新并关闭文件，xlsx 在此时保存
+	Close() error
+}
+
+// DetectFormat 根据文件扩展名识别数据集格式
+func DetectFormat(filename string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	for _, format := range SupportedFormats {
+		if ext == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("不支持的文件类型 %q，支持: %s", filepath.Ext(filename), strings.Join(SupportedFormats, ", "))
+}
+
+// NormalizeFormat 校验输出格式参数，为空时返回 def
+func NormalizeFormat(format, def string) (string, error) {
+	format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+	if format == "" {
+		return def, nil
+	}
+	return DetectFormat("x." + format)
+}
+
+// OpenDataset 打开数据集文件
+func OpenDataset(path string, opts DatasetOptions) (DatasetReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	reader, err := OpenDatasetReader(file, filepath.Base(path), opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileReader{DatasetReader: reader, file: file}, nil
+}
+
+// OpenDatasetReader 从可定位的读取器（如上传的文件）打开数据集，格式由 filename 的扩展名决定。
+// 调用方负责关闭 src
+func OpenDatasetReader(src io.ReadSeeker, filename string, opts DatasetOptions) (DatasetReader, error) {
+	format, err := DetectFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatXLSX:
+		return newXLSXReader(src, opts.Sheet)
+	case FormatCSV, FormatTSV:
+		text, err := decodeText(src, opts.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		r := csv.NewReader(text)
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+		if format == FormatTSV {
+			r.Comma = '\t'
+		}
+		return &csvReader{format: format, reader: r}, nil
+	case FormatJSON:
+		return newJSONReader(src, opts.Encoding)
+	default:
+		return newJSONLReader(src, opts.Encoding)
+	}
+}
+
+// ReadAllRows 读取数据集的所有行并关闭读取器
+func ReadAllRows(reader DatasetReader) ([][]string, error) {
+	defer reader.Close()
+	rows := make([][]string, 0)
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// CreateDataset 创建数据集文件，sheet 仅对 xlsx 有效（为空时使用 Sheet1）
+func CreateDataset(path, format, sheet string) (DatasetWriter, error) {
+	if format == FormatXLSX {
+		return newXLSXWriter(path, sheet)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建文件失败: %v", err)
+	}
+	buffered := bufio.NewWriter(file)
+
+	switch format {
+	case FormatCSV, FormatTSV:
+		buffered.Write(utf8BOM)
+		w := csv.NewWriter(buffered)
+		if format == FormatTSV {
+			w.Comma = '\t'
+		}
+		return &csvWriter{file: file, buffered: buffered, writer: w}, nil
+	case FormatJSON, FormatJSONL:
+		return &jsonWriter{file: file, buffered: buffered, lines: format == FormatJSONL}, nil
+	default:
+		file.Close()
+		return nil, fmt.Errorf("不支持的输出格式 %q", format)
+	}
+}
+
+// ReplaceExt 将文件名的扩展名替换为指定格式
+func ReplaceExt(filename, format string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "." + format
+}
+
+// fileReader 在关闭读取器时一并关闭底层文件
+type fileReader struct {
+	DatasetReader
+	file *os.File
+}
+
+func (r *fileReader) Close() error {
+	err := r.DatasetReader.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decodeText 识别文本编码并返回 UTF-8 读取器。自动模式下，开头的内容是合法 UTF-8 时按 UTF-8
+// 读取（去掉BOM），否则按 GBK（GB18030）解码
+func decodeText(src io.Reader, encoding string) (io.Reader, error) {
+	buffered := bufio.NewReaderSize(src, 64*1024)
+	sample, err := buffered.Peek(64 * 1024)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gbk", "gb2312", "gb18030":
+		return transform.NewReader(buffered, simplifiedchinese.GB18030.NewDecoder()), nil
+	case "utf-8", "utf8":
+	case "", "auto":
+		if !bytes.HasPrefix(sample, utf8BOM) && !validUTF8Prefix(sample) {
+			return transform.NewReader(buffered, simplifiedchinese.GB18030.NewDecoder()), nil
+		}
+	default:
+		return nil, fmt.Errorf("不支持的编码 %q，支持: auto、utf-8、gbk", encoding)
+	}
+
+	if bytes.HasPrefix(sample, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	return buffered, nil
+}
+
+// validUTF8Prefix 判断样本是否为合法 UTF-8，允许末尾被截断的不完整字符
+func validUTF8Prefix(sample []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			return true
+		}
+		sample = sample[:len(sample)-1]
+	}
+	return utf8.Valid(sample)
+}
+
+// formatCell 将单元格的值转换为文本
+func formatCell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// xlsxReader 使用 excelize 的行迭代器逐行读取 xlsx 工作表，不会一次性加载整张表
+type xlsxReader struct {
+	file  *excelize.File
+	sheet string
+	rows  *excelize.Rows
+}
+
+func newXLSXReader(src io.Reader, sheet string) (*xlsxReader, error) {
+	file, err := excelize.OpenReader(src, xlsxOpenOptions)
+	if err != nil {
+		return nil, fmt.Errorf("读取Excel文件失败: %v", err)
+	}
+
+	sheet, err = SelectSheet(file, sheet)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("读取工作表失败: %v", err)
+	}
+	return &xlsxReader{file: file, sheet: sheet, rows: rows}, nil
+}
+
+// SelectSheet 返回要处理的工作表名，未指定时使用第一个工作表
+func SelectSheet(f *excelize.File, name string) (string, error) {
+	sheets := f.GetSheetList()
+	index, err := selectSheetIndex(sheets, name)
+	if err != nil {
+		return "", err
+	}
+	return sheets[index], nil
+}
+
+// selectSheetIndex 返回要处理的工作表在 sheets 中的序号，未指定时使用第一个工作表
+func selectSheetIndex(sheets []string, name string) (int, error) {
+	if name == "" {
+		if len(sheets) == 0 {
+			return 0, fmt.Errorf("工作簿中没有工作表")
+		}
+		return 0, nil
+	}
+	for i, sheet := range sheets {
+		if sheet == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("工作表 %q 不存在，可用的工作表: %s", name, strings.Join(sheets, ", "))
+}
+
+func (r *xlsxReader) Format() string { return FormatXLSX }
+func (r *xlsxReader) Sheet() string  { return r.sheet }
+func (r *xlsxReader) Close() error {
+	r.rows.Close()
+	return r.file.Close()
+}
+
+func (r *xlsxReader) Next() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Error(); err != nil {
+			return nil, fmt.Errorf("读取工作表失败: %v", err)
+		}
+		return nil, io.EOF
+	}
+	row, err := r.rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("读取工作表失败: %v", err)
+	}
+	return row, nil
+}
+
+// csvReader 读取 CSV/TSV
+type csvReader struct {
+	format string
+	reader *csv.Reader
+}
+
+func (r *csvReader) Format() string { return r.format }
+func (r *csvReader) Sheet() string  { return "" }
+func (r *csvReader) Close() error   { return nil }
+
+func (r *csvReader) Next() ([]string, error) {
+	row, err := r.reader.Read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("解析%s失败: %v", strings.ToUpper(r.format), err)
+	}
+	return row, err
+}
+
+// jsonObject 保留字段顺序的JSON对象
+type jsonObject struct {
+	keys   []string
+	values map[string]string
+}
+
+// decodeJSONObject 解析一个JSON对象，保留字段顺序，值统一转换为文本
+func decodeJSONObject(dec *json.Decoder) (*jsonObject, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("每条记录必须是JSON对象")
+	}
+
+	obj := &jsonObject{values: make(map[string]string)}
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := keyTok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if _, exists := obj.values[key]; !exists {
+			obj.keys = append(obj.keys, key)
+		}
+		obj.values[key] = jsonValueText(raw)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// jsonValueText 将JSON值转换为文本：字符串取原值，null 为空，其余保留JSON文本
+func jsonValueText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	text := strings.TrimSpace(string(raw))
+	if text == "null" {
+		return ""
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, raw); err == nil {
+		return compacted.String()
+	}
+	return text
+}
+
+// keyIndex 维护字段名及其出现顺序
+type keyIndex struct {
+	keys []string
+	seen map[string]bool
+}
+
+func (k *keyIndex) add(keys []string) {
+	if k.seen == nil {
+		k.seen = make(map[string]bool)
+	}
+	for _, key := range keys {
+		if !k.seen[key] {
+			k.seen[key] = true
+			k.keys = append(k.keys, key)
+		}
+	}
+}
+
+func (k *keyIndex) row(obj *jsonObject) []string {
+	row := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		row[i] = obj.values[key]
+	}
+	return row
+}
+
+// jsonReader 流式读取JSON对象数组。与 JSONL 相同，先扫描一遍收集所有字段名，再从头逐条读取
+type jsonReader struct {
+	index      keyIndex
+	dec        *json.Decoder
+	count      int
+	headerDone bool
+}
+
+func newJSONReader(src io.ReadSeeker, encoding string) (*jsonReader, error) {
+	r := &jsonReader{}
+	dec, err := newJSONArrayDecoder(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for dec.More() {
+		count++
+		obj, err := decodeJSONObject(dec)
+		if err != nil {
+			return nil, fmt.Errorf("解析第%d条记录失败: %v", count, err)
+		}
+		r.index.add(obj.keys)
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取JSON失败: %v", err)
+	}
+	if r.dec, err = newJSONArrayDecoder(src, encoding); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newJSONArrayDecoder 创建解码器并读取数组的起始符号
+func newJSONArrayDecoder(src io.Reader, encoding string) (*json.Decoder, error) {
+	text, err := decodeText(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(text)
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("JSON文件必须是对象数组")
+	}
+	return dec, nil
+}
+
+func (r *jsonReader) Format() string { return FormatJSON }
+func (r *jsonReader) Sheet() string  { return "" }
+func (r *jsonReader) Close() error   { return nil }
+
+func (r *jsonReader) Next() ([]string, error) {
+	if !r.headerDone {
+		r.headerDone = true
+		return append([]string(nil), r.index.keys...), nil
+	}
+	if !r.dec.More() {
+		return nil, io.EOF
+	}
+	r.count++
+	obj, err := decodeJSONObject(r.dec)
+	if err != nil {
+		return nil, fmt.Errorf("解析第%d条记录失败: %v", r.count, err)
+	}
+	return r.index.row(obj), nil
+}
+
+// jsonlReader 逐行读取JSONL。先扫描一遍收集所有字段名，再从头流式读取
+type jsonlReader struct {
+	index      keyIndex
+	scanner    *bufio.Scanner
+	line       int
+	headerDone bool
+}
+
+func newJSONLReader(src io.ReadSeeker, encoding string) (*jsonlReader, error) {
+	r := &jsonlReader{}
+	scanner, err := newJSONLScanner(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	line := 0
+	for scanner.Scan() {
+		line++
+		obj, err := parseJSONLLine(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("解析第%d行失败: %v", line, err)
+		}
+		if obj != nil {
+			r.index.add(obj.keys)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取JSONL失败: %v", err)
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取JSONL失败: %v", err)
+	}
+	if r.scanner, err = newJSONLScanner(src, encoding); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newJSONLScanner 创建按行读取的扫描器，单行最长 64MB
+func newJSONLScanner(src io.Reader, encoding string) (*bufio.Scanner, error) {
+	text, err := decodeText(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(text)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	return scanner, nil
+}
+
+// parseJSONLLine 解析一行JSONL，空行返回 nil
+func parseJSONLLine(line []byte) (*jsonObject, error) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	return decodeJSONObject(dec)
+}
+
+func (r *jsonlReader) Format() string { return FormatJSONL }
+func (r *jsonlReader) Sheet() string  { return "" }
+func (r *jsonlReader) Close() error   { return nil }
+
+func (r *jsonlReader) Next() ([]string, error) {
+	if !r.headerDone {
+		r.headerDone = true
+		return append([]string(nil), r.index.keys...), nil
+	}
+	for r.scanner.Scan() {
+		r.line++
+		obj, err := parseJSONLLine(r.scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("解析第%d行失败: %v", r.line, err)
+		}
+		if obj != nil {
+			return r.index.row(obj), nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取JSONL失败: %v", err)
+	}
+	return nil, io.EOF
+}
+
+// xlsxWriter 使用 StreamWriter 逐行写入 xlsx 工作表，行数据按需刷到临时文件
+type xlsxWriter struct {
+	path   string
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXWriter(path, sheet string) (*xlsxWriter, error) {
+	file := excelize.NewFile()
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("设置工作表名失败: %v", err)
+	}
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("创建工作表写入器失败: %v", err)
+	}
+	return &xlsxWriter{path: path, file: file, stream: stream}, nil
+}
+
+func (w *xlsxWriter) WriteRow(cells []interface{}) error {
+	w.row++
+	cell, _ := excelize.CoordinatesToCellName(1, w.row)
+	return w.stream.SetRow(cell, cells)
+}
+
+func (w *xlsxWriter) Close() error {
+	defer w.file.Close()
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+	return w.file.SaveAs(w.path)
+}
+
+// csvWriter 写入 CSV/TSV
+type csvWriter struct {
+	file     *os.File
+	buffered *bufio.Writer
+	writer   *csv.Writer
+}
+
+func (w *csvWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = formatCell(cell)
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	err := w.writer.Error()
+	if ferr := w.buffered.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// jsonWriter 写入JSON对象数组或JSONL，第一行作为字段名
+type jsonWriter struct {
+	file     *os.File
+	buffered *bufio.Writer
+	lines    bool
+	keys     []string
+	count    int
+}
+
+func (w *jsonWriter) WriteRow(cells []interface{}) error {
+	if w.keys == nil {
+		w.keys = make([]string, len(cells))
+		for i, cell := range cells {
+			w.keys[i] = formatCell(cell)
+		}
+		if !w.lines {
+			_, err := w.buffered.WriteString("[")
+			return err
+		}
+		return nil
+	}
+
+	// 按字段顺序输出对象，超出表头的单元格以 column_N 命名
+	var obj bytes.Buffer
+	obj.WriteByte('{')
+	for i, cell := range cells {
+		key := fmt.Sprintf("column_%d", i+1)
+		if i < len(w.keys) && w.keys[i] != "" {
+			key = w.keys[i]
+		}
+		keyJSON, _ := json.Marshal(key)
+		valueJSON, err := json.Marshal(cell)
+		if err != nil {
+			valueJSON, _ = json.Marshal(formatCell(cell))
+		}
+		if i > 0 {
+			obj.WriteByte(',')
+		}
+		obj.Write(keyJSON)
+		obj.WriteByte(':')
+		obj.Write(valueJSON)
+	}
+	obj.WriteByte('}')
+
+	if w.lines {
+		obj.WriteByte('\n')
+	} else if w.count > 0 {
+		w.buffered.WriteString(",\n")
+	} else {
+		w.buffered.WriteString("\n")
+	}
+	w.count++
+	_, err := w.buffered.Write(obj.Bytes())
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	var err error
+	if !w.lines {
+		if w.keys == nil {
+			w.buffered.WriteString("[")
+		}
+		_, err = w.buffered.WriteString("\n]\n")
+	}
+	if ferr := w.buffered.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// writeTestWorkbook 生成 rows 行数据（另有一行表头）的 xlsx 文件
+func writeTestWorkbook(t *testing.T, path string, rows int) {
+	t.Helper()
+	writer, err := CreateDataset(path, FormatXLSX, "数据")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteRow([]interface{}{"编号", "问题", "参考答案"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		err := writer.WriteRow([]interface{}{i + 1, fmt.Sprintf("第%d个问题的内容", i+1), "这是一段用于测试的参考答案文本"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// peakHeap 执行 fn，返回执行期间存活堆内存相对开始时的最大增量。
+// 采样前强制 GC，只统计仍被引用的内存，不受垃圾回收时机影响
+func peakHeap(fn func() error) (uint64, error) {
+	sample := func() uint64 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+	base := sample()
+
+	var peak uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if heap := sample(); heap > base && heap-base > peak {
+				peak = heap - base
+			}
+		}
+	}()
+
+	err := fn()
+	close(done)
+	wg.Wait()
+	return peak, err
+}
+
+// TestStreamingMemoryFlat 行数增加到3倍时，逐行读写 xlsx 与为工作簿追加输出列的内存峰值不应随之增长
+func TestStreamingMemoryFlat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("生成大文件较慢，-short 时跳过")
+	}
+	// 调低解压到内存的上限，使较小的测试文件也走临时文件
+	saved := xlsxOpenOptions
+	xlsxOpenOptions.UnzipXMLSizeLimit = 1 << 20
+	defer func() { xlsxOpenOptions = saved }()
+
+	// StreamWriter 在内存中缓冲 16MB 后才写入临时文件，两种行数都超过这个量，比较的是缓冲之外的内存
+	dir := t.TempDir()
+	sizes := []int{100000, 300000}
+	inputs := make([]string, len(sizes))
+	for i, rows := range sizes {
+		inputs[i] = filepath.Join(dir, fmt.Sprintf("input_%d.xlsx", rows))
+		writeTestWorkbook(t, inputs[i], rows)
+	}
+
+	cases := []struct {
+		name string
+		run  func(input, output string) error
+	}{
+		{"dataset", copyDataset},
+		{"workbook", appendWorkbookColumn},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			peaks := make([]uint64, len(sizes))
+			for i, rows := range sizes {
+				output := filepath.Join(dir, fmt.Sprintf("%s_%d.xlsx", c.name, rows))
+				peak, err := peakHeap(func() error { return c.run(inputs[i], output) })
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("%d 行: 内存峰值 %.1f MB", rows, float64(peak)/(1<<20))
+				peaks[i] = peak
+			}
+			if peaks[1] > peaks[0]*3/2 {
+				t.Fatalf("行数增加到3倍后内存峰值从 %d 增长到 %d 字节", peaks[0], peaks[1])
+			}
+		})
+	}
+}
+
+// copyDataset 逐行读取 xlsx 并写入新的 xlsx，与评分接口读取数据集、输出结果的方式相同
+func copyDataset(input, output string) error {
+	reader, err := OpenDataset(input, DatasetOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := CreateDataset(output, FormatXLSX, reader.Sheet())
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		cells := make([]interface{}, len(row)+1)
+		for i, cell := range row {
+			cells[i] = cell
+		}
+		cells[len(row)] = 0.5
+		if err := writer.WriteRow(cells); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// appendWorkbookColumn 为工作簿追加输出列，与大模型批量处理保存结果的方式相同。
+// 处理结果本身由调用方保存在内存中，这里只写入少量结果以便观察工作表本身占用的内存
+func appendWorkbookColumn(input, output string) error {
+	handler, err := NewExcelHandler(input, "", true)
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+	if err := handler.WriteHeader("模型输出"); err != nil {
+		return err
+	}
+	for rowIndex := 1; rowIndex <= 1000; rowIndex++ {
+		if err := handler.WriteResult(rowIndex, "输出"); err != nil {
+			return err
+		}
+	}
+	return handler.SaveOutput(output)
+}
+
+// TestExcelHandlerSaveOutput 输出列追加在所选工作表最后一列之后，其他工作表、工作表顺序与单元格样式保持不变
+func TestExcelHandlerSaveOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.xlsx")
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", "说明")
+	f.SetCellValue("说明", "A1", "保留")
+	f.NewSheet("数据")
+	f.SetSheetRow("数据", "A1", &[]interface{}{"编号", "问题", "参考答案"})
+	for i := 1; i <= 3; i++ {
+		f.SetSheetRow("数据", fmt.Sprintf("A%d", i+1), &[]interface{}{i, fmt.Sprintf("第%d个问题的内容", i), "这是一段用于测试的参考答案 <&>"})
+	}
+	style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetCellStyle("数据", "A1", "C1", style)
+	if err := f.SaveAs(input); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	handler, err := NewExcelHandler(input, "数据", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.WriteHeader("模型输出"); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.WriteResult(2, "第二行输出 <&>"); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.xlsx")
+	if err := handler.SaveOutput(output); err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+
+	result, err := excelize.OpenFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	if sheets := result.GetSheetList(); len(sheets) != 2 || sheets[0] != "说明" || sheets[1] != "数据" {
+		t.Fatalf("工作表为 %v，应为 [说明 数据]", sheets)
+	}
+	if value, _ := result.GetCellValue("说明", "A1"); value != "保留" {
+		t.Fatalf("其他工作表的内容为 %q", value)
+	}
+	if got, _ := result.GetCellStyle("数据", "B1"); got != style {
+		t.Fatalf("单元格样式为 %d，应为 %d", got, style)
+	}
+	rows, err := result.GetRows("数据")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"编号", "问题", "参考答案", "模型输出"},
+		{"1", "第1个问题的内容", "这是一段用于测试的参考答案 <&>"},
+		{"2", "第2个问题的内容", "这是一段用于测试的参考答案 <&>", "第二行输出 <&>"},
+		{"3", "第3个问题的内容", "这是一段用于测试的参考答案 <&>"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Fatalf("输出为 %v，应为 %v", rows, want)
+	}
+}
+
+// TestAppendSheetColumn 自闭合的行补上单元格，带命名空间前缀的行使用相同前缀，没有结果的行原样保留
+func TestAppendSheetColumn(t *testing.T) {
+	input := `<x:worksheet xmlns:x="main"><x:dimension ref="A1:B3"/><x:sheetData>` +
+		`<x:row r="1"><x:c r="A1"><x:v>1</x:v></x:c></x:row><x:row r="2"/><x:row r="3" spans="1:2"></x:row>` +
+		`</x:sheetData></x:worksheet>`
+	want := `<x:worksheet xmlns:x="main"><x:dimension ref="A1:C3"/><x:sheetData>` +
+		`<x:row r="1"><x:c r="A1"><x:v>1</x:v></x:c><x:c r="C1" t="inlineStr"><x:is><x:t xml:space="preserve">a&lt;b</x:t></x:is></x:c></x:row>` +
+		`<x:row r="2"><x:c r="C2" t="inlineStr"><x:is><x:t xml:space="preserve">二</x:t></x:is></x:c></x:row><x:row r="3" spans="1:2"></x:row>` +
+		`</x:sheetData></x:worksheet>`
+	var out bytes.Buffer
+	if err := appendSheetColumn(&out, strings.NewReader(input), 3, map[int]string{0: "a<b", 1: "二"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Fatalf("输出为\n%s\n应为\n%s", out.String(), want)
+	}
+}
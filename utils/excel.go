@@ -1,50 +1,440 @@
 package utils
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/xuri/excelize/v2"
 )
 
-// ExcelHandler 处理Excel文件的结构体
+// ExcelHandler 处理Excel文件的结构体。输出文件是输入工作簿的副本，其他工作表、样式和
+// 原有单元格都保持不变，处理结果追加在所选工作表最后一列之后。读取与保存都直接处理
+// xlsx 压缩包：所选工作表的XML逐个节点读取、改写，其他文件原样复制，内存占用与行数无关
 type ExcelHandler struct {
-	InputFile  *excelize.File
-	OutputFile *excelize.File
+	Sheet     string // 处理的工作表
+	HeaderRow bool   // 第一行是否为表头
+	outputCol int    // 输出列（从1开始）
+	outputs   map[int]string
+	archive   *zip.Reader
+	sheetPath string    // 所选工作表在压缩包中的路径
+	closer    io.Closer // NewExcelHandler 打开的文件，Close 时关闭
 }
 
-// NewExcelHandler 创建新的Excel处理器
-func NewExcelHandler(inputPath string) (*ExcelHandler, error) {
-	inputFile, err := excelize.OpenFile(inputPath)
+// NewExcelHandler 创建新的Excel处理器，sheet 为空时使用第一个工作表
+func NewExcelHandler(inputPath, sheet string, headerRow bool) (*ExcelHandler, error) {
+	input, err := os.Open(inputPath)
 	if err != nil {
 		return nil, fmt.Errorf("打开Excel文件失败: %v", err)
 	}
+	h, err := NewExcelHandlerFromReader(input, sheet, headerRow)
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+	h.closer = input
+	return h, nil
+}
 
+// NewExcelHandlerFromReader 从可定位的读取器（如 zip 中解压的文件）创建Excel处理器，
+// 调用 SaveOutput 之前不能关闭 input
+func NewExcelHandlerFromReader(input io.ReadSeeker, sheet string, headerRow bool) (*ExcelHandler, error) {
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	readerAt, ok := input.(io.ReaderAt)
+	if !ok {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		readerAt = bytes.NewReader(data)
+	}
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, fmt.Errorf("打开Excel文件失败: %v", err)
+	}
+
+	sheets, err := workbookSheets(archive)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(sheets))
+	for i, s := range sheets {
+		names[i] = s.name
+	}
+	index, err := selectSheetIndex(names, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	// 输出列放在所有行的最大列数之后
+	width, err := sheetWidth(archive, sheets[index].path)
+	if err != nil {
+		return nil, fmt.Errorf("读取工作表失败: %v", err)
+	}
 	return &ExcelHandler{
-		InputFile:  inputFile,
-		OutputFile: excelize.NewFile(),
+		Sheet:     sheets[index].name,
+		HeaderRow: headerRow,
+		outputCol: width + 1,
+		outputs:   make(map[int]string),
+		archive:   archive,
+		sheetPath: sheets[index].path,
 	}, nil
 }
 
-// GetRows 获取工作表中的所有行
-func (h *ExcelHandler) GetRows() ([][]string, error) {
-	return h.InputFile.GetRows("Sheet1")
+// SaveOutput 保存处理结果到输出文件：复制输入工作簿，在所选工作表有结果的行末尾追加输出列
+func (h *ExcelHandler) SaveOutput(outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	writer := zip.NewWriter(out)
+	err = h.copyWorkbook(writer)
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyWorkbook 原样复制工作簿中的文件，所选工作表追加输出列后写入
+func (h *ExcelHandler) copyWorkbook(writer *zip.Writer) error {
+	for _, entry := range h.archive.File {
+		if entry.Name != h.sheetPath {
+			if err := writer.Copy(entry); err != nil {
+				return fmt.Errorf("复制 %s 失败: %v", entry.Name, err)
+			}
+			continue
+		}
+
+		src, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("读取工作表失败: %v", err)
+		}
+		dst, err := writer.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: zip.Deflate, Modified: entry.Modified})
+		if err != nil {
+			src.Close()
+			return err
+		}
+		buffered := bufio.NewWriter(dst)
+		err = appendSheetColumn(buffered, src, h.outputCol, h.outputs)
+		src.Close()
+		if err == nil {
+			err = buffered.Flush()
+		}
+		if err != nil {
+			return fmt.Errorf("写入工作表失败: %v", err)
+		}
+	}
+	return nil
 }
 
-// SaveOutput 保存处理结果到输出文件
-func (h *ExcelHandler) SaveOutput(outputPath string) error {
-	return h.OutputFile.SaveAs(outputPath)
+// WriteHeader 有表头时，在输出列的表头写入列名
+func (h *ExcelHandler) WriteHeader(title string) error {
+	if !h.HeaderRow {
+		return nil
+	}
+	return h.WriteResult(0, title)
 }
 
-// WriteResult 写入处理结果到输出文件
-func (h *ExcelHandler) WriteResult(rowIndex int, input, output string) {
-	h.OutputFile.SetCellValue("Sheet1", fmt.Sprintf("A%d", rowIndex+1), input)
-	h.OutputFile.SetCellValue("Sheet1", fmt.Sprintf("B%d", rowIndex+1), output)
+// WriteResult 记录输出列的处理结果，rowIndex 从0开始，调用 SaveOutput 时写入
+func (h *ExcelHandler) WriteResult(rowIndex int, output string) error {
+	if rowIndex < 0 {
+		return fmt.Errorf("无效的行号 %d", rowIndex)
+	}
+	h.outputs[rowIndex] = output
+	return nil
 }
 
 // Close 关闭Excel文件
 func (h *ExcelHandler) Close() {
-	if err := h.InputFile.Close(); err != nil {
+	if h.closer == nil {
+		return
+	}
+	if err := h.closer.Close(); err != nil {
 		log.Printf("关闭输入文件失败: %v", err)
 	}
 }
+
+// workbookSheet 工作簿中的工作表
+type workbookSheet struct {
+	name string
+	path string // 在压缩包中的路径
+}
+
+// workbookSheets 根据 xl/workbook.xml 及其关系文件，按顺序返回工作表名称和路径
+func workbookSheets(archive *zip.Reader) ([]workbookSheet, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(archive, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if err := decodeZipXML(archive, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	sheets := make([]workbookSheet, 0, len(workbook.Sheets))
+	for _, s := range workbook.Sheets {
+		// 关系ID为带命名空间的 r:id 属性
+		for _, attr := range s.Attrs {
+			if target, ok := targets[attr.Value]; ok && attr.Name.Local == "id" && attr.Name.Space != "" {
+				sheets = append(sheets, workbookSheet{name: s.Name, path: target})
+				break
+			}
+		}
+	}
+	return sheets, nil
+}
+
+// sheetWidth 逐个读取工作表XML的节点，返回各行的最大列数
+func sheetWidth(archive *zip.Reader, sheetPath string) (int, error) {
+	file, err := archive.Open(sheetPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(bufio.NewReader(file))
+	width, col := 0, 0
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return width, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		t, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch t.Name.Local {
+		case "row":
+			col = 0
+		case "c":
+			col++
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "r" {
+					if n, _, err := excelize.CellNameToCoordinates(attr.Value); err == nil {
+						col = n
+					}
+				}
+			}
+			if col > width {
+				width = col
+			}
+		}
+	}
+}
+
+// decodeZipXML 解析压缩包中的XML文件
+func decodeZipXML(archive *zip.Reader, name string, v interface{}) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %v", name, err)
+	}
+	defer file.Close()
+	if err := xml.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", name, err)
+	}
+	return nil
+}
+
+// recordingReader 记录XML解码器读过且尚未写出的字节，便于按偏移量原样写出
+type recordingReader struct {
+	r    *bufio.Reader
+	buf  []byte // 从偏移量 base 开始已读取的字节
+	base int64
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+func (r *recordingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
+
+// span 返回偏移量 [from, to) 的原始字节
+func (r *recordingReader) span(from, to int64) []byte {
+	return r.buf[from-r.base : to-r.base]
+}
+
+// discard 丢弃偏移量 offset 之前的字节
+func (r *recordingReader) discard(offset int64) {
+	n := copy(r.buf, r.buf[offset-r.base:])
+	r.buf = r.buf[:n]
+	r.base = offset
+}
+
+// appendSheetColumn 逐个读取工作表XML的节点并原样写出，在有结果的行末尾追加第 col 列的单元格，
+// 并把 dimension 的范围扩大到该列。values 的键为从0开始的行号
+func appendSheetColumn(dst io.Writer, src io.Reader, col int, values map[int]string) error {
+	colName, err := excelize.ColumnNumberToName(col)
+	if err != nil {
+		return err
+	}
+	reader := &recordingReader{r: bufio.NewReader(src)}
+	decoder := xml.NewDecoder(reader)
+
+	var written int64 // 已写出的偏移量
+	flush := func(to int64) error {
+		_, err := dst.Write(reader.span(written, to))
+		written = to
+		return err
+	}
+
+	rowNum := 0
+	skipEnd := false // 自闭合的行已补上结束标签
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		end := decoder.InputOffset()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "dimension":
+				if err := flush(start); err != nil {
+					return err
+				}
+				if _, err := dst.Write(dimensionTag(reader.span(start, end), t, col)); err != nil {
+					return err
+				}
+				written = end
+			case "row":
+				rowNum++
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "r" {
+						if n, err := strconv.Atoi(attr.Value); err == nil {
+							rowNum = n
+						}
+					}
+				}
+				raw := reader.span(start, end)
+				value, ok := values[rowNum-1]
+				if !ok || !bytes.HasSuffix(raw, []byte("/>")) {
+					break
+				}
+				// 自闭合的空行：补上单元格和结束标签
+				if err := flush(start); err != nil {
+					return err
+				}
+				tag := bytes.TrimSpace(bytes.TrimSuffix(raw, []byte("/>")))
+				if _, err := fmt.Fprintf(dst, "%s>%s</%s>", tag, inlineStrCell(t.Name.Space, colName, rowNum, value), qualifiedName(t.Name)); err != nil {
+					return err
+				}
+				written = end
+				skipEnd = true
+			}
+		case xml.EndElement:
+			if t.Name.Local != "row" {
+				break
+			}
+			if skipEnd {
+				skipEnd = false
+				break
+			}
+			if value, ok := values[rowNum-1]; ok {
+				if err := flush(start); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(dst, inlineStrCell(t.Name.Space, colName, rowNum, value)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if written < end {
+			if err := flush(end); err != nil {
+				return err
+			}
+		}
+		reader.discard(written)
+	}
+	return flush(reader.base + int64(len(reader.buf)))
+}
+
+// qualifiedName 返回带前缀的元素名，如 x:row
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// inlineStrCell 返回内联字符串单元格的XML，prefix 为行元素的命名空间前缀
+func inlineStrCell(prefix, colName string, rowNum int, value string) string {
+	if prefix != "" {
+		prefix += ":"
+	}
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return fmt.Sprintf(`<%sc r="%s%d" t="inlineStr"><%sis><%st xml:space="preserve">%s</%st></%sis></%sc>`,
+		prefix, colName, rowNum, prefix, prefix, escaped.String(), prefix, prefix, prefix)
+}
+
+// dimensionTag 将 dimension 的范围扩大到第 col 列，无法解析时原样返回
+func dimensionTag(raw []byte, t xml.StartElement, col int) []byte {
+	for _, attr := range t.Attr {
+		if attr.Name.Local != "ref" {
+			continue
+		}
+		cells := strings.Split(attr.Value, ":")
+		endCol, endRow, err := excelize.CellNameToCoordinates(cells[len(cells)-1])
+		if err != nil || endCol >= col {
+			return raw
+		}
+		ref, err := excelize.CoordinatesToCellName(col, endRow)
+		if err != nil {
+			return raw
+		}
+		return bytes.Replace(raw, []byte(`"`+attr.Value+`"`), []byte(`"`+cells[0]+":"+ref+`"`), 1)
+	}
+	return raw
+}
@@ -0,0 +1,26 @@
+// 为同源的修改类请求（POST、DELETE 等）自动带上 CSRF 令牌：服务端在 csrf_token Cookie 中下发令牌，
+// 这里读取后放入 X-CSRF-Token 请求头。需在页面中其他脚本之前加载
+(function () {
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]+)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    const originalFetch = window.fetch;
+    window.fetch = function (input, init) {
+        init = init || {};
+        const request = input instanceof Request ? input : null;
+        const method = (init.method || (request ? request.method : 'GET')).toUpperCase();
+        const url = new URL(request ? request.url : input, window.location.href);
+
+        if (!['GET', 'HEAD', 'OPTIONS'].includes(method) && url.origin === window.location.origin) {
+            const headers = new Headers(init.headers || (request ? request.headers : undefined));
+            const token = csrfToken();
+            if (token) {
+                headers.set('X-CSRF-Token', token);
+            }
+            init = Object.assign({}, init, {headers: headers});
+        }
+        return originalFetch.call(this, input, init);
+    };
+})();